@@ -0,0 +1,548 @@
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chsoares/gummy/internal/ui"
+)
+
+// Recursive transfers
+//
+// Directories (and glob matches) travel as a single archive: tar+gzip on Unix
+// targets, zip (Compress-Archive / Expand-Archive) on PowerShell. The archive is
+// written to a temp file on the sending side and moves through Upload/Download
+// like any other file, so it never sits in memory and an interrupted transfer
+// resumes. Archive names only depend on the transfer, which is how a second
+// attempt finds the archive it was sending. Archives are built and extracted
+// locally with the standard library, keeping permissions and modification times.
+
+// IsGlob reports whether a remote path contains glob metacharacters
+func IsGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// shQuote quotes a string for sh (single quotes)
+func shQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// treeArchive returns the archive file name of a tree transfer identified by key
+func treeArchive(key, base, ext string) string {
+	if base == "" || IsGlob(base) {
+		base = "files"
+	}
+	sum := sha1.Sum([]byte(key))
+	return fmt.Sprintf("%s_%s.gummy%s", base, hex.EncodeToString(sum[:4]), ext)
+}
+
+// removeCommand returns the remote command that deletes a file (no error if missing)
+func (t *Transferer) removeCommand(path string) string {
+	if t.shell() == "powershell" {
+		return fmt.Sprintf("Remove-Item -Force -LiteralPath %s -ErrorAction SilentlyContinue", psQuote(path))
+	}
+	return fmt.Sprintf("rm -f %s", shQuote(path))
+}
+
+// DownloadTree downloads a remote directory or glob pattern into localDir
+// Directories keep their own name (download -r /etc -> localDir/etc); glob
+// matches keep their full path under localDir
+func (t *Transferer) DownloadTree(ctx context.Context, remotePath, localDir string) error {
+	if t.shell() == "cmd" {
+		return fmt.Errorf("recursive transfers need PowerShell or a Unix shell on the target")
+	}
+
+	absDir, err := filepath.Abs(localDir)
+	if err != nil {
+		return err
+	}
+	ext := ".tgz"
+	if t.shell() == "powershell" {
+		ext = ".zip"
+	}
+	name := treeArchive("download\x00"+remotePath+"\x00"+absDir, remoteBase(remotePath), ext)
+	local := filepath.Join(os.TempDir(), name)
+
+	// The archive goes to the remote temp directory (the source may not be writable),
+	// hidden so it never clashes with the local one when the target is this host
+	var remote, build string
+	if t.shell() == "powershell" {
+		temp, err := t.exec(ctx, "$env:TEMP")
+		if err != nil {
+			return cancelled(ctx, "download", fmt.Errorf("failed to find the remote temp directory: %w", err))
+		}
+		remote = strings.TrimRight(strings.TrimSpace(temp), "\\") + "\\." + name
+		// Compress-Archive expands wildcards in -Path itself
+		build = fmt.Sprintf("Compress-Archive -Path %s -DestinationPath %s -ErrorAction Stop", psQuote(remotePath), psQuote(remote))
+	} else {
+		remote = "/tmp/." + name
+		if IsGlob(remotePath) {
+			// Unquoted so the remote shell expands it; tar strips the leading '/'
+			build = fmt.Sprintf("(set -- %s; [ -e \"$1\" ] && tar czf %s \"$@\" 2>/dev/null; [ -s %s ])",
+				remotePath, shQuote(remote), shQuote(remote))
+		} else {
+			dir := strings.TrimRight(remotePath, "/")
+			build = fmt.Sprintf("(cd \"$(dirname %s)\" 2>/dev/null && [ -e \"$(basename %s)\" ] && tar czf %s \"$(basename %s)\" 2>/dev/null; [ -s %s ])",
+				shQuote(dir), shQuote(dir), shQuote(remote), shQuote(dir), shQuote(remote))
+		}
+	}
+
+	// An interrupted download continues with the archive it left on the target
+	if t.hasTransferState("download", local, remote) {
+		if t.shell() == "powershell" {
+			build = fmt.Sprintf("if (-not (Test-Path -LiteralPath %s)) { %s }", psQuote(remote), build)
+		} else {
+			build = fmt.Sprintf("[ -s %s ] || %s", shQuote(remote), build)
+		}
+	} else {
+		build = t.removeCommand(remote) + "; " + build
+	}
+	if _, err := t.exec(ctx, build); err != nil {
+		if ctx.Err() != nil {
+			return cancelled(ctx, "download", err)
+		}
+		t.cleanup(t.removeCommand(remote))
+		return fmt.Errorf("nothing found at %s", remotePath)
+	}
+
+	// On failure the remote archive stays for the next attempt
+	if err := t.Download(ctx, remote, local); err != nil {
+		return err
+	}
+	t.cleanup(t.removeCommand(remote))
+
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", localDir, err)
+	}
+	files, err := extractArchive(local, localDir)
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", local, err)
+	}
+	os.Remove(local)
+
+	fmt.Println(ui.Success(fmt.Sprintf("%d file(s) extracted to: %s", files, localDir)))
+	return nil
+}
+
+// UploadTree uploads the contents of a local directory into remoteDir (created if needed)
+func (t *Transferer) UploadTree(ctx context.Context, localDir, remoteDir string) error {
+	info, err := os.Stat(localDir)
+	if err != nil {
+		return fmt.Errorf("failed to read local directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("not a directory: %s", localDir)
+	}
+	absDir, err := filepath.Abs(localDir)
+	if err != nil {
+		return err
+	}
+	if remoteDir == "" {
+		remoteDir = filepath.Base(absDir)
+	}
+
+	var (
+		pack    func(io.Writer, string) error
+		ext     string
+		archive string
+		extract string
+	)
+	switch t.shell() {
+	case "powershell":
+		pack, ext = packZip, ".zip"
+		archive = remoteDir + ".gummy.zip"
+		extract = fmt.Sprintf("& { try { Expand-Archive -Force -LiteralPath %s -DestinationPath %s -ErrorAction Stop } "+
+			"finally { Remove-Item -Force -LiteralPath %s -ErrorAction SilentlyContinue } }",
+			psQuote(archive), psQuote(remoteDir), psQuote(archive))
+	case "cmd":
+		return fmt.Errorf("recursive transfers need PowerShell or a Unix shell on the target")
+	default:
+		pack, ext = packTarGz, ".tgz"
+		archive = strings.TrimRight(remoteDir, "/") + ".gummy.tgz"
+		extract = fmt.Sprintf("(mkdir -p %s && tar xzf %s -C %s; rc=$?; rm -f %s; exit $rc)",
+			shQuote(remoteDir), shQuote(archive), shQuote(remoteDir), shQuote(archive))
+	}
+
+	// An interrupted upload continues with the archive it was sending
+	local := filepath.Join(os.TempDir(), treeArchive("upload\x00"+absDir+"\x00"+remoteDir, filepath.Base(absDir), ext))
+	if _, err := os.Stat(local); err != nil || !t.hasTransferState("upload", local, archive) {
+		if err := packFile(local, localDir, pack); err != nil {
+			return fmt.Errorf("failed to pack %s: %w", localDir, err)
+		}
+	}
+
+	// On failure the local archive stays for the next attempt
+	if err := t.Upload(ctx, local, archive); err != nil {
+		return err
+	}
+	os.Remove(local)
+
+	if _, err := t.exec(ctx, extract); err != nil {
+		t.cleanup(t.removeCommand(archive))
+		return cancelled(ctx, "upload", fmt.Errorf("failed to extract archive on target: %w", err))
+	}
+
+	fmt.Println(ui.Success(fmt.Sprintf("Extracted into %s", remoteDir)))
+	return nil
+}
+
+// packFile writes the archive of dir to path
+func packFile(path, dir string, pack func(io.Writer, string) error) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := pack(f, dir); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// packTarGz archives the contents of dir into w (paths relative to dir)
+func packTarGz(w io.Writer, dir string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(tw, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// packZip archives the contents of dir into w (paths relative to dir)
+// Symlinks are followed: Expand-Archive can't recreate them anyway
+func packZip(w io.Writer, dir string) error {
+	zw := zip.NewWriter(w)
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		} else {
+			header.Method = zip.Deflate
+		}
+
+		entry, err := zw.CreateHeader(header)
+		if err != nil || info.IsDir() {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(entry, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// extractPath maps an archive entry name to a path inside root
+// Absolute names and ".." can't escape root
+func extractPath(root, name string) string {
+	return filepath.Join(root, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// checkNoSymlinks fails if p, or a directory between root and p, is a symlink
+// (left by an earlier download), so nothing is written through it outside root
+func checkNoSymlinks(root, p string) error {
+	root = filepath.Clean(root)
+	rel, err := filepath.Rel(root, p)
+	if err != nil || rel == "." {
+		return err
+	}
+
+	current := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to write through symlink %s", current)
+		}
+	}
+	return nil
+}
+
+// safeLinkTarget reports whether a symlink at link pointing to target stays inside root
+// Targets must be relative and may only climb with leading "..": a ".." after
+// another component could go through a link and climb further than it looks
+func safeLinkTarget(root, link, target string) bool {
+	if target == "" || path.IsAbs(target) || filepath.IsAbs(target) {
+		return false
+	}
+
+	climbing := true
+	for _, part := range strings.Split(target, "/") {
+		switch {
+		case part == "" || part == ".":
+		case part == "..":
+			if !climbing {
+				return false
+			}
+		default:
+			climbing = false
+		}
+	}
+
+	rel, err := filepath.Rel(filepath.Clean(root), filepath.Join(filepath.Dir(link), filepath.FromSlash(target)))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// extractArchive extracts the archive file at path (zip or tar.gz, by extension) into root
+// Returns the number of regular files written
+func extractArchive(path, root string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(path, ".zip") {
+		info, err := f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return extractZip(f, info.Size(), root)
+	}
+	return extractTarGz(f, root)
+}
+
+// extractTarGz extracts a tar.gz archive into root, keeping modes and mtimes
+// Returns the number of regular files written
+func extractTarGz(r io.Reader, root string) (int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	type symlink struct{ path, target string }
+	type dirAttrs struct {
+		path  string
+		mode  os.FileMode
+		mtime time.Time
+	}
+	var links []symlink
+	var dirs []dirAttrs
+	files := 0
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return files, err
+		}
+
+		target := extractPath(root, header.Name)
+		if target == filepath.Clean(root) {
+			continue
+		}
+		mode := header.FileInfo().Mode().Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := checkNoSymlinks(root, target); err != nil {
+				return files, err
+			}
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return files, err
+			}
+			// Must stay writable while extracting, the real mode is applied at the end
+			os.Chmod(target, mode|0700)
+			dirs = append(dirs, dirAttrs{target, mode, header.ModTime})
+		case tar.TypeReg:
+			if err := checkNoSymlinks(root, filepath.Dir(target)); err != nil {
+				return files, err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return files, err
+			}
+			os.Remove(target) // Might be a symlink from a previous download
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode|0600)
+			if err != nil {
+				return files, err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return files, err
+			}
+			os.Chmod(target, mode)
+			os.Chtimes(target, header.ModTime, header.ModTime)
+			files++
+		case tar.TypeSymlink:
+			// Links that could point outside root are skipped
+			if !safeLinkTarget(root, target, header.Linkname) {
+				continue
+			}
+			// Created last so no file can be written through them
+			links = append(links, symlink{target, header.Linkname})
+		}
+	}
+
+	for _, link := range links {
+		// An earlier link may have replaced one of its directories
+		if err := checkNoSymlinks(root, filepath.Dir(link.path)); err != nil {
+			return files, err
+		}
+		os.MkdirAll(filepath.Dir(link.path), 0755)
+		os.Remove(link.path)
+		os.Symlink(link.target, link.path)
+	}
+
+	// Writing files touches their directories, so directory attributes go last (deepest first)
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Chmod(dirs[i].path, dirs[i].mode)
+		os.Chtimes(dirs[i].path, dirs[i].mtime, dirs[i].mtime)
+	}
+
+	return files, nil
+}
+
+// extractZip extracts a zip archive into root, keeping mtimes
+// Returns the number of regular files written
+func extractZip(r io.ReaderAt, size int64, root string) (int, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return 0, err
+	}
+
+	files := 0
+	for _, entry := range zr.File {
+		// Compress-Archive may use Windows separators
+		name := strings.ReplaceAll(entry.Name, "\\", "/")
+		target := extractPath(root, name)
+		if target == filepath.Clean(root) {
+			continue
+		}
+
+		if strings.HasSuffix(name, "/") {
+			if err := checkNoSymlinks(root, target); err != nil {
+				return files, err
+			}
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return files, err
+			}
+			continue
+		}
+
+		if err := checkNoSymlinks(root, filepath.Dir(target)); err != nil {
+			return files, err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return files, err
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			return files, err
+		}
+		os.Remove(target)
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			rc.Close()
+			return files, err
+		}
+		_, err = io.Copy(f, rc)
+		rc.Close()
+		f.Close()
+		if err != nil {
+			return files, err
+		}
+		os.Chtimes(target, entry.Modified, entry.Modified)
+		files++
+	}
+
+	return files, nil
+}
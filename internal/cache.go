@@ -0,0 +1,504 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chsoares/gummy/internal/ui"
+)
+
+// Module cache
+//
+// Scripts and binaries used by modules are downloaded once into ~/.gummy/cache
+// and reused from there, so modules keep working without internet. The index
+// (index.json) maps each URL to its file and SHA-256; a cached file whose hash
+// doesn't match is treated as missing. "modules update" refreshes entries while
+// online, except pinned ones, which keep their SHA-256 until unpinned.
+
+// cacheIndexFile is the name of the cache index inside the cache directory
+const cacheIndexFile = "index.json"
+
+// releaseTagPattern extracts the tag from GitHub release download URLs
+var releaseTagPattern = regexp.MustCompile(`/releases/download/([^/]+)/`)
+
+// CacheEntry describes one cached file
+type CacheEntry struct {
+	URL       string    `json:"url"`
+	File      string    `json:"file"` // Relative to the cache directory
+	SHA256    string    `json:"sha256"`
+	Size      int64     `json:"size"`
+	Version   string    `json:"version"` // Release tag when known, otherwise a hash prefix
+	FetchedAt time.Time `json:"fetched_at"`
+	Pinned    bool      `json:"pinned"`
+}
+
+// Age returns how long ago the entry was fetched, rounded for display
+func (e *CacheEntry) Age() string {
+	d := time.Since(e.FetchedAt)
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
+
+// ModuleCache is a URL-keyed, SHA-256 verified file cache
+type ModuleCache struct {
+	dir string
+	mu  sync.Mutex
+}
+
+var (
+	globalCache     *ModuleCache
+	globalCacheOnce sync.Once
+)
+
+// GetModuleCache returns the cache under ~/.gummy/cache (singleton)
+func GetModuleCache() *ModuleCache {
+	globalCacheOnce.Do(func() {
+		home, _ := os.UserHomeDir()
+		globalCache = NewModuleCache(filepath.Join(home, ".gummy", "cache"))
+	})
+	return globalCache
+}
+
+// NewModuleCache creates a cache stored in dir
+func NewModuleCache(dir string) *ModuleCache {
+	return &ModuleCache{dir: dir}
+}
+
+// FetchModuleFile returns a local path for url, from the cache when possible
+// Built-in module URLs are replaced by their [modules] override, if any
+func FetchModuleFile(url string) (string, error) {
+	return GetModuleCache().Get(moduleURL(url))
+}
+
+// load reads the index (empty if there is none yet)
+func (c *ModuleCache) load() map[string]*CacheEntry {
+	index := make(map[string]*CacheEntry)
+	data, err := os.ReadFile(filepath.Join(c.dir, cacheIndexFile))
+	if err != nil {
+		return index
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		fmt.Println(ui.Warning(fmt.Sprintf("Module cache index is corrupted, starting over: %v", err)))
+		return make(map[string]*CacheEntry)
+	}
+	return index
+}
+
+// save writes the index
+func (c *ModuleCache) save(index map[string]*CacheEntry) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(c.dir, cacheIndexFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(c.dir, cacheIndexFile))
+}
+
+// path returns the absolute path of an entry's file
+func (c *ModuleCache) path(entry *CacheEntry) string {
+	return filepath.Join(c.dir, entry.File)
+}
+
+// verify reports whether the entry's file exists and matches its SHA-256
+func (c *ModuleCache) verify(entry *CacheEntry) bool {
+	sum, err := fileSHA256(c.path(entry))
+	return err == nil && sum == entry.SHA256
+}
+
+// Lookup returns the cached entry for url, if present and intact
+func (c *ModuleCache) Lookup(url string) (*CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.load()[url]
+	if !ok || !c.verify(entry) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// Get returns the cached file for url, downloading it on a cache miss
+func (c *ModuleCache) Get(url string) (string, error) {
+	if entry, ok := c.Lookup(url); ok {
+		fmt.Println(ui.Info(fmt.Sprintf("Using cached %s (%s, %s old)", filepath.Base(entry.URL), entry.Version, entry.Age())))
+		return c.path(entry), nil
+	}
+
+	entry, _, err := c.Fetch(url)
+	if err != nil {
+		return "", err
+	}
+	return c.path(entry), nil
+}
+
+// Fetch downloads url into the cache and returns its entry
+// changed reports whether the content differs from what was cached before.
+// Pinned entries only accept content matching their SHA-256
+func (c *ModuleCache) Fetch(url string) (entry *CacheEntry, changed bool, err error) {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return nil, false, err
+	}
+
+	key := sha256.Sum256([]byte(url))
+	file := hex.EncodeToString(key[:8]) + "-" + sanitizePath(filepath.Base(url))
+
+	tmp := filepath.Join(c.dir, file+".tmp")
+	defer os.Remove(tmp)
+
+	finalURL, err := downloadFile(url, tmp)
+	if err != nil {
+		return nil, false, err
+	}
+	sum, err := fileSHA256(tmp)
+	if err != nil {
+		return nil, false, err
+	}
+	info, err := os.Stat(tmp)
+	if err != nil {
+		return nil, false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	index := c.load()
+	old := index[url]
+	if old != nil && old.Pinned && old.SHA256 != sum {
+		return nil, false, fmt.Errorf("%s is pinned to %s but the download has %s", filepath.Base(url), old.SHA256[:12], sum[:12])
+	}
+
+	if err := os.Rename(tmp, filepath.Join(c.dir, file)); err != nil {
+		return nil, false, err
+	}
+
+	entry = &CacheEntry{
+		URL:       url,
+		File:      file,
+		SHA256:    sum,
+		Size:      info.Size(),
+		Version:   cacheVersion(url, finalURL, sum),
+		FetchedAt: time.Now(),
+	}
+	if old != nil {
+		entry.Pinned = old.Pinned
+		changed = old.SHA256 != sum
+	}
+	index[url] = entry
+	return entry, changed, c.save(index)
+}
+
+// Pin pins url to its cached SHA-256 (or to sha, which must match the cached file)
+func (c *ModuleCache) Pin(url, sha string) (*CacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	index := c.load()
+	entry, ok := index[url]
+	if !ok || !c.verify(entry) {
+		return nil, fmt.Errorf("%s is not cached (run 'modules update' first)", filepath.Base(url))
+	}
+	if sha != "" && !strings.EqualFold(sha, entry.SHA256) {
+		return nil, fmt.Errorf("cached %s has SHA-256 %s, not %s", filepath.Base(url), entry.SHA256[:12], sha)
+	}
+	entry.Pinned = true
+	return entry, c.save(index)
+}
+
+// Unpin lets "modules update" refresh url again
+func (c *ModuleCache) Unpin(url string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	index := c.load()
+	entry, ok := index[url]
+	if !ok {
+		return fmt.Errorf("%s is not cached", filepath.Base(url))
+	}
+	entry.Pinned = false
+	return c.save(index)
+}
+
+// Entries returns all cache entries sorted by file name
+func (c *ModuleCache) Entries() []*CacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var entries []*CacheEntry
+	for _, entry := range c.load() {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return filepath.Base(entries[i].URL) < filepath.Base(entries[j].URL)
+	})
+	return entries
+}
+
+// cacheVersion names the version of a download: the GitHub release tag when the
+// URL (or where "latest" redirected to) has one, otherwise a SHA-256 prefix
+func cacheVersion(url, finalURL, sum string) string {
+	for _, u := range []string{finalURL, url} {
+		if m := releaseTagPattern.FindStringSubmatch(u); m != nil && m[1] != "latest" {
+			return m[1]
+		}
+	}
+	return sum[:12]
+}
+
+// fileSHA256 hashes a local file
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ModuleSources is implemented by modules that run files from fixed URLs
+type ModuleSources interface {
+	Sources() []string
+}
+
+// moduleSourceURLs returns the URLs used by a module (nil for custom modules)
+func moduleSourceURLs(mod Module) []string {
+	src, ok := mod.(ModuleSources)
+	if !ok {
+		return nil
+	}
+	var urls []string
+	for _, url := range src.Sources() {
+		urls = append(urls, moduleURL(url))
+	}
+	return urls
+}
+
+// cacheStatus formats the cache state of a module's files for the listing
+func cacheStatus(urls []string) string {
+	if len(urls) == 0 {
+		return ""
+	}
+
+	cache := GetModuleCache()
+	cached := 0
+	var first *CacheEntry
+	for _, url := range urls {
+		if entry, ok := cache.Lookup(url); ok {
+			cached++
+			if first == nil {
+				first = entry
+			}
+		}
+	}
+
+	switch {
+	case cached == 0:
+		return "[not cached]"
+	case len(urls) > 1:
+		return fmt.Sprintf("[%d/%d cached, %s old]", cached, len(urls), first.Age())
+	}
+
+	pin := ""
+	if first.Pinned {
+		pin = ", pinned"
+	}
+	return fmt.Sprintf("[%s, %s old%s]", first.Version, first.Age(), pin)
+}
+
+// resolveCacheTarget maps a module name or URL to the URLs it covers
+func resolveCacheTarget(target string) ([]string, error) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		return []string{target}, nil
+	}
+
+	mod, ok := GetModuleRegistry().Get(target)
+	if !ok {
+		return nil, fmt.Errorf("unknown module: %s", target)
+	}
+	urls := moduleSourceURLs(mod)
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("module %s has no fixed files to cache", target)
+	}
+	return urls, nil
+}
+
+// allCacheURLs returns the URLs of every built-in module plus anything already cached
+func allCacheURLs() []string {
+	seen := make(map[string]bool)
+	var urls []string
+	add := func(url string) {
+		if !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+
+	for _, mod := range GetModuleRegistry().List() {
+		for _, url := range moduleSourceURLs(mod) {
+			add(url)
+		}
+	}
+	for _, entry := range GetModuleCache().Entries() {
+		add(entry.URL)
+	}
+	return urls
+}
+
+// handleModules handles the modules command
+// modules                           - list modules with their cache state
+// modules update [module|url]       - refresh the cache (everything by default)
+// modules pin <module|url> [sha256] - keep the cached version on update
+// modules unpin <module|url>        - let update refresh it again
+// modules cache                     - list cached files
+// modules reload                    - reload user modules (see usermodules.go)
+func (m *Manager) handleModules(args []string) {
+	if len(args) == 0 {
+		m.handleModulesList()
+		return
+	}
+
+	cache := GetModuleCache()
+
+	switch args[0] {
+	case "update":
+		urls := allCacheURLs()
+		if len(args) > 1 {
+			var err error
+			if urls, err = resolveCacheTarget(args[1]); err != nil {
+				fmt.Println(ui.Error(err.Error()))
+				return
+			}
+		}
+
+		updated, failed := 0, 0
+		for _, url := range urls {
+			if entry, ok := cache.Lookup(url); ok && entry.Pinned {
+				fmt.Println(ui.Info(fmt.Sprintf("Skipping pinned %s (%s)", filepath.Base(url), entry.Version)))
+				continue
+			}
+			entry, changed, err := cache.Fetch(url)
+			if err != nil {
+				fmt.Println(ui.Error(fmt.Sprintf("%s: %v", filepath.Base(url), err)))
+				failed++
+				continue
+			}
+			if changed {
+				fmt.Println(ui.Success(fmt.Sprintf("%s updated to %s", filepath.Base(url), entry.Version)))
+			}
+			updated++
+		}
+
+		if failed > 0 {
+			fmt.Println(ui.Warning(fmt.Sprintf("Module cache: %d refreshed, %d failed (offline?)", updated, failed)))
+		} else {
+			fmt.Println(ui.Success(fmt.Sprintf("Module cache: %d file(s) refreshed", updated)))
+		}
+	case "pin":
+		if len(args) < 2 {
+			fmt.Println(ui.CommandHelp("Usage: modules pin <module|url> [sha256]"))
+			return
+		}
+		urls, err := resolveCacheTarget(args[1])
+		if err != nil {
+			fmt.Println(ui.Error(err.Error()))
+			return
+		}
+		sha := ""
+		if len(args) > 2 {
+			if len(urls) > 1 {
+				fmt.Println(ui.Error("A SHA-256 can only be given for a single file"))
+				return
+			}
+			sha = args[2]
+		}
+		for _, url := range urls {
+			entry, err := cache.Pin(url, sha)
+			if err != nil {
+				fmt.Println(ui.Error(err.Error()))
+				continue
+			}
+			fmt.Println(ui.Success(fmt.Sprintf("%s pinned to %s (sha256 %s)", filepath.Base(url), entry.Version, entry.SHA256[:12])))
+		}
+	case "unpin":
+		if len(args) < 2 {
+			fmt.Println(ui.CommandHelp("Usage: modules unpin <module|url>"))
+			return
+		}
+		urls, err := resolveCacheTarget(args[1])
+		if err != nil {
+			fmt.Println(ui.Error(err.Error()))
+			return
+		}
+		for _, url := range urls {
+			if err := cache.Unpin(url); err != nil {
+				fmt.Println(ui.Error(err.Error()))
+				continue
+			}
+			fmt.Println(ui.Success(fmt.Sprintf("%s unpinned", filepath.Base(url))))
+		}
+	case "cache":
+		m.ShowModuleCache()
+	case "reload":
+		loaded, errs := GetModuleRegistry().ReloadUserModules(UserModuleDir())
+		for _, err := range errs {
+			fmt.Println(ui.Warning(fmt.Sprintf("Skipping user module %v", err)))
+		}
+		fmt.Println(ui.Success(fmt.Sprintf("Loaded %d user module(s) from %s", loaded, UserModuleDir())))
+	default:
+		fmt.Println(ui.CommandHelp("Usage: modules [update [module|url] | pin <module|url> [sha256] | unpin <module|url> | cache | reload]"))
+	}
+}
+
+// ShowModuleCache prints the cached files
+func (m *Manager) ShowModuleCache() {
+	cache := GetModuleCache()
+	entries := cache.Entries()
+	if len(entries) == 0 {
+		fmt.Println(ui.Info("Module cache is empty (run 'modules update' while online)"))
+		return
+	}
+
+	var lines []string
+	lines = append(lines, ui.TableHeader("file                   version          age   size      sha256        pinned"))
+	for _, entry := range entries {
+		pinned := ""
+		if entry.Pinned {
+			pinned = "yes"
+		}
+		if !cache.verify(entry) {
+			pinned = "CORRUPTED"
+		}
+		lines = append(lines, ui.Command(fmt.Sprintf("%-22s %-16s %-5s %-9s %-13s %s",
+			filepath.Base(entry.URL), entry.Version, entry.Age(), formatBytes(entry.Size), entry.SHA256[:12], pinned)))
+	}
+	lines = append(lines, "")
+	lines = append(lines, ui.CommandHelp(cache.dir))
+
+	fmt.Println(ui.BoxWithTitle(fmt.Sprintf("%s Module Cache", ui.SymbolGem), lines))
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/ulikunitz/xz"
+)
+
+// Compressed transfers
+//
+// Chunks of resumable transfers are compressed before base64 when the target can
+// undo it: gzip (or xz when gzip is missing) on Unix, .NET GZipStream on
+// PowerShell. cmd.exe has nothing usable and transfers stay plain base64.
+// Checksums are always computed over the uncompressed bytes.
+
+// Codecs
+const (
+	codecNone = ""
+	codecGzip = "gzip"
+	codecXz   = "xz"
+)
+
+// codec returns the compression used with the target (codecNone if there is none)
+func (t *Transferer) codec() string {
+	switch t.shell() {
+	case "powershell":
+		return codecGzip
+	case "cmd":
+		return codecNone
+	}
+
+	if t.facts.HasTool("gzip") {
+		return codecGzip
+	}
+	if t.facts.HasTool("xz") {
+		return codecXz
+	}
+	return codecNone
+}
+
+// compressStdin returns the sh filter that compresses stdin with codec
+func compressStdin(codec string) string {
+	if codec == codecXz {
+		return "xz -c"
+	}
+	return "gzip -c"
+}
+
+// decompressStdin returns the sh filter that decompresses stdin with codec
+func decompressStdin(codec string) string {
+	if codec == codecXz {
+		return "xz -dc"
+	}
+	return "gzip -dc"
+}
+
+// psCompress is PowerShell that prints $b[0..$n] gzipped as base64
+const psCompress = "$m = New-Object IO.MemoryStream; " +
+	"$z = New-Object IO.Compression.GZipStream($m, [IO.Compression.CompressionMode]::Compress); " +
+	"$z.Write($b, 0, $n); $z.Close(); [Convert]::ToBase64String($m.ToArray())"
+
+// psDecompress is PowerShell that replaces the gzipped $b with its contents
+// (read loop instead of CopyTo, which needs .NET 4)
+const psDecompress = "$z = New-Object IO.Compression.GZipStream((New-Object IO.MemoryStream(,$b)), [IO.Compression.CompressionMode]::Decompress); " +
+	"$m = New-Object IO.MemoryStream; $t = New-Object byte[] 65536; " +
+	"while (($r = $z.Read($t, 0, $t.Length)) -gt 0) { $m.Write($t, 0, $r) }; $z.Close(); $b = $m.ToArray()"
+
+// compressBytes compresses data with codec
+func compressBytes(codec string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+
+	switch codec {
+	case codecGzip:
+		w = gzip.NewWriter(&buf)
+	case codecXz:
+		xw, err := xz.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		w = xw
+	default:
+		return data, nil
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressBytes decompresses data compressed with codec
+func decompressBytes(codec string, data []byte) ([]byte, error) {
+	var r io.Reader
+
+	switch codec {
+	case codecGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	case codecXz:
+		xr, err := xz.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("xz: %w", err)
+		}
+		r = xr
+	default:
+		return data, nil
+	}
+
+	return io.ReadAll(r)
+}
@@ -0,0 +1,309 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/chsoares/gummy/internal/ui"
+)
+
+// Control channels
+//
+// Right after a session is detected gummy spawns a second shell from it in the
+// background. The new shell calls back announcing "GUMMY:<token>\n" (the same
+// handshake port forward relays use), so the listener hands it over as the
+// control channel of the first session instead of opening a new session.
+// Uploads, downloads, modules and relay helpers run over the control channel
+// and the interactive shell is left alone. Without one, everything falls back
+// to the session's own mux.
+
+// controlTokenPrefix prefixes the callback tokens of control channels
+const controlTokenPrefix = "ctl"
+
+// ControlSession returns the session's control channel, or the session itself
+// when there is none (not spawned yet, disabled or closed)
+func (s *SessionInfo) ControlSession() *SessionInfo {
+	s.ctlMu.Lock()
+	defer s.ctlMu.Unlock()
+
+	if s.control == nil || s.control.Mux.Closed() {
+		return s
+	}
+	return s.control
+}
+
+// HasControl reports whether the session has a live control channel
+func (s *SessionInfo) HasControl() bool {
+	return s.ControlSession() != s
+}
+
+// resizeTimeout bounds a resize over the control channel (a transfer may hold it)
+const resizeTimeout = 2 * time.Second
+
+// resizeTTY sets the size of the session's upgraded PTY from the control channel
+// Changing the size makes the kernel SIGWINCH the foreground process group;
+// it's also signalled explicitly for systems that don't. Nothing is typed into
+// the interactive shell, so vim/less/htop only see the new size
+func (s *SessionInfo) resizeTTY(tty string, width, height int) error {
+	if !s.HasControl() {
+		return fmt.Errorf("no control channel, see 'control on'")
+	}
+
+	// GNU stty takes the device with -F, BSD/macOS with -f
+	flag := "-F"
+	if platform := s.Facts().OS; platform == "macos" || strings.HasSuffix(platform, "bsd") || platform == "dragonfly" {
+		flag = "-f"
+	}
+
+	cmd := fmt.Sprintf("stty %s %s rows %d cols %d && { pg=$(ps -o tpgid= -t %s 2>/dev/null | awk 'NR==1{print $1}'); [ -n \"$pg\" ] && kill -WINCH -- -$pg 2>/dev/null; true; }",
+		flag, shQuote(tty), height, width, shQuote(strings.TrimPrefix(tty, "/dev/")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), resizeTimeout)
+	defer cancel()
+	_, code, err := s.ControlSession().Mux.Exec(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("stty failed on %s", tty)
+	}
+	return nil
+}
+
+// closeControl closes the session's control channel, if any
+func (s *SessionInfo) closeControl() {
+	s.ctlMu.Lock()
+	ctl := s.control
+	s.control = nil
+	s.ctlMu.Unlock()
+
+	if ctl != nil {
+		ctl.Conn.Close()
+	}
+}
+
+// SetAutoControl enables/disables spawning control channels for new sessions
+// (the "control" setting)
+func (m *Manager) SetAutoControl(enabled bool) {
+	s := CurrentSettings()
+	s.Control = enabled
+	ApplySettings(s, SettingsProfile())
+}
+
+// controlOpener is implemented by session connections that open the control channel
+// as a second channel of their own login (SSH, WinRM) instead of calling back
+type controlOpener interface {
+	OpenControl(command string) (net.Conn, error)
+}
+
+// psStdinLoop runs the PowerShell statements read from stdin, streaming their output
+// Lines are collected until they parse as complete statements (multi-line scripts)
+const psStdinLoop = "$data = '';while(($line = [Console]::In.ReadLine()) -ne $null){$data += $line + [char]10;$errs = $null;[void][System.Management.Automation.Language.Parser]::ParseInput($data,[ref]$null,[ref]$errs);if($errs | Where-Object {$_.IncompleteInput}){continue};iex $data 2>&1 | Out-String -Stream | ForEach-Object {[Console]::Out.WriteLine($_);[Console]::Out.Flush()};$data = ''}"
+
+// nativeOpener returns the session's connection if it opens its own control channels
+func nativeOpener(session *SessionInfo) (controlOpener, bool) {
+	opener, ok := session.Mux.conn.(controlOpener)
+	return opener, ok
+}
+
+// controlSupported reports whether we know how to spawn a control channel from session
+// Sessions that didn't come through a listener (bind shells) have nothing to call back to
+func controlSupported(session *SessionInfo) bool {
+	if _, native := nativeOpener(session); !native && session.Listener == nil {
+		return false
+	}
+	switch session.Platform() {
+	case "linux", "macos", "windows":
+		return true
+	}
+	return false
+}
+
+// spawnControl opens a control channel for session through spawnFrom
+// (or on the session's own connection for SSH and WinRM)
+// Failures are not fatal: the session keeps using its own mux
+func (m *Manager) spawnControl(session *SessionInfo) error {
+	opener, native := nativeOpener(session)
+	if !native && session.Listener == nil {
+		return fmt.Errorf("session has no listener to call back to")
+	}
+	if !controlSupported(session) {
+		return fmt.Errorf("unsupported platform: %s", session.Platform())
+	}
+
+	// SSH and WinRM: a second channel on the same login, without a PTY
+	if native {
+		command := "sh"
+		if session.Platform() == "windows" {
+			command = "powershell -nop -noni -e " + base64.StdEncoding.EncodeToString(encodeUTF16LE(psStdinLoop))
+		}
+		conn, err := opener.OpenControl(command)
+		if err != nil {
+			return err
+		}
+		m.attachControl(session, conn)
+		return nil
+	}
+
+	token := controlTokenPrefix + generateSessionID()[:12]
+	arrived := make(chan struct{})
+
+	m.registerRelay(token, func(conn net.Conn) {
+		m.unregisterRelay(token)
+		m.attachControl(session, conn)
+		close(arrived)
+	})
+
+	if _, err := m.spawnFrom(session, 0, token); err != nil {
+		m.unregisterRelay(token)
+		return err
+	}
+
+	select {
+	case <-arrived:
+		return nil
+	case <-time.After(relayTimeout):
+		m.unregisterRelay(token)
+		return fmt.Errorf("control channel did not call back")
+	}
+}
+
+// attachControl pairs a control channel callback with its session
+func (m *Manager) attachControl(session *SessionInfo, conn net.Conn) {
+	mux := NewSessionMux(conn)
+	// Control shells are spawned as bash or powershell, whatever the session runs
+	if session.Platform() == "windows" {
+		mux.SetShell("powershell")
+	} else {
+		mux.SetShell("sh")
+	}
+
+	handler := NewHandler(mux, session.ID)
+	handler.SetPlatform(session.Platform())
+
+	ctl := &SessionInfo{
+		ID:        session.ID,
+		NumID:     session.NumID,
+		Conn:      mux,
+		Mux:       mux,
+		RemoteIP:  conn.RemoteAddr().String(),
+		Listener:  session.Listener,
+		manager:   m,
+		Handler:   handler,
+		CreatedAt: time.Now(),
+	}
+	ctl.setFacts(session.Facts())
+
+	// The session may have died while the control shell was calling back
+	m.mu.RLock()
+	_, alive := m.sessions[session.ID]
+	m.mu.RUnlock()
+	if !alive {
+		conn.Close()
+		return
+	}
+
+	session.ctlMu.Lock()
+	old := session.control
+	session.control = ctl
+	session.ctlMu.Unlock()
+	if old != nil {
+		old.Conn.Close()
+	}
+
+	// Quando o canal de controle cai, a sessão volta a usar o próprio mux
+	go func() {
+		<-mux.Done()
+
+		session.ctlMu.Lock()
+		current := session.control == ctl
+		if current {
+			session.control = nil
+		}
+		session.ctlMu.Unlock()
+
+		if !current {
+			return
+		}
+
+		m.mu.RLock()
+		_, alive := m.sessions[session.ID]
+		m.mu.RUnlock()
+		if alive {
+			m.notify(ui.Warning(fmt.Sprintf("Control channel of session %d closed, using the main shell", session.NumID)))
+		}
+	}()
+}
+
+// handleControl handles the control command
+// control          - show control channels
+// control on|off   - enable/disable control channels (applies to current sessions too)
+func (m *Manager) handleControl(args []string) {
+	if len(args) == 0 {
+		m.ShowControl()
+		return
+	}
+
+	switch args[0] {
+	case "on":
+		m.SetAutoControl(true)
+		fmt.Println(ui.Success("Control channels enabled"))
+
+		spawned := 0
+		for _, session := range m.GetAllSessions() {
+			// Sessions still being detected get theirs from AddSession
+			if session.HasControl() || !session.Detected() || !controlSupported(session) {
+				continue
+			}
+			spawned++
+			go func(session *SessionInfo) {
+				if err := m.spawnControl(session); err != nil {
+					m.notify(ui.Warning(fmt.Sprintf("No control channel for session %d: %v", session.NumID, err)))
+				}
+			}(session)
+		}
+		if spawned > 0 {
+			fmt.Println(ui.Info(fmt.Sprintf("Spawning control channels for %d session(s)...", spawned)))
+		}
+	case "off":
+		m.SetAutoControl(false)
+		for _, session := range m.GetAllSessions() {
+			session.closeControl()
+		}
+		fmt.Println(ui.Success("Control channels disabled"))
+	default:
+		fmt.Println(ui.CommandHelp("Usage: control [on|off]"))
+	}
+}
+
+// ShowControl prints which sessions are paired with a control channel
+func (m *Manager) ShowControl() {
+	enabled := CurrentSettings().Control
+
+	state := "disabled"
+	if enabled {
+		state = "enabled"
+	}
+
+	var lines []string
+	lines = append(lines, ui.CommandHelp(fmt.Sprintf("Control channels %s", state)))
+
+	sessions := m.GetAllSessions()
+	if len(sessions) > 0 {
+		lines = append(lines, "")
+		lines = append(lines, ui.TableHeader("id  remote address     control channel"))
+		for _, session := range sessions {
+			control := "-"
+			if ctl := session.ControlSession(); ctl != session {
+				control = ctl.RemoteIP
+			}
+			lines = append(lines, ui.Command(fmt.Sprintf("%-3d %-18s %s", session.NumID, session.RemoteIP, control)))
+		}
+	}
+
+	fmt.Println(ui.BoxWithTitle(fmt.Sprintf("%s Control Channels", ui.SymbolGem), lines))
+}
@@ -12,6 +12,12 @@ import (
 
 // DownloadFile downloads a file from URL with progress indication
 func DownloadFile(url, destPath string) error {
+	_, err := downloadFile(url, destPath)
+	return err
+}
+
+// downloadFile is DownloadFile, also returning the URL after redirects
+func downloadFile(url, destPath string) (string, error) {
 	spinner := ui.NewSpinner()
 	spinner.Start(fmt.Sprintf("Downloading %s...", filepath.Base(url)))
 	defer spinner.Stop()
@@ -19,18 +25,18 @@ func DownloadFile(url, destPath string) error {
 	// Make HTTP request
 	resp, err := http.Get(url)
 	if err != nil {
-		return fmt.Errorf("failed to download: %w", err)
+		return "", fmt.Errorf("failed to download: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
+		return "", fmt.Errorf("bad status: %s", resp.Status)
 	}
 
 	// Create destination file
 	out, err := os.Create(destPath)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return "", fmt.Errorf("failed to create file: %w", err)
 	}
 	defer out.Close()
 
@@ -58,7 +64,7 @@ func DownloadFile(url, destPath string) error {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("download interrupted: %w", err)
+			return "", fmt.Errorf("download interrupted: %w", err)
 		}
 	}
 
@@ -66,9 +72,9 @@ func DownloadFile(url, destPath string) error {
 
 	// Format size
 	sizeStr := formatBytes(downloaded)
-	fmt.Println(ui.Success(fmt.Sprintf("Downloaded %s (%s)", filepath.Base(destPath), sizeStr)))
+	fmt.Println(ui.Success(fmt.Sprintf("Downloaded %s (%s)", filepath.Base(url), sizeStr)))
 
-	return nil
+	return resp.Request.URL.String(), nil
 }
 
 // formatBytes formats bytes into human-readable string
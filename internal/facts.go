@@ -0,0 +1,369 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chsoares/gummy/internal/ui"
+)
+
+// HostFacts describes the target of a session, filled by probeHostFacts on connect
+type HostFacts struct {
+	OS         string          // Family: linux, macos, windows (or uname -s for other unixes)
+	Distro     string          // ubuntu, debian, alpine, "Microsoft Windows 10 Pro"...
+	Version    string          // Distro / Windows release (22.04, 22H2...)
+	Kernel     string          // uname -r, or the Windows build
+	Arch       string          // Normalized: amd64, 386, arm64, arm (raw value if unknown)
+	Shell      string          // bash, sh, zsh, dash, cmd, powershell...
+	User       string          // Current user
+	Hostname   string          // Host name
+	UID        int             // -1 when unknown (Windows)
+	Privileged bool            // root or elevated Administrator
+	Tools      map[string]bool // Helpers found on the target (see probeTools)
+}
+
+// probeTools are the helpers whose availability is recorded in HostFacts.Tools
+var probeTools = []string{"python3", "python", "perl", "socat", "curl", "wget", "base64", "nc", "gzip", "xz"}
+
+// probeToolsWindows are the Windows counterparts (certutil stands in for base64)
+var probeToolsWindows = []string{"python", "python3", "perl", "socat", "curl", "wget", "certutil"}
+
+// unknownFacts returns facts for a target we couldn't probe
+func unknownFacts() HostFacts {
+	return HostFacts{OS: "unknown", UID: -1, Tools: make(map[string]bool)}
+}
+
+// HasTool reports whether any of the given helpers exists on the target
+func (f HostFacts) HasTool(names ...string) bool {
+	for _, name := range names {
+		if f.Tools[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// Python returns the python interpreter available on the target ("" if none)
+func (f HostFacts) Python() string {
+	for _, name := range []string{"python3", "python"} {
+		if f.Tools[name] {
+			return name
+		}
+	}
+	return ""
+}
+
+// ShellFamily returns the mux marker syntax for the shell ("sh", "powershell" or "cmd")
+func (f HostFacts) ShellFamily() string {
+	switch f.Shell {
+	case "powershell", "cmd":
+		return f.Shell
+	}
+	return "sh"
+}
+
+// Whoami returns user@hostname (or "unknown")
+func (f HostFacts) Whoami() string {
+	if f.User == "" || f.Hostname == "" {
+		return "unknown"
+	}
+	return f.User + "@" + f.Hostname
+}
+
+// System returns a short OS description for the sessions table
+func (f HostFacts) System() string {
+	switch {
+	case f.OS == "windows" && f.Kernel != "":
+		return "windows " + f.Kernel
+	case f.Distro != "" && f.Version != "":
+		return f.Distro + " " + f.Version
+	case f.Distro != "":
+		return f.Distro
+	}
+	return f.OS
+}
+
+// ToolList returns the available helpers, sorted
+func (f HostFacts) ToolList() []string {
+	var tools []string
+	for name, ok := range f.Tools {
+		if ok {
+			tools = append(tools, name)
+		}
+	}
+	sort.Strings(tools)
+	return tools
+}
+
+// normalizeArch maps uname -m / PROCESSOR_ARCHITECTURE values to GOARCH-style names
+func normalizeArch(arch string) string {
+	switch strings.ToLower(strings.TrimSpace(arch)) {
+	case "x86_64", "amd64", "x64":
+		return "amd64"
+	case "i386", "i486", "i586", "i686", "x86":
+		return "386"
+	case "aarch64", "arm64", "armv8l":
+		return "arm64"
+	case "armv5l", "armv6l", "armv7l", "arm":
+		return "arm"
+	}
+	return strings.TrimSpace(arch)
+}
+
+// identifyShell asks the remote shell to identify itself with a polyglot echo
+// sh expands $0 (bash, zsh, ...), cmd expands %OS%, PowerShell expands neither
+// Returns "" if nothing recognizable came back
+func identifyShell(mux *SessionMux, timeout time.Duration) string {
+	marker := "GUMMY_ID_" + generateSessionID()[:8] + "_"
+	if _, err := mux.Write([]byte("echo " + marker + "%OS%_$0\r\n")); err != nil {
+		return ""
+	}
+
+	output := ""
+	buffer := make([]byte, 4096)
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		mux.SetReadDeadline(deadline)
+		n, err := mux.Read(buffer)
+		output += string(buffer[:n])
+
+		// Only complete lines: the answer may still be arriving
+		lines := strings.Split(output, "\n")
+		for _, line := range lines[:len(lines)-1] {
+			// The echoed command line doesn't count, only its output
+			idx := strings.Index(line, marker)
+			if idx < 0 || strings.Contains(line, "echo ") {
+				continue
+			}
+
+			rest := strings.TrimSpace(line[idx+len(marker):])
+			mux.SetReadDeadline(time.Time{})
+			switch {
+			case strings.HasPrefix(rest, "Windows_NT"):
+				return "cmd"
+			case rest == "%OS%_":
+				return "powershell"
+			case strings.HasPrefix(rest, "%OS%_"):
+				// $0 may be "-bash" (login shell) or a path
+				shell := path.Base(strings.TrimPrefix(rest[len("%OS%_"):], "-"))
+				if shell == "" || shell == "." {
+					shell = "sh"
+				}
+				return shell
+			}
+		}
+
+		if err != nil && n == 0 {
+			break
+		}
+	}
+
+	mux.SetReadDeadline(time.Time{})
+	return ""
+}
+
+// guessShellFromPrompt is the fallback when the shell didn't answer identifyShell
+func guessShellFromPrompt(prompt string) string {
+	switch {
+	case strings.Contains(prompt, "PS "):
+		return "powershell"
+	case strings.Contains(prompt, "C:\\") || strings.Contains(prompt, "C:/"):
+		return "cmd"
+	case strings.Contains(prompt, "$") || strings.Contains(prompt, "#"):
+		return "sh"
+	}
+	return ""
+}
+
+// factsCommand returns the probe command for a shell family
+// Every fact comes back as a key=value line
+func factsCommand(family string) string {
+	switch family {
+	case "powershell":
+		tools := "'" + strings.Join(probeToolsWindows, "','") + "'"
+		// Script block keeps our variables out of the user's session
+		return "& { $id = [Security.Principal.WindowsIdentity]::GetCurrent(); " +
+			"\"user=$env:USERNAME\"; \"host=$env:COMPUTERNAME\"; " +
+			"\"arch=\" + $(if ($env:PROCESSOR_ARCHITEW6432) { $env:PROCESSOR_ARCHITEW6432 } else { $env:PROCESSOR_ARCHITECTURE }); " +
+			"\"kernel=\" + [Environment]::OSVersion.Version; " +
+			"\"distro=\" + (Get-CimInstance Win32_OperatingSystem -ErrorAction SilentlyContinue).Caption; " +
+			"\"version=\" + (Get-ItemProperty 'HKLM:\\SOFTWARE\\Microsoft\\Windows NT\\CurrentVersion' -ErrorAction SilentlyContinue).DisplayVersion; " +
+			"\"admin=\" + ([Security.Principal.WindowsPrincipal]$id).IsInRole([Security.Principal.WindowsBuiltInRole]::Administrator); " +
+			"foreach ($t in " + tools + ") { if (Get-Command $t -CommandType Application -ErrorAction SilentlyContinue) { \"tool=$t\" } } }"
+	case "cmd":
+		// The for loop is parenthesized so the end marker isn't part of its body
+		return "echo user=%USERNAME% & echo host=%COMPUTERNAME% & echo arch=%PROCESSOR_ARCHITECTURE% & ver & " +
+			"(net session >nul 2>&1 && echo admin=True) & " +
+			"(for %t in (" + strings.Join(probeToolsWindows, " ") + ") do @(where %t >nul 2>&1 && echo tool=%t))"
+	}
+
+	// Subshell: sourcing os-release must not leak variables into the user's shell
+	return "(echo \"user=$(id -un 2>/dev/null || whoami 2>/dev/null)\"; echo \"uid=$(id -u 2>/dev/null)\"; " +
+		"echo \"host=$(hostname 2>/dev/null || uname -n 2>/dev/null)\"; " +
+		"echo \"os=$(uname -s 2>/dev/null)\"; echo \"kernel=$(uname -r 2>/dev/null)\"; echo \"arch=$(uname -m 2>/dev/null)\"; " +
+		"if [ -r /etc/os-release ]; then . /etc/os-release; echo \"distro=$ID\"; echo \"version=$VERSION_ID\"; " +
+		"elif command -v sw_vers >/dev/null 2>&1; then echo \"distro=macos\"; echo \"version=$(sw_vers -productVersion)\"; fi; " +
+		"for t in " + strings.Join(probeTools, " ") + "; do command -v $t >/dev/null 2>&1 && echo \"tool=$t\"; done)"
+}
+
+// windowsVersion extracts the build from cmd's "ver" output
+var windowsVersion = regexp.MustCompile(`\[Version ([0-9.]+)\]`)
+
+// parseFacts fills facts from the key=value output of factsCommand
+func parseFacts(facts *HostFacts, output string) {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+
+		if m := windowsVersion.FindStringSubmatch(line); m != nil {
+			facts.Kernel = m[1]
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user":
+			facts.User = value
+		case "host":
+			facts.Hostname = value
+		case "uid":
+			if uid, err := strconv.Atoi(value); err == nil {
+				facts.UID = uid
+				facts.Privileged = uid == 0
+			}
+		case "os":
+			switch strings.ToLower(value) {
+			case "linux":
+				facts.OS = "linux"
+			case "darwin":
+				facts.OS = "macos"
+			case "":
+			default:
+				facts.OS = strings.ToLower(value)
+			}
+		case "kernel":
+			facts.Kernel = value
+		case "arch":
+			facts.Arch = normalizeArch(value)
+		case "distro":
+			facts.Distro = value
+		case "version":
+			facts.Version = value
+		case "admin":
+			facts.Privileged = strings.EqualFold(value, "True")
+		case "tool":
+			facts.Tools[value] = true
+		}
+	}
+
+	// Unexpanded cmd variables mean they weren't set
+	if strings.HasPrefix(facts.Arch, "%") {
+		facts.Arch = ""
+	}
+}
+
+// probeHostFacts fingerprints the shell behind mux and collects host facts
+// prompt is whatever the shell printed on connect (fallback hint for identification)
+// The mux shell syntax is set as a side effect
+func probeHostFacts(mux *SessionMux, prompt string) HostFacts {
+	facts := unknownFacts()
+
+	facts.Shell = identifyShell(mux, 3*time.Second)
+	if facts.Shell == "" {
+		facts.Shell = guessShellFromPrompt(prompt)
+	}
+	if facts.Shell == "cmd" || facts.Shell == "powershell" {
+		facts.OS = "windows"
+	}
+
+	// Shells that didn't identify themselves get sh syntax, the most likely one
+	mux.SetShell(facts.ShellFamily())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	output, _, err := mux.Exec(ctx, factsCommand(facts.ShellFamily()))
+	if err != nil {
+		return facts
+	}
+	parseFacts(&facts, output)
+
+	if facts.Shell == "" && facts.OS != "unknown" {
+		facts.Shell = "sh"
+	}
+	return facts
+}
+
+// handleInfo handles the info command
+// info [id] - show the host facts of a session (default: the selected one)
+func (m *Manager) handleInfo(args []string) {
+	session := m.selectedSession
+	if len(args) > 0 {
+		numID, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println(ui.Error(fmt.Sprintf("Invalid session ID: %s", args[0])))
+			return
+		}
+		session = nil
+		for _, s := range m.GetAllSessions() {
+			if s.NumID == numID {
+				session = s
+				break
+			}
+		}
+		if session == nil {
+			fmt.Println(ui.Error(fmt.Sprintf("Session %d not found", numID)))
+			return
+		}
+	}
+	if session == nil {
+		fmt.Println(ui.Error("No session selected. Use 'use <id>' or 'info <id>'."))
+		return
+	}
+	if !session.Detected() {
+		fmt.Println(ui.Warning(fmt.Sprintf("Session %d is still being detected", session.NumID)))
+		return
+	}
+
+	facts := session.Facts()
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+
+	uid := "-"
+	if facts.UID >= 0 {
+		uid = strconv.Itoa(facts.UID)
+	}
+	privileged := "no"
+	if facts.Privileged {
+		privileged = "yes"
+	}
+
+	lines := []string{
+		ui.Command(fmt.Sprintf("%-11s %s", "whoami", facts.Whoami())),
+		ui.Command(fmt.Sprintf("%-11s %s", "os", orDash(facts.OS))),
+		ui.Command(fmt.Sprintf("%-11s %s", "distro", orDash(strings.TrimSpace(facts.Distro+" "+facts.Version)))),
+		ui.Command(fmt.Sprintf("%-11s %s", "kernel", orDash(facts.Kernel))),
+		ui.Command(fmt.Sprintf("%-11s %s", "arch", orDash(facts.Arch))),
+		ui.Command(fmt.Sprintf("%-11s %s", "shell", orDash(facts.Shell))),
+		ui.Command(fmt.Sprintf("%-11s %s", "uid", uid)),
+		ui.Command(fmt.Sprintf("%-11s %s", "privileged", privileged)),
+		ui.Command(fmt.Sprintf("%-11s %s", "tools", orDash(strings.Join(facts.ToolList(), " ")))),
+	}
+
+	fmt.Println(ui.BoxWithTitle(fmt.Sprintf("%s Session %d Host Facts", ui.SymbolGem, session.NumID), lines))
+}
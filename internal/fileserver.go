@@ -0,0 +1,550 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chsoares/gummy/internal/ui"
+)
+
+// Out-of-band HTTP transfers
+//
+// When the file server is on, uploads are fetched by the target itself from a
+// one-time URL (curl, wget, python, Invoke-WebRequest or certutil) and downloads
+// are pushed back with a PUT/POST to another one-time URL. Only the short fetch
+// command goes through the shell. Each URL carries a random token, works for a
+// single request and expires if it is never used. Targets without a usable
+// client (or that can't reach us) fall back to the in-band transfer.
+
+// fileServerDefaultPort is used by "http on" without a port
+const fileServerDefaultPort = 8000
+
+// fileOfferTTL is how long an unused URL stays valid
+const fileOfferTTL = 10 * time.Minute
+
+// httpConnectTimeout is how long (seconds) the target tries to reach the file server
+// A target that can't reach us fails fast and falls back to the in-band transfer
+const httpConnectTimeout = 5
+
+// errNoHTTPClient means the target has nothing to fetch/push files with
+var errNoHTTPClient = errors.New("no HTTP client on target")
+
+// fetchPython downloads argv[1] into argv[2] (py2/py3), passed base64 encoded via -c
+// argv[3] is the socket timeout
+const fetchPython = `import sys
+try:
+	from urllib.request import urlopen
+except ImportError:
+	from urllib2 import urlopen
+r=urlopen(sys.argv[1],timeout=int(sys.argv[3]))
+f=open(sys.argv[2],'wb')
+while 1:
+	d=r.read(65536)
+	if not d:break
+	f.write(d)
+f.close()`
+
+// pushPython POSTs the file argv[1] to argv[2] (py2/py3), passed base64 encoded via -c
+// argv[3] is the socket timeout
+const pushPython = `import sys,os
+try:
+	from urllib.request import urlopen,Request
+except ImportError:
+	from urllib2 import urlopen,Request
+f=open(sys.argv[1],'rb')
+urlopen(Request(sys.argv[2],f,{'Content-Length':str(os.path.getsize(sys.argv[1])),'Content-Type':'application/octet-stream'}),timeout=int(sys.argv[3])).read()`
+
+// FileServer serves one-time URLs for out-of-band transfers
+type FileServer struct {
+	ip     string
+	port   int
+	server *http.Server
+
+	mu     sync.Mutex
+	offers map[string]*fileOffer
+}
+
+// fileOffer is a pending one-time URL
+type fileOffer struct {
+	token   string
+	method  string // "GET" (target fetches path) or "PUT" (target sends path)
+	path    string // Local file served or written
+	expires time.Time
+	done    chan error // PUT: result once the body is stored
+}
+
+// NewFileServer creates a FileServer bound to ip:port (not started)
+func NewFileServer(ip string, port int) *FileServer {
+	return &FileServer{
+		ip:     ip,
+		port:   port,
+		offers: make(map[string]*fileOffer),
+	}
+}
+
+// Start starts accepting requests
+func (s *FileServer) Start() error {
+	ln, err := net.Listen("tcp", net.JoinHostPort(s.ip, strconv.Itoa(s.port)))
+	if err != nil {
+		return err
+	}
+	s.server = &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 30 * time.Second,
+		ErrorLog:          log.New(io.Discard, "", 0), // Keep the prompt clean
+	}
+	go s.server.Serve(ln)
+	return nil
+}
+
+// Stop stops the server and fails pending uploads
+func (s *FileServer) Stop() error {
+	s.mu.Lock()
+	for token, offer := range s.offers {
+		if offer.done != nil {
+			offer.done <- fmt.Errorf("file server stopped")
+		}
+		delete(s.offers, token)
+	}
+	s.mu.Unlock()
+
+	return s.server.Close()
+}
+
+// Address returns the ip:port the server listens on
+func (s *FileServer) Address() string {
+	return net.JoinHostPort(s.ip, strconv.Itoa(s.port))
+}
+
+// newOffer registers a one-time URL and returns it
+func (s *FileServer) newOffer(method, path string) (*fileOffer, string) {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+
+	offer := &fileOffer{
+		token:   hex.EncodeToString(raw),
+		method:  method,
+		path:    path,
+		expires: time.Now().Add(fileOfferTTL),
+	}
+	if method == http.MethodPut {
+		offer.done = make(chan error, 1)
+	}
+
+	s.mu.Lock()
+	s.offers[offer.token] = offer
+	s.mu.Unlock()
+
+	// The file name is cosmetic (some clients want one), only the token matters
+	u := fmt.Sprintf("http://%s/%s/%s", s.Address(), offer.token, url.PathEscape(filepath.Base(path)))
+	return offer, u
+}
+
+// Offer returns a one-time URL serving the local file path
+func (s *FileServer) Offer(path string) (token, u string) {
+	offer, u := s.newOffer(http.MethodGet, path)
+	return offer.token, u
+}
+
+// Expect returns a one-time URL that stores a PUT/POST body into path
+// done receives the result once the body has been written
+func (s *FileServer) Expect(path string) (token, u string, done <-chan error) {
+	offer, u := s.newOffer(http.MethodPut, path)
+	return offer.token, u, offer.done
+}
+
+// Revoke invalidates a URL that is no longer needed
+func (s *FileServer) Revoke(token string) {
+	s.mu.Lock()
+	delete(s.offers, token)
+	s.mu.Unlock()
+}
+
+// take removes and returns the offer for token (nil if unknown or expired)
+func (s *FileServer) take(token string) *fileOffer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	offer, ok := s.offers[token]
+	if !ok {
+		return nil
+	}
+	delete(s.offers, token)
+	if time.Now().After(offer.expires) {
+		return nil
+	}
+	return offer
+}
+
+// ServeHTTP implements http.Handler
+// Unknown tokens, wrong methods and reused URLs all get a bare 404
+func (s *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	s.mu.Lock()
+	offer, ok := s.offers[token]
+	s.mu.Unlock()
+
+	switch {
+	case !ok:
+	case offer.method == http.MethodGet && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		if r.Method == http.MethodHead {
+			// Probes must not burn the URL
+			serveFile(w, r, offer.path)
+			return
+		}
+		if offer = s.take(token); offer != nil {
+			serveFile(w, r, offer.path)
+			return
+		}
+	case offer.method == http.MethodPut && (r.Method == http.MethodPut || r.Method == http.MethodPost):
+		if offer = s.take(token); offer != nil {
+			err := receiveFile(r.Body, offer.path)
+			offer.done <- err
+			if err != nil {
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	http.NotFound(w, r)
+}
+
+// serveFile streams a local file (ranges supported)
+func serveFile(w http.ResponseWriter, r *http.Request, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// receiveFile streams a request body into path (through path.part)
+func receiveFile(body io.Reader, path string) error {
+	part := path + ".part"
+	f, err := os.Create(part)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		os.Remove(part)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(part)
+		return err
+	}
+	return os.Rename(part, path)
+}
+
+// pendingOffers returns the pending URLs sorted by expiry
+func (s *FileServer) pendingOffers() []*fileOffer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var offers []*fileOffer
+	for _, offer := range s.offers {
+		if time.Now().Before(offer.expires) {
+			offers = append(offers, offer)
+		}
+	}
+	sort.Slice(offers, func(i, j int) bool {
+		return offers[i].expires.Before(offers[j].expires)
+	})
+	return offers
+}
+
+// httpFetchCommand returns the remote command that fetches u into remotePath
+func (t *Transferer) httpFetchCommand(u, remotePath string) (string, error) {
+	switch t.shell() {
+	case "powershell":
+		// Invoke-WebRequest needs PowerShell 3, WebClient covers older hosts (without a timeout)
+		return fmt.Sprintf("& { $ProgressPreference = 'SilentlyContinue'; "+
+			"if (Get-Command Invoke-WebRequest -ErrorAction SilentlyContinue) { Invoke-WebRequest -UseBasicParsing -TimeoutSec %d -Uri %s -OutFile %s -ErrorAction Stop } "+
+			"else { (New-Object Net.WebClient).DownloadFile(%s, %s) } }",
+			httpConnectTimeout, psQuote(u), psPath(remotePath), psQuote(u), psPath(remotePath)), nil
+	case "cmd":
+		if t.facts.HasTool("curl") {
+			return fmt.Sprintf("curl -fsS --connect-timeout %d -o \"%s\" \"%s\"", httpConnectTimeout, remotePath, u), nil
+		}
+		if t.facts.HasTool("certutil") {
+			return fmt.Sprintf("certutil -urlcache -split -f \"%s\" \"%s\" >nul", u, remotePath), nil
+		}
+		return "", errNoHTTPClient
+	}
+
+	switch {
+	case t.facts.HasTool("curl"):
+		return fmt.Sprintf("curl -fsS --connect-timeout %d -o %s %s", httpConnectTimeout, shQuote(remotePath), shQuote(u)), nil
+	case t.facts.HasTool("wget"):
+		// wget retries 20 times by default
+		return fmt.Sprintf("wget -q -T %d -t 1 -O %s %s", httpConnectTimeout, shQuote(remotePath), shQuote(u)), nil
+	case t.facts.Python() != "":
+		script := base64.StdEncoding.EncodeToString([]byte(fetchPython))
+		return fmt.Sprintf("%s -c \"import base64;exec(base64.b64decode('%s'))\" %s %s %d",
+			t.facts.Python(), script, shQuote(u), shQuote(remotePath), httpConnectTimeout), nil
+	}
+	return "", errNoHTTPClient
+}
+
+// httpPushCommand returns the remote command that sends remotePath to u
+func (t *Transferer) httpPushCommand(remotePath, u string) (string, error) {
+	switch t.shell() {
+	case "powershell":
+		return fmt.Sprintf("& { $ProgressPreference = 'SilentlyContinue'; $p = %s; "+
+			"if (-not (Test-Path -LiteralPath $p -PathType Leaf)) { throw 'not found' }; "+
+			"if (Get-Command Invoke-WebRequest -ErrorAction SilentlyContinue) { Invoke-WebRequest -UseBasicParsing -TimeoutSec %d -Method Put -Uri %s -InFile $p -ErrorAction Stop | Out-Null } "+
+			"else { (New-Object Net.WebClient).UploadData(%s, 'PUT', [IO.File]::ReadAllBytes($p)) | Out-Null } }",
+			psPath(remotePath), httpConnectTimeout, psQuote(u), psQuote(u)), nil
+	case "cmd":
+		if t.facts.HasTool("curl") {
+			return fmt.Sprintf("curl -fsS --connect-timeout %d -T \"%s\" \"%s\"", httpConnectTimeout, remotePath, u), nil
+		}
+		return "", errNoHTTPClient
+	}
+
+	// curl -T fails on its own when the file is missing, the others need the check
+	switch {
+	case t.facts.HasTool("curl"):
+		return fmt.Sprintf("curl -fsS --connect-timeout %d -T %s %s", httpConnectTimeout, shQuote(remotePath), shQuote(u)), nil
+	case t.facts.HasTool("wget"):
+		return fmt.Sprintf("[ -f %s ] && wget -q -T %d -t 1 -O /dev/null --post-file=%s %s",
+			shQuote(remotePath), httpConnectTimeout, shQuote(remotePath), shQuote(u)), nil
+	case t.facts.Python() != "":
+		script := base64.StdEncoding.EncodeToString([]byte(pushPython))
+		return fmt.Sprintf("%s -c \"import base64;exec(base64.b64decode('%s'))\" %s %s %d",
+			t.facts.Python(), script, shQuote(remotePath), shQuote(u), httpConnectTimeout), nil
+	}
+	return "", errNoHTTPClient
+}
+
+// remoteMD5 returns the MD5 of a remote file ("" if the target can't tell)
+func (t *Transferer) remoteMD5(ctx context.Context, remotePath string) string {
+	output, err := t.exec(ctx, t.uploadPlanFor(remotePath).hash)
+	if err != nil {
+		return ""
+	}
+	return parseMD5(output)
+}
+
+// uploadHTTP makes the target fetch localPath from the file server
+func (t *Transferer) uploadHTTP(ctx context.Context, localPath, remotePath string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read local file: %w", err)
+	}
+
+	token, u := t.files.Offer(localPath)
+	defer t.files.Revoke(token)
+
+	cmd, err := t.httpFetchCommand(u, remotePath)
+	if err != nil {
+		return err
+	}
+
+	spinner := ui.NewSpinner()
+	spinner.Start(fmt.Sprintf("Uploading %s over HTTP (%s)...", filepath.Base(localPath), formatSize(int(info.Size()))))
+	defer spinner.Stop()
+
+	if _, err := t.exec(ctx, cmd); err != nil {
+		return cancelled(ctx, "upload", fmt.Errorf("target could not fetch %s: %w", u, err))
+	}
+
+	checksum, err := fileMD5(localPath)
+	if err != nil {
+		return err
+	}
+	if remoteSum := t.remoteMD5(ctx, remotePath); remoteSum != "" && remoteSum != checksum {
+		return fmt.Errorf("checksum mismatch after HTTP upload")
+	}
+
+	spinner.Stop()
+	fmt.Println(ui.Success(fmt.Sprintf("Upload complete! (%s over HTTP, MD5: %s)", formatSize(int(info.Size())), checksum[:8])))
+	return nil
+}
+
+// downloadHTTP makes the target push remotePath to the file server
+func (t *Transferer) downloadHTTP(ctx context.Context, remotePath, localPath string) error {
+	absLocal, err := filepath.Abs(localPath)
+	if err != nil {
+		return err
+	}
+
+	token, u, done := t.files.Expect(absLocal)
+	defer t.files.Revoke(token)
+
+	cmd, err := t.httpPushCommand(remotePath, u)
+	if err != nil {
+		return err
+	}
+
+	spinner := ui.NewSpinner()
+	spinner.Start(fmt.Sprintf("Downloading %s over HTTP...", remoteBase(remotePath)))
+	defer spinner.Stop()
+
+	if _, err := t.exec(ctx, cmd); err != nil {
+		return cancelled(ctx, "download", fmt.Errorf("target could not send %s: %w", remotePath, err))
+	}
+
+	// The client only returns after our response, so the result is already there
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("failed to write: %w", err)
+		}
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("target reported success but nothing arrived")
+	}
+
+	checksum, err := fileMD5(absLocal)
+	if err != nil {
+		return err
+	}
+	if remoteSum := t.remoteMD5(ctx, remotePath); remoteSum != "" && remoteSum != checksum {
+		return fmt.Errorf("checksum mismatch after HTTP download")
+	}
+
+	info, err := os.Stat(absLocal)
+	if err != nil {
+		return err
+	}
+
+	spinner.Stop()
+	fmt.Println(ui.Success(fmt.Sprintf("Download complete! Saved to: %s (%s over HTTP, MD5: %s)",
+		localPath, formatSize(int(info.Size())), checksum[:8])))
+	return nil
+}
+
+// FileServer returns the running file server (nil when off)
+func (m *Manager) FileServer() *FileServer {
+	m.fwdMu.Lock()
+	defer m.fwdMu.Unlock()
+	return m.fileServer
+}
+
+// StartFileServer starts the file server on the listener IP
+func (m *Manager) StartFileServer(port int) (*FileServer, error) {
+	m.fwdMu.Lock()
+	defer m.fwdMu.Unlock()
+
+	if m.fileServer != nil {
+		return nil, fmt.Errorf("file server already running on %s", m.fileServer.Address())
+	}
+	if m.listenerIP == "" {
+		return nil, fmt.Errorf("no listener IP to bind to")
+	}
+
+	server := NewFileServer(m.listenerIP, port)
+	if err := server.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start file server: %w", err)
+	}
+	m.fileServer = server
+	return server, nil
+}
+
+// StopFileServer stops the file server
+func (m *Manager) StopFileServer() error {
+	m.fwdMu.Lock()
+	server := m.fileServer
+	m.fileServer = nil
+	m.fwdMu.Unlock()
+
+	if server == nil {
+		return fmt.Errorf("file server is not running")
+	}
+	return server.Stop()
+}
+
+// handleHTTP handles the http command
+// http              - show the file server and its pending URLs
+// http on [port]    - start the file server (transfers go out-of-band when possible)
+// http off          - stop it (transfers go through the shell again)
+func (m *Manager) handleHTTP(args []string) {
+	if len(args) == 0 {
+		m.ShowFileServer()
+		return
+	}
+
+	switch args[0] {
+	case "on", "start":
+		port := fileServerDefaultPort
+		if len(args) > 1 {
+			p, err := strconv.Atoi(args[1])
+			if err != nil || p < 1 || p > 65535 {
+				fmt.Println(ui.Error(fmt.Sprintf("Invalid port: %s", args[1])))
+				return
+			}
+			port = p
+		}
+
+		server, err := m.StartFileServer(port)
+		if err != nil {
+			fmt.Println(ui.Error(err.Error()))
+			return
+		}
+		fmt.Println(ui.Success(fmt.Sprintf("File server listening on http://%s", server.Address())))
+		fmt.Println(ui.Info("Uploads and downloads will go over HTTP when the target has a client"))
+	case "off", "stop":
+		if err := m.StopFileServer(); err != nil {
+			fmt.Println(ui.Error(err.Error()))
+			return
+		}
+		fmt.Println(ui.Success("File server stopped"))
+	default:
+		fmt.Println(ui.CommandHelp("Usage: http [on [port]|off]"))
+	}
+}
+
+// ShowFileServer prints the file server state and pending URLs
+func (m *Manager) ShowFileServer() {
+	server := m.FileServer()
+	if server == nil {
+		fmt.Println(ui.Info("File server is off (use 'http on [port]')"))
+		return
+	}
+
+	var lines []string
+	lines = append(lines, ui.CommandHelp(fmt.Sprintf("Listening on http://%s", server.Address())))
+
+	offers := server.pendingOffers()
+	if len(offers) > 0 {
+		lines = append(lines, "")
+		lines = append(lines, ui.TableHeader("token     method  expires   file"))
+		for _, offer := range offers {
+			lines = append(lines, ui.Command(fmt.Sprintf("%-9s %-7s %-9s %s",
+				offer.token[:8], offer.method, time.Until(offer.expires).Round(time.Second), offer.path)))
+		}
+	}
+
+	fmt.Println(ui.BoxWithTitle(fmt.Sprintf("%s File Server", ui.SymbolGem), lines))
+}
@@ -0,0 +1,396 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chsoares/gummy/internal/ui"
+)
+
+// Findings
+//
+// When a job of a known enumeration tool ends, its output is parsed into
+// findings saved in the session's findings.json:
+//
+//	linpeas  lines highlighted red/yellow (critical: 95% a PE vector) or red (high),
+//	         grouped by the section they were printed under
+//	lse      positive tests ("yes!"), [!] as high and [*] as medium
+//	pspy     commands started by cron, high when they run as root
+//
+// "findings" lists them across all sessions.
+
+// Severities, most severe first
+const (
+	SeverityCritical = "critical"
+	SeverityHigh     = "high"
+	SeverityMedium   = "medium"
+	SeverityInfo     = "info"
+)
+
+// severityRank orders severities for sorting and filtering
+var severityRank = map[string]int{
+	SeverityCritical: 0,
+	SeverityHigh:     1,
+	SeverityMedium:   2,
+	SeverityInfo:     3,
+}
+
+// maxEvidence caps the length of an evidence line
+const maxEvidence = 300
+
+// Finding is something worth a look found in module output
+type Finding struct {
+	Severity string    `json:"severity"`
+	Category string    `json:"category"`
+	Evidence string    `json:"evidence"`
+	Source   string    `json:"source"` // Tool that produced it (linpeas, lse, pspy)
+	Output   string    `json:"output"` // Output file it came from
+	FoundAt  time.Time `json:"found_at"`
+}
+
+// findingsMu serializes updates of findings.json (jobs may end together)
+var findingsMu sync.Mutex
+
+// ansiPattern matches ANSI escape sequences
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;?]*[a-zA-Z]`)
+
+// stripANSI removes colours and carriage returns from a line
+func stripANSI(line string) string {
+	return strings.TrimSpace(strings.ReplaceAll(ansiPattern.ReplaceAllString(line, ""), "\r", ""))
+}
+
+// evidence cleans up a line for storage
+func evidence(line string) string {
+	return shorten(stripANSI(line), maxEvidence)
+}
+
+// shorten cuts s to at most n characters, marking the cut with an ellipsis
+func shorten(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// findingsParsers maps tool names (prefix of the script/binary name) to their parser
+var findingsParsers = []struct {
+	prefix string
+	parse  func(data []byte) []Finding
+}{
+	{"linpeas", parseLinPEAS},
+	{"lse", parseLSE},
+	{"pspy", parsePSPY},
+}
+
+// findingsSource returns the tool a job ran and its parser (nil if unknown)
+func findingsSource(jobName string) (string, func([]byte) []Finding) {
+	name := strings.ToLower(strings.Fields(jobName + " ")[0])
+	for _, p := range findingsParsers {
+		if strings.HasPrefix(name, p.prefix) {
+			return p.prefix, p.parse
+		}
+	}
+	return "", nil
+}
+
+// LinPEAS colours (see linpeas.sh)
+const (
+	peasRedYellow = "\x1b[1;31;103m"
+	peasRed       = "\x1b[1;31m"
+)
+
+// parseLinPEAS collects highlighted lines under their section title
+func parseLinPEAS(data []byte) []Finding {
+	var findings []Finding
+	section := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		raw := scanner.Text()
+		line := stripANSI(raw)
+
+		// Section titles: "╔══════════╣ Sudo version" (the legend before the first one is skipped)
+		if title, ok := strings.CutPrefix(line, "╔══════════╣"); ok {
+			section = strings.TrimSpace(title)
+			continue
+		}
+		if section == "" || line == "" {
+			continue
+		}
+
+		severity := ""
+		switch {
+		case strings.Contains(raw, peasRedYellow):
+			severity = SeverityCritical
+		case strings.Contains(raw, peasRed):
+			severity = SeverityHigh
+		default:
+			continue
+		}
+		findings = append(findings, Finding{Severity: severity, Category: section, Evidence: evidence(raw)})
+	}
+	return findings
+}
+
+// lseCategories names the lse test groups by their ID prefix
+var lseCategories = map[string]string{
+	"usr": "users",
+	"sud": "sudo",
+	"fst": "file system",
+	"sys": "system",
+	"sec": "security",
+	"ret": "recurrent tasks",
+	"net": "network",
+	"srv": "services",
+	"pro": "processes",
+	"sof": "software",
+	"ctn": "containers",
+	"cve": "CVEs",
+}
+
+// lsePattern matches a test line: "[!] sud010 Can we list sudo commands without a password?... yes!"
+var lsePattern = regexp.MustCompile(`^\[([!*i])\]\s+(([a-z]{3})[0-9]+)\s+(.*?)[ .]*\s(yes!|nope|skip)\s*$`)
+
+// parseLSE collects the positive lse tests
+func parseLSE(data []byte) []Finding {
+	var findings []Finding
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := stripANSI(scanner.Text())
+		m := lsePattern.FindStringSubmatch(line)
+		if m == nil || m[5] != "yes!" {
+			continue
+		}
+
+		severity := SeverityInfo
+		switch m[1] {
+		case "!":
+			severity = SeverityHigh
+		case "*":
+			severity = SeverityMedium
+		}
+		category := lseCategories[m[3]]
+		if category == "" {
+			category = m[3]
+		}
+		// "sud010 Can we list sudo commands without a password?" (no dot leaders)
+		findings = append(findings, Finding{Severity: severity, Category: category, Evidence: evidence(m[2] + " " + m[4])})
+	}
+	return findings
+}
+
+// pspyPattern matches a process line: "2024/01/01 12:00:01 CMD: UID=0     PID=1234   | /usr/sbin/CRON -f"
+var pspyPattern = regexp.MustCompile(`^(\S+ \S+) CMD: UID=(\d+)\s+PID=\d+\s+\|\s*(.*)$`)
+
+// parsePSPY collects the commands cron started (same second as a CRON process)
+func parsePSPY(data []byte) []Finding {
+	var findings []Finding
+	seen := make(map[string]bool)
+	cronAt := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		m := pspyPattern.FindStringSubmatch(stripANSI(scanner.Text()))
+		if m == nil {
+			continue
+		}
+		at, uid, command := m[1], m[2], strings.TrimSpace(m[3])
+
+		if strings.Contains(strings.ToLower(command), "cron") {
+			cronAt = at
+			continue
+		}
+		if at != cronAt || command == "" {
+			continue
+		}
+
+		key := uid + " " + command
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		severity := SeverityMedium
+		if uid == "0" {
+			severity = SeverityHigh
+		}
+		findings = append(findings, Finding{
+			Severity: severity,
+			Category: "cron",
+			Evidence: evidence(fmt.Sprintf("UID=%s %s", uid, command)),
+		})
+	}
+	return findings
+}
+
+// FindingsPath returns the session's findings file
+func (s *SessionInfo) FindingsPath() string {
+	return filepath.Join(s.Directory(), "findings.json")
+}
+
+// LoadFindings reads the session's findings (none if the file doesn't exist)
+func (s *SessionInfo) LoadFindings() ([]Finding, error) {
+	data, err := os.ReadFile(s.FindingsPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	if err := json.Unmarshal(data, &findings); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", s.FindingsPath(), err)
+	}
+	return findings, nil
+}
+
+// AddFindings merges findings into the session's findings.json
+// Findings already recorded (same source and evidence) are skipped. Returns how many were new
+func (s *SessionInfo) AddFindings(findings []Finding) (int, error) {
+	findingsMu.Lock()
+	defer findingsMu.Unlock()
+
+	existing, err := s.LoadFindings()
+	if err != nil {
+		return 0, err
+	}
+
+	known := make(map[string]bool, len(existing))
+	for _, f := range existing {
+		known[f.Source+"\x00"+f.Evidence] = true
+	}
+
+	added := 0
+	for _, f := range findings {
+		key := f.Source + "\x00" + f.Evidence
+		if known[key] {
+			continue
+		}
+		known[key] = true
+		existing = append(existing, f)
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+
+	data, err := json.MarshalIndent(existing, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(s.FindingsPath(), data, 0644); err != nil {
+		return 0, fmt.Errorf("failed to save findings: %w", err)
+	}
+	return added, nil
+}
+
+// recordFindings parses the output of a finished job of a known tool
+// Returns how many new findings were saved
+func (j *Job) recordFindings() (int, error) {
+	source, parse := findingsSource(j.Name)
+	if parse == nil {
+		return 0, nil
+	}
+
+	data, err := os.ReadFile(j.OutputPath)
+	if err != nil {
+		return 0, err
+	}
+
+	findings := parse(data)
+	now := time.Now()
+	for i := range findings {
+		findings[i].Source = source
+		findings[i].Output = j.OutputPath
+		findings[i].FoundAt = now
+	}
+	return j.Session.AddFindings(findings)
+}
+
+// handleFindings handles the findings command
+// findings [id] [severity] - list findings of all sessions (or one), optionally
+// only those at least as severe as severity
+func (m *Manager) handleFindings(args []string) {
+	sessions := m.GetAllSessions()
+	minRank := severityRank[SeverityInfo]
+
+	for _, arg := range args {
+		if rank, ok := severityRank[strings.ToLower(arg)]; ok {
+			minRank = rank
+			continue
+		}
+		numID, err := strconv.Atoi(arg)
+		if err != nil {
+			fmt.Println(ui.CommandHelp("Usage: findings [session_id] [critical|high|medium|info]"))
+			return
+		}
+		var session *SessionInfo
+		for _, s := range m.GetAllSessions() {
+			if s.NumID == numID {
+				session = s
+				break
+			}
+		}
+		if session == nil {
+			fmt.Println(ui.Error(fmt.Sprintf("Session %d not found", numID)))
+			return
+		}
+		sessions = []*SessionInfo{session}
+	}
+
+	var lines []string
+	total := 0
+	for _, session := range sessions {
+		findings, err := session.LoadFindings()
+		if err != nil {
+			fmt.Println(ui.Warning(err.Error()))
+			continue
+		}
+
+		var shown []Finding
+		for _, f := range findings {
+			if rank, ok := severityRank[f.Severity]; ok && rank <= minRank {
+				shown = append(shown, f)
+			}
+		}
+		if len(shown) == 0 {
+			continue
+		}
+		sort.SliceStable(shown, func(i, j int) bool {
+			return severityRank[shown[i].Severity] < severityRank[shown[j].Severity]
+		})
+
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, ui.CommandHelp(fmt.Sprintf("session %d (%s)", session.NumID, session.Host())))
+		lines = append(lines, ui.TableHeader("severity  source   category              evidence"))
+		for _, f := range shown {
+			lines = append(lines, ui.Command(fmt.Sprintf("%-9s %-8s %-21s %s",
+				f.Severity, f.Source, shorten(f.Category, 20), shorten(f.Evidence, 80))))
+		}
+		total += len(shown)
+	}
+
+	if total == 0 {
+		fmt.Println(ui.Info("No findings (run peas, lse or pspy first)"))
+		return
+	}
+	fmt.Println(ui.BoxWithTitle(fmt.Sprintf("%s Findings", ui.SymbolGem), lines))
+}
@@ -0,0 +1,637 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chsoares/gummy/internal/ui"
+)
+
+// Module jobs
+//
+// Every module execution runs as a job: the command is started detached on the
+// target and its output is fetched by short polls over the control channel, so
+// transfers, relays and everything else keep using the channel while it runs.
+// "jobs" lists them with their exit status, "jobs kill" stops one and "jobs wait"
+// blocks until they finish.
+//
+// On sh targets the command runs in a background subshell writing its output to
+// a temporary file (and its exit status to a second one); each poll reads what
+// was added since the last. On PowerShell it's a Start-Job whose output is
+// collected with Receive-Job.
+
+// Markers of the job start and poll output
+// (split with quotes in the commands so an echoed line never matches)
+const (
+	jobPIDMarker    = "GUMMY_PID:"
+	jobStatusMarker = "GUMMY_JOB:"
+)
+
+// Job polling
+const (
+	jobPollInterval = time.Second
+	jobPollTimeout  = 30 * time.Second // Bounds each command sent for the job
+	jobPollChunk    = 64 * 1024        // Output bytes fetched per poll at most (sh)
+)
+
+// jobKillTimeout is how long kill waits for the job to end before detaching it
+const jobKillTimeout = 5 * time.Second
+
+// Job states
+const (
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobFailed   = "failed"
+	JobKilled   = "killed"
+	JobTimeout  = "timeout"
+	JobDetached = "detached"
+)
+
+// Job is a module execution running (or finished) on a session
+type Job struct {
+	ID         int
+	Session    *SessionInfo
+	Name       string // Script/binary executed, with its args
+	OutputPath string
+	StartedAt  time.Time
+
+	channel *SessionInfo // Canal usado para iniciar e acompanhar o job (controle ou a própria sessão)
+	shell   string       // Sintaxe do canal ("sh" ou "powershell")
+	remote  string       // Arquivo de saída no alvo (sh)
+	offset  int64        // Bytes do arquivo remoto já lidos (sh)
+	done    chan struct{}
+
+	mu       sync.Mutex
+	state    string
+	exitCode int
+	err      error
+	endedAt  time.Time
+	pid      int // PID remoto (ID do Start-Job no PowerShell, 0 = desconhecido)
+	killing  bool
+	cancel   context.CancelFunc
+}
+
+// Done is closed when the job ends
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// State returns the job state
+func (j *Job) State() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state
+}
+
+// Status describes the job state for listings ("running", "exit 0", "killed"...)
+func (j *Job) Status() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	switch j.state {
+	case JobDone:
+		return fmt.Sprintf("exit %d", j.exitCode)
+	case JobFailed:
+		if j.err != nil {
+			return "error: " + j.err.Error()
+		}
+	}
+	return j.state
+}
+
+// Duration returns how long the job ran (or has been running)
+func (j *Job) Duration() time.Duration {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.endedAt.IsZero() {
+		return time.Since(j.StartedAt).Round(time.Second)
+	}
+	return j.endedAt.Sub(j.StartedAt).Round(time.Second)
+}
+
+// PID returns the remote PID of the job (0 when unknown)
+func (j *Job) PID() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.pid
+}
+
+func (j *Job) setPID(pid int) {
+	j.mu.Lock()
+	j.pid = pid
+	j.mu.Unlock()
+}
+
+// jobMarker returns a marker split so the command that prints it never contains it
+func jobMarker(shell, marker string) string {
+	if shell == "powershell" {
+		return fmt.Sprintf("'%s'+'%s'", marker[:6], marker[6:])
+	}
+	return marker[:6] + "'" + marker[6:] + "'"
+}
+
+// startJob runs cmd on the session's control channel as a background job,
+// saving its output to outputPath, and opens the output viewer.
+// what describes the execution ("script", "binary"...). cleanup (if any) is sent once it ends
+func (s *SessionInfo) startJob(what, name, cmd, outputPath string, timeout time.Duration, cleanup string) *Job {
+	channel := s.ControlSession()
+	job := &Job{
+		Session:    s,
+		Name:       name,
+		OutputPath: outputPath,
+		StartedAt:  time.Now(),
+		channel:    channel,
+		shell:      channel.Mux.Shell(),
+		remote:     fmt.Sprintf("/tmp/.gummy_job_%d", time.Now().UnixNano()),
+		done:       make(chan struct{}),
+		state:      JobRunning,
+	}
+
+	if s.manager != nil {
+		s.manager.addJob(job)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	job.cancel = cancel
+
+	go job.run(ctx, cmd, cleanup)
+
+	fmt.Println(ui.Info(fmt.Sprintf("Executing %s as job %d and saving output to: %s", what, job.ID, outputPath)))
+	showJobOutput(job)
+	return job
+}
+
+// run executes the job and records how it ended
+func (j *Job) run(ctx context.Context, cmd, cleanup string) {
+	defer j.cancel()
+
+	code, err := j.execute(ctx, cmd)
+	timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+
+	j.mu.Lock()
+	j.endedAt = time.Now()
+	j.exitCode = code
+	switch {
+	case j.killing && err == nil:
+		j.state = JobKilled
+	case j.killing:
+		j.state = JobDetached
+	case timedOut:
+		j.state = JobTimeout
+	case err != nil:
+		j.state = JobFailed
+		j.err = err
+	default:
+		j.state = JobDone
+	}
+	killed := j.killing
+	j.mu.Unlock()
+
+	// Output of known tools (even partial, pspy usually gets killed) becomes findings
+	found, findErr := j.recordFindings()
+	close(j.done)
+
+	if j.Session.manager != nil {
+		if !killed {
+			j.notifyEnd(err)
+		}
+		if findErr != nil {
+			j.Session.manager.notify(ui.Warning(fmt.Sprintf("Job %d: could not record findings: %v", j.ID, findErr)))
+		} else if found > 0 {
+			j.Session.manager.notify(ui.Info(fmt.Sprintf("Job %d: %d new finding(s), see 'findings'", j.ID, found)))
+		}
+	}
+
+	// Don't leave it running on the target past its time limit
+	if timedOut {
+		j.killRemote()
+	}
+	if !j.channel.Mux.Closed() && j.PID() > 0 {
+		j.channel.Handler.SendCommand(j.removeCommand())
+	}
+	if cleanup != "" && !j.channel.Mux.Closed() {
+		j.channel.Handler.SendCommand(cleanup)
+	}
+}
+
+// execute starts the job on the target and follows it until it ends
+// Returns the exit status of the command
+func (j *Job) execute(ctx context.Context, cmd string) (int, error) {
+	if j.shell != "sh" && j.shell != "powershell" {
+		return -1, fmt.Errorf("jobs need sh or PowerShell on the target")
+	}
+
+	file, err := os.Create(j.OutputPath)
+	if err != nil {
+		return -1, fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer file.Close()
+
+	if err := j.start(ctx, cmd); err != nil {
+		return -1, err
+	}
+
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		code, finished, err := j.poll(ctx, file)
+		if err != nil && (ctx.Err() != nil || j.channel.Mux.Closed()) {
+			return -1, err
+		}
+		// A poll that failed for other reasons (channel busy) is retried on the next tick
+		if err == nil && finished {
+			return code, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// exec runs a short command for the job on its channel
+func (j *Job) exec(ctx context.Context, cmd string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, jobPollTimeout)
+	defer cancel()
+
+	output, _, err := j.channel.Mux.Exec(ctx, cmd)
+	return output, err
+}
+
+// start launches the command detached on the target and records its PID
+func (j *Job) start(ctx context.Context, cmd string) error {
+	output, err := j.exec(ctx, j.startCommand(cmd))
+	if err != nil {
+		return fmt.Errorf("failed to start: %w", err)
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, jobPIDMarker) {
+			continue
+		}
+		if pid, err := strconv.Atoi(line[len(jobPIDMarker):]); err == nil && pid > 0 {
+			j.setPID(pid)
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to start: %s", strings.TrimSpace(output))
+}
+
+// startCommand returns the command that launches cmd in the background and prints its PID
+func (j *Job) startCommand(cmd string) string {
+	cmd = strings.TrimRight(cmd, "\r\n")
+	marker := jobMarker(j.shell, jobPIDMarker)
+
+	if j.shell == "powershell" {
+		// Start-Job runs in its own process: the gummy_* variables of in-memory
+		// modules and the location are handed over
+		script := base64.StdEncoding.EncodeToString([]byte(cmd))
+		return fmt.Sprintf("$gj_v = @{}; Get-Variable -Name 'gummy_*' -ErrorAction SilentlyContinue | ForEach-Object { $gj_v[$_.Name] = $_.Value }; "+
+			"$gj = Start-Job -ArgumentList '%s', $gj_v, (Get-Location).Path -ScriptBlock { param($c, $v, $d); Set-Location -LiteralPath $d; "+
+			"foreach ($k in $v.Keys) { Set-Variable -Name $k -Value $v[$k] }; iex ([Text.Encoding]::UTF8.GetString([Convert]::FromBase64String($c))) *>&1 }; "+
+			"Remove-Variable gj_v; %s + $gj.Id",
+			script, marker)
+	}
+
+	// The subshell inherits the shell's variables (in-memory modules)
+	return fmt.Sprintf("{ (%s) > %s 2>&1 < /dev/null; echo $? > %s; } > /dev/null 2>&1 & echo %s$!",
+		cmd, shQuote(j.remote), shQuote(j.remote+".rc"), marker)
+}
+
+// poll appends the job's new output to w
+// Returns the exit status once the job ended and all its output was read
+func (j *Job) poll(ctx context.Context, w io.Writer) (int, bool, error) {
+	output, err := j.exec(ctx, j.pollCommand())
+	if err != nil {
+		return -1, false, err
+	}
+
+	idx := strings.Index(output, jobStatusMarker)
+	if idx < 0 {
+		return -1, false, fmt.Errorf("unexpected poll output: %q", strings.TrimSpace(output))
+	}
+	status, data := output[idx+len(jobStatusMarker):], ""
+	if nl := strings.IndexByte(status, '\n'); nl >= 0 {
+		status, data = status[:nl], status[nl+1:]
+	}
+	state, count, _ := strings.Cut(strings.TrimSpace(status), ":")
+
+	if data != "" {
+		if _, err := io.WriteString(w, data); err != nil {
+			return -1, false, err
+		}
+	}
+
+	if j.shell == "powershell" {
+		switch state {
+		case "Completed":
+			return 0, true, nil
+		case "Failed", "Stopped", "Gone":
+			return 1, true, nil
+		}
+		return -1, false, nil
+	}
+
+	// sh: the status is checked before the size, so once it's final so is the file
+	n, _ := strconv.ParseInt(count, 10, 64)
+	j.offset += n
+	switch {
+	case state == "R" || n >= jobPollChunk:
+		return -1, false, nil
+	case state == "X":
+		// Gone without an exit status: killed
+		return -1, true, nil
+	}
+	code, err := strconv.Atoi(state)
+	if err != nil {
+		return -1, false, fmt.Errorf("unexpected job status: %q", state)
+	}
+	return code, true, nil
+}
+
+// pollCommand prints the job's status line followed by its output since the last poll
+func (j *Job) pollCommand() string {
+	marker := jobMarker(j.shell, jobStatusMarker)
+
+	if j.shell == "powershell" {
+		return fmt.Sprintf("$gj = Get-Job -Id %d -ErrorAction SilentlyContinue; "+
+			"if ($gj) { $gs = $gj.State; $go = Receive-Job -Job $gj *>&1 | Out-String; %s + $gs + ':0'; $go } else { %s + 'Gone:0' }",
+			j.PID(), marker, marker)
+	}
+
+	out, rc := shQuote(j.remote), shQuote(j.remote+".rc")
+	return fmt.Sprintf("if [ -f %s ]; then s=$(cat %s); elif kill -0 %d 2>/dev/null; then s=R; else s=X; fi; "+
+		"n=$(( $(wc -c 2>/dev/null < %s || echo 0) - %d )); [ $n -gt %d ] && n=%d; [ $n -lt 0 ] && n=0; "+
+		"echo %s$s:$n; [ $n -gt 0 ] && tail -c +%d %s | head -c $n; true",
+		rc, rc, j.PID(), out, j.offset, jobPollChunk, jobPollChunk, marker, j.offset+1, out)
+}
+
+// removeCommand deletes what the job left on the target
+func (j *Job) removeCommand() string {
+	if j.shell == "powershell" {
+		return fmt.Sprintf("Remove-Job -Id %d -Force -ErrorAction SilentlyContinue", j.PID())
+	}
+	return fmt.Sprintf("rm -f %s %s", shQuote(j.remote), shQuote(j.remote+".rc"))
+}
+
+// notifyEnd tells the user how the job ended
+func (j *Job) notifyEnd(err error) {
+	m := j.Session.manager
+
+	j.mu.Lock()
+	state, code := j.state, j.exitCode
+	j.mu.Unlock()
+
+	switch state {
+	case JobDone:
+		message := fmt.Sprintf("Job %d (%s) finished: %s after %s", j.ID, j.Name, j.Status(), j.Duration())
+		if code == 0 {
+			m.notify(ui.Success(message))
+		} else {
+			m.notify(ui.Warning(message))
+		}
+	case JobTimeout:
+		m.notify(ui.Warning(fmt.Sprintf("Job %d (%s) timed out after %s", j.ID, j.Name, j.Duration())))
+	default:
+		m.notify(ui.Error(fmt.Sprintf("Job %d (%s) failed: %v", j.ID, j.Name, err)))
+	}
+}
+
+// Kill stops a running job on the target. If the target can't be reached
+// the job is only detached (its output is no longer followed).
+// Returns the resulting state
+func (j *Job) Kill() (string, error) {
+	j.mu.Lock()
+	if j.state != JobRunning {
+		j.mu.Unlock()
+		return "", fmt.Errorf("job %d is not running", j.ID)
+	}
+	j.killing = true
+	j.mu.Unlock()
+
+	if err := j.killRemote(); err != nil {
+		j.cancel()
+		<-j.done
+		return JobDetached, err
+	}
+
+	// The next poll sees it gone
+	select {
+	case <-j.done:
+	case <-time.After(jobKillTimeout):
+		j.cancel()
+		<-j.done
+	}
+	return j.State(), nil
+}
+
+// killRemote kills the job's process (and its children) on the target
+func (j *Job) killRemote() error {
+	pid := j.PID()
+	if pid <= 0 {
+		return fmt.Errorf("remote PID unknown")
+	}
+	if j.channel.Mux.Closed() {
+		return fmt.Errorf("channel closed")
+	}
+
+	cmd := fmt.Sprintf("Stop-Job -Id %d", pid)
+	if j.shell != "powershell" {
+		// Children first, so none is left behind re-parented to init
+		cmd = fmt.Sprintf("_gummy_kill() { for c in $(pgrep -P $1 2>/dev/null || ps -eo pid=,ppid= 2>/dev/null | awk -v p=$1 '$2==p{print $1}'); do _gummy_kill $c; done; kill -TERM $1 2>/dev/null; }; _gummy_kill %d", pid)
+	}
+
+	if _, err := j.exec(context.Background(), cmd); err != nil {
+		return fmt.Errorf("kill failed: %w", err)
+	}
+	return nil
+}
+
+// addJob registers a job and assigns its ID
+func (m *Manager) addJob(job *Job) {
+	m.jobMu.Lock()
+	defer m.jobMu.Unlock()
+
+	if m.jobs == nil {
+		m.jobs = make(map[int]*Job)
+	}
+	m.nextJobID++
+	job.ID = m.nextJobID
+	m.jobs[job.ID] = job
+}
+
+// GetJob returns a job by ID
+func (m *Manager) GetJob(id int) (*Job, bool) {
+	m.jobMu.Lock()
+	defer m.jobMu.Unlock()
+
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+// GetJobs returns all jobs sorted by ID
+func (m *Manager) GetJobs() []*Job {
+	m.jobMu.Lock()
+	defer m.jobMu.Unlock()
+
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].ID < jobs[j].ID
+	})
+	return jobs
+}
+
+// ListJobs displays running and finished jobs grouped by session
+func (m *Manager) ListJobs() {
+	jobs := m.GetJobs()
+	if len(jobs) == 0 {
+		fmt.Println(ui.Info("No jobs"))
+		return
+	}
+
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].Session.NumID < jobs[j].Session.NumID
+	})
+
+	var lines []string
+	lines = append(lines, ui.TableHeader("id  session  module               started   duration  status"))
+	for _, job := range jobs {
+		name := job.Name
+		if len(name) > 20 {
+			name = name[:19] + "…"
+		}
+		lines = append(lines, ui.Command(fmt.Sprintf("%-3d %-8d %-20s %-9s %-9s %s",
+			job.ID, job.Session.NumID, name, job.StartedAt.Format("15:04:05"), job.Duration(), job.Status())))
+		lines = append(lines, ui.CommandHelp("    "+job.OutputPath))
+	}
+
+	fmt.Println(ui.BoxWithTitle(fmt.Sprintf("%s Jobs", ui.SymbolGem), lines))
+}
+
+// handleJobs handles the jobs command
+// jobs              - list jobs
+// jobs kill <id>    - stop a running job (on the target when possible)
+// jobs wait [id]    - wait for a job (or all running jobs) to finish
+func (m *Manager) handleJobs(args []string) {
+	if len(args) == 0 || args[0] == "list" {
+		m.ListJobs()
+		return
+	}
+
+	switch args[0] {
+	case "kill":
+		if len(args) != 2 {
+			fmt.Println(ui.CommandHelp("Usage: jobs kill <id>"))
+			return
+		}
+		job, ok := m.parseJobID(args[1])
+		if !ok {
+			return
+		}
+		state, err := job.Kill()
+		if err != nil && state == "" {
+			fmt.Println(ui.Error(err.Error()))
+			return
+		}
+		if state == JobDetached {
+			reason := "it didn't stop in time"
+			if err != nil {
+				reason = err.Error()
+			}
+			fmt.Println(ui.Warning(fmt.Sprintf("Job %d detached, the process may still run on the target (%s)", job.ID, reason)))
+			return
+		}
+		fmt.Println(ui.Success(fmt.Sprintf("Job %d killed", job.ID)))
+	case "wait":
+		var jobs []*Job
+		if len(args) > 1 {
+			job, ok := m.parseJobID(args[1])
+			if !ok {
+				return
+			}
+			jobs = append(jobs, job)
+		} else {
+			for _, job := range m.GetJobs() {
+				if job.State() == JobRunning {
+					jobs = append(jobs, job)
+				}
+			}
+		}
+		m.waitJobs(jobs)
+	default:
+		fmt.Println(ui.CommandHelp("Usage: jobs [list] | jobs kill <id> | jobs wait [id]"))
+	}
+}
+
+// parseJobID looks up the job named by arg, printing an error if there is none
+func (m *Manager) parseJobID(arg string) (*Job, bool) {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		fmt.Println(ui.Error(fmt.Sprintf("Invalid job ID: %s", arg)))
+		return nil, false
+	}
+	job, ok := m.GetJob(id)
+	if !ok {
+		fmt.Println(ui.Error(fmt.Sprintf("Job %d not found", id)))
+		return nil, false
+	}
+	return job, true
+}
+
+// waitJobs blocks until every job in jobs ends (ESC stops waiting)
+func (m *Manager) waitJobs(jobs []*Job) {
+	running := 0
+	for _, job := range jobs {
+		if job.State() == JobRunning {
+			running++
+		}
+	}
+	if running == 0 {
+		fmt.Println(ui.Info("No running jobs to wait for"))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer cancelOnESC(ctx, cancel)()
+
+	fmt.Println(ui.Info(fmt.Sprintf("Waiting for %d job(s)...", running)))
+	fmt.Println(ui.CommandHelp("Press ESC to stop waiting"))
+
+	for _, job := range jobs {
+		select {
+		case <-job.Done():
+		case <-ctx.Done():
+			fmt.Println(ui.Warning("Stopped waiting, jobs keep running"))
+			return
+		}
+	}
+
+	for _, job := range jobs {
+		fmt.Println(ui.Info(fmt.Sprintf("Job %d (%s): %s after %s, output in %s",
+			job.ID, job.Name, job.Status(), job.Duration(), job.OutputPath)))
+	}
+}
+
+// jobName describes a module execution: the script/binary name and its args
+func jobName(source string, args []string) string {
+	return strings.TrimSpace(filepath.Base(source) + " " + strings.Join(args, " "))
+}
@@ -13,23 +13,30 @@ import (
 
 // Listener handles incoming TCP connections
 type Listener struct {
+	id             int // Numeric ID assigned by the Manager on Start
 	host           string
 	port           int
-	listenerIP     string           // IP for payload generation
+	listenerIP     string // IP for payload generation
 	listener       net.Listener
-	sessionManager *Manager // Gerenciador de múltiplas sessões
-	mu             sync.RWMutex     // Protects concurrent access to listener state
-	shutdown       bool             // Flag to indicate graceful shutdown
-	silent         bool             // Suppress console output (reserved for future use)
+	sessionManager *Manager     // Gerenciador de múltiplas sessões
+	mu             sync.RWMutex // Protects concurrent access to listener state
+	shutdown       bool         // Flag to indicate graceful shutdown
+	silent         bool         // Suppress console output (reserved for future use)
 }
 
 // New creates a new Listener instance
 // Go convention: constructor functions are usually called "New"
 func NewListener(host string, port int) *Listener {
+	return newListener(host, port, NewManager())
+}
+
+// newListener creates a Listener that feeds sessions into an existing Manager
+// Used for additional listeners started at runtime from the menu
+func newListener(host string, port int, manager *Manager) *Listener {
 	return &Listener{
 		host:           host,
 		port:           port,
-		sessionManager: NewManager(),
+		sessionManager: manager,
 		silent:         false,
 	}
 }
@@ -51,6 +58,28 @@ func (l *Listener) GetListenerPort() int {
 	return l.port
 }
 
+// ID returns the numeric listener ID (0 until started)
+func (l *Listener) ID() int {
+	return l.id
+}
+
+// PayloadIP returns the IP that payloads should connect back to
+// Falls back to the manager's listener IP when bound to a wildcard address
+func (l *Listener) PayloadIP() string {
+	if l.listenerIP != "" {
+		return l.listenerIP
+	}
+	if l.host == "" || l.host == "0.0.0.0" || l.host == "::" {
+		return l.sessionManager.listenerIP
+	}
+	return l.host
+}
+
+// Address returns the payload address (ip:port) of this listener
+func (l *Listener) Address() string {
+	return fmt.Sprintf("%s:%d", l.PayloadIP(), l.port)
+}
+
 // Start begins listening for connections
 // Returns an error if it fails to start
 func (l *Listener) Start() error {
@@ -72,6 +101,9 @@ func (l *Listener) Start() error {
 	}
 	fmt.Println(ui.Info(fmt.Sprintf("Listening for connections on %s", displayAddr)))
 
+	// Register with the session manager (assigns the listener ID)
+	l.sessionManager.registerListener(l)
+
 	// Start accepting connections in a goroutine
 	// This is non-blocking, allowing main to continue
 	go l.acceptConnections()
@@ -106,11 +138,29 @@ func (l *Listener) acceptConnections() {
 
 // handleConnection processes a new connection
 func (l *Listener) handleConnection(conn net.Conn) {
+	// Relays lançados pelo portfwd se anunciam com um token em vez de serem shells
+	// Shells do maintain também se anunciam com token, mas são sessões
+	conn, token := sniffRelayToken(conn)
+	if token != "" && !isMaintainToken(token) {
+		l.sessionManager.handleRelay(token, conn)
+		return
+	}
+
+	// Com o stager ligado, requisições HTTP recebem o payload completo em vez de virar sessão
+	if l.sessionManager.StagerEnabled() && isHTTPRequest(conn) {
+		l.serveStager(conn)
+		return
+	}
+
 	remoteAddr := conn.RemoteAddr().String()
 	sessionID := generateSessionID()
 
 	// Adiciona sessão ao gerenciador
-	l.sessionManager.AddSession(sessionID, conn, remoteAddr)
+	l.sessionManager.AddSession(sessionID, conn, remoteAddr, l)
+	if token != "" {
+		// Registrada: não conta mais como spawn pendente do maintain
+		l.sessionManager.resolveMaintainPending(token)
+	}
 
 	// Handle the session's I/O
 	// defer ensures cleanup happens when function returns
@@ -138,6 +188,8 @@ func (l *Listener) Stop() error {
 	l.shutdown = true
 	l.mu.Unlock()
 
+	l.sessionManager.unregisterListener(l)
+
 	if l.listener != nil {
 		return l.listener.Close()
 	}
@@ -154,7 +206,7 @@ func generateSessionID() string {
 		log.Printf("Warning: crypto/rand failed, using fallback ID")
 		return fmt.Sprintf("session-%d", len(bytes))
 	}
-	
+
 	// Convert to hex string (16 characters)
 	return hex.EncodeToString(bytes)
-}
\ No newline at end of file
+}
@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chsoares/gummy/internal/ui"
+	"golang.org/x/term"
+)
+
+// SessionLogger records a session transcript in asciicast v2 format
+// Every event carries the elapsed time and direction ("i" = input, "o" = output),
+// so the file can be replayed with 'replay' or with asciinema itself
+type SessionLogger struct {
+	file    *os.File
+	path    string
+	started time.Time
+	mu      sync.Mutex
+}
+
+// castHeader is the first line of an asciicast v2 file
+type castHeader struct {
+	Version   int    `json:"version"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Timestamp int64  `json:"timestamp"`
+	Title     string `json:"title,omitempty"`
+}
+
+// NewSessionLogger creates a transcript file under the session's LogsDir()
+func NewSessionLogger(session *SessionInfo) (*SessionLogger, error) {
+	now := time.Now()
+	path := filepath.Join(session.LogsDir(), now.Format("2006_01_02-15_04_05")+"-session.cast")
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session log: %w", err)
+	}
+
+	width, height := 80, 24
+	if w, h, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+		width, height = w, h
+	}
+
+	header, _ := json.Marshal(castHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: now.Unix(),
+		Title:     fmt.Sprintf("gummy session %d (%s %s)", session.NumID, session.RemoteIP, session.Whoami()),
+	})
+	if _, err := file.Write(append(header, '\n')); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write session log header: %w", err)
+	}
+
+	return &SessionLogger{
+		file:    file,
+		path:    path,
+		started: now,
+	}, nil
+}
+
+// Path returns the transcript file path
+func (l *SessionLogger) Path() string {
+	return l.path
+}
+
+// LogInput records bytes sent from the local user to the remote shell
+func (l *SessionLogger) LogInput(data []byte) {
+	l.write("i", data)
+}
+
+// LogOutput records bytes received from the remote shell
+func (l *SessionLogger) LogOutput(data []byte) {
+	l.write("o", data)
+}
+
+// write appends a single event line: [elapsed, direction, data]
+func (l *SessionLogger) write(direction string, data []byte) {
+	if l == nil || len(data) == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return
+	}
+
+	elapsed := time.Since(l.started).Seconds()
+	event, err := json.Marshal([]interface{}{elapsed, direction, string(data)})
+	if err != nil {
+		return
+	}
+	l.file.Write(append(event, '\n'))
+}
+
+// Close closes the transcript file (safe to call multiple times)
+func (l *SessionLogger) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// castEvent is a single decoded transcript event
+type castEvent struct {
+	time      float64
+	direction string
+	data      string
+}
+
+// ReplaySession plays back a recorded transcript in the local terminal
+// speed scales playback (2 = twice as fast); idle gaps are capped at 2 seconds
+// Press ESC to stop
+func ReplaySession(path string, speed float64) error {
+	if speed <= 0 {
+		speed = 1
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open session log: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	// First line is the header
+	if !scanner.Scan() {
+		return fmt.Errorf("empty session log: %s", path)
+	}
+	var header castHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Version != 2 {
+		return fmt.Errorf("not an asciicast v2 file: %s", path)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer cancelOnESC(ctx, cancel)()
+
+	fmt.Println(ui.CommandHelp("Press ESC to stop replay"))
+
+	const maxIdle = 2 * time.Second
+	last := 0.0
+
+	for scanner.Scan() {
+		event, ok := parseCastEvent(scanner.Bytes())
+		if !ok || event.direction != "o" {
+			continue
+		}
+
+		delay := time.Duration((event.time - last) / speed * float64(time.Second))
+		if delay > maxIdle {
+			delay = maxIdle
+		}
+		last = event.time
+
+		select {
+		case <-ctx.Done():
+			fmt.Print("\r\n")
+			fmt.Println(ui.Info("Replay stopped"))
+			return nil
+		case <-time.After(delay):
+		}
+
+		// WatchForCancel puts the terminal in raw mode, so restore CR before LF
+		output := strings.ReplaceAll(event.data, "\r\n", "\n")
+		os.Stdout.WriteString(strings.ReplaceAll(output, "\n", "\r\n"))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read session log: %w", err)
+	}
+
+	fmt.Print("\r\n")
+	fmt.Println(ui.Info("Replay finished"))
+	return nil
+}
+
+// parseCastEvent decodes an event line: [time, "o"|"i", "data"]
+func parseCastEvent(line []byte) (castEvent, bool) {
+	var raw []interface{}
+	if err := json.Unmarshal(line, &raw); err != nil || len(raw) != 3 {
+		return castEvent{}, false
+	}
+
+	t, ok1 := raw[0].(float64)
+	dir, ok2 := raw[1].(string)
+	data, ok3 := raw[2].(string)
+	if !ok1 || !ok2 || !ok3 {
+		return castEvent{}, false
+	}
+
+	return castEvent{time: t, direction: dir, data: data}, true
+}
@@ -0,0 +1,240 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chsoares/gummy/internal/ui"
+)
+
+// maintainPendingTTL is how long a spawned-but-not-yet-connected shell counts
+// towards a target's session count
+const maintainPendingTTL = 15 * time.Second
+
+// maintainTokenPrefix prefixes the callback tokens of shells spawned by maintain mode
+// The token tells them apart from sessions the user opened in the meantime
+const maintainTokenPrefix = "mnt"
+
+// pendingSpawn is a shell spawned by maintain mode that hasn't connected yet
+type pendingSpawn struct {
+	token string
+	at    time.Time
+}
+
+// isMaintainToken reports whether a callback token was announced by a maintain spawn
+func isMaintainToken(token string) bool {
+	return strings.HasPrefix(token, maintainTokenPrefix)
+}
+
+// maintainTarget returns the grouping key used by maintain mode (host + whoami)
+func maintainTarget(s *SessionInfo) string {
+	return s.Host() + " " + s.Whoami()
+}
+
+// SetMaintainDefault sets the global minimum number of sessions per target
+// 0 disables maintain mode for targets without a specific setting
+func (m *Manager) SetMaintainDefault(n int) {
+	m.mu.Lock()
+	m.maintainDefault = n
+	m.mu.Unlock()
+
+	m.checkAllMaintain()
+}
+
+// SetMaintainTarget sets the minimum number of sessions for the target of a session
+// A negative value removes the per-target setting (falls back to the global one)
+func (m *Manager) SetMaintainTarget(numID int, n int) error {
+	var session *SessionInfo
+	for _, s := range m.GetAllSessions() {
+		if s.NumID == numID {
+			session = s
+			break
+		}
+	}
+	if session == nil {
+		return fmt.Errorf("session %d not found", numID)
+	}
+
+	m.mu.Lock()
+	if n < 0 {
+		delete(m.maintainTargets, maintainTarget(session))
+	} else {
+		m.maintainTargets[maintainTarget(session)] = n
+	}
+	m.mu.Unlock()
+
+	m.checkAllMaintain()
+	return nil
+}
+
+// maintainRequiredLocked returns the minimum session count for a target
+// Caller must hold m.mu
+func (m *Manager) maintainRequiredLocked(target string) int {
+	if n, exists := m.maintainTargets[target]; exists {
+		return n
+	}
+	return m.maintainDefault
+}
+
+// pendingMaintainLocked drops expired pending spawns and returns how many remain
+// Caller must hold m.mu
+func (m *Manager) pendingMaintainLocked(target string) int {
+	var alive []pendingSpawn
+	for _, spawn := range m.maintainPending[target] {
+		if time.Since(spawn.at) < maintainPendingTTL {
+			alive = append(alive, spawn)
+		}
+	}
+
+	if len(alive) == 0 {
+		delete(m.maintainPending, target)
+	} else {
+		m.maintainPending[target] = alive
+	}
+	return len(alive)
+}
+
+// resolveMaintainPending marks the spawn that announced token as arrived
+// (called once its session is registered, so it's never counted as missing)
+func (m *Manager) resolveMaintainPending(token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for target, pending := range m.maintainPending {
+		for i, spawn := range pending {
+			if spawn.token == token {
+				m.maintainPending[target] = append(pending[:i:i], pending[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// checkAllMaintain runs a maintain check for every known target
+func (m *Manager) checkAllMaintain() {
+	targets := make(map[string]bool)
+	for _, s := range m.GetAllSessions() {
+		// Sessions still being detected get their check from AddSession
+		if s.Detected() {
+			targets[maintainTarget(s)] = true
+		}
+	}
+
+	for target := range targets {
+		go m.checkMaintain(target)
+	}
+}
+
+// checkMaintain spawns new shells through a surviving sibling session
+// when a target has fewer sessions than required
+func (m *Manager) checkMaintain(target string) {
+	m.mu.Lock()
+
+	required := m.maintainRequiredLocked(target)
+	if required <= 0 {
+		m.mu.Unlock()
+		return
+	}
+
+	var siblings []*SessionInfo
+	for _, s := range m.sessions {
+		if maintainTarget(s) == target {
+			siblings = append(siblings, s)
+		}
+	}
+
+	deficit := required - len(siblings) - m.pendingMaintainLocked(target)
+	if deficit <= 0 {
+		m.mu.Unlock()
+		return
+	}
+
+	if len(siblings) == 0 {
+		m.mu.Unlock()
+		m.notify(ui.Warning(fmt.Sprintf("Maintain: no surviving session for %s, cannot respawn", target)))
+		return
+	}
+
+	// The session the user is interacting with is never used
+	sort.Slice(siblings, func(i, j int) bool {
+		return siblings[i].NumID < siblings[j].NumID
+	})
+	var source *SessionInfo
+	for _, s := range siblings {
+		if s.Conn != m.activeConn {
+			source = s
+			break
+		}
+	}
+	if source == nil {
+		m.mu.Unlock()
+		m.notify(ui.Warning(fmt.Sprintf("Maintain: only session %d is left for %s and it's in use, respawning when you leave it",
+			siblings[0].NumID, target)))
+		return
+	}
+
+	spawns := make([]pendingSpawn, deficit)
+	for i := range spawns {
+		spawns[i] = pendingSpawn{token: maintainTokenPrefix + generateSessionID()[:12], at: time.Now()}
+	}
+	m.maintainPending[target] = append(m.maintainPending[target], spawns...)
+	m.mu.Unlock()
+
+	m.notify(ui.Info(fmt.Sprintf("Maintain: spawning %d session(s) for %s through session %d",
+		deficit, target, source.NumID)))
+
+	for _, spawn := range spawns {
+		if _, err := m.spawnFrom(source, 0, spawn.token); err != nil {
+			m.notify(ui.Error(fmt.Sprintf("Maintain: spawn failed: %v", err)))
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// ShowMaintain displays maintain settings and current session counts per target
+func (m *Manager) ShowMaintain() {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, s := range m.sessions {
+		if s.Detected() {
+			counts[maintainTarget(s)]++
+		}
+	}
+	for target := range m.maintainTargets {
+		if _, exists := counts[target]; !exists {
+			counts[target] = 0
+		}
+	}
+
+	targets := make([]string, 0, len(counts))
+	for target := range counts {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	var lines []string
+	if m.maintainDefault > 0 {
+		lines = append(lines, ui.Command(fmt.Sprintf("Global minimum: %d session(s) per target", m.maintainDefault)))
+	} else {
+		lines = append(lines, ui.Command("Global minimum: disabled"))
+	}
+
+	if len(targets) > 0 {
+		lines = append(lines, "")
+		lines = append(lines, ui.TableHeader("target                                    sessions  keep"))
+		for _, target := range targets {
+			keep := "-"
+			if n := m.maintainRequiredLocked(target); n > 0 {
+				keep = fmt.Sprintf("%d", n)
+			}
+			lines = append(lines, ui.Command(fmt.Sprintf("%-41s %-9d %s", target, counts[target], keep)))
+		}
+	}
+
+	fmt.Println(ui.BoxWithTitle(fmt.Sprintf("%s Maintain", ui.SymbolGem), lines))
+}
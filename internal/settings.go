@@ -0,0 +1,531 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/chsoares/gummy/internal/ui"
+)
+
+// Settings
+//
+// ~/.gummy/config.toml sets the defaults of the command-line flags and of the
+// tunables below; [profiles.<name>] tables override them per engagement
+// (-profile <name> or "set profile <name>"). Flags win over both:
+//
+//	port = 4444
+//	interface = "tun0"           # or ip = "10.10.14.2" (interface wins if both are set)
+//	viewer = "auto"
+//	terminal = "kitty"           # preferred emulator for module output ("" = first found)
+//	monitor_interval = "5s"      # session health checks
+//	script_timeout = "10m"       # scripts run by modules
+//	binary_timeout = "5m"        # binaries run by modules
+//	chunk_size = 32768           # file transfer chunk size
+//	control = false              # open a control channel for new sessions
+//
+//	[modules]                    # module URL overrides (e.g. a local mirror)
+//	linpeas = "http://10.10.14.2/linpeas.sh"
+//
+//	[profiles.htb]
+//	interface = "tun0"
+//	port = 9001
+//
+// "get [key]" shows the settings and "set [--save] <key> <value>" changes one
+// live; --save also writes it to the file (in the active profile's table).
+
+// Settings holds the configurable behaviour of gummy
+type Settings struct {
+	Port            int               `toml:"port"`
+	Interface       string            `toml:"interface"`
+	IP              string            `toml:"ip"`
+	Viewer          string            `toml:"viewer"`
+	Terminal        string            `toml:"terminal"`
+	MonitorInterval time.Duration     `toml:"monitor_interval"`
+	ScriptTimeout   time.Duration     `toml:"script_timeout"`
+	BinaryTimeout   time.Duration     `toml:"binary_timeout"`
+	ChunkSize       int               `toml:"chunk_size"`
+	Control         bool              `toml:"control"`
+	Modules         map[string]string `toml:"modules"` // Module name -> URL override
+}
+
+// settingsFile is the layout of config.toml
+type settingsFile struct {
+	Settings
+	Profiles map[string]toml.Primitive `toml:"profiles"`
+}
+
+// DefaultSettings returns the built-in settings
+func DefaultSettings() Settings {
+	return Settings{
+		Port:            4444,
+		Viewer:          ViewerAuto,
+		MonitorInterval: 5 * time.Second,
+		ScriptTimeout:   defaultScriptTimeout,
+		BinaryTimeout:   defaultBinaryTimeout,
+		ChunkSize:       32768, // 32KB chunks (safe for most shells)
+	}
+}
+
+var (
+	settingsMu      sync.Mutex
+	settings        = DefaultSettings()
+	settingsProfile string
+)
+
+// SettingsPath returns the configuration file
+func SettingsPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".gummy", "config.toml")
+}
+
+// LoadSettings reads config.toml over the defaults, then the profile (if any)
+// A missing file is not an error, a missing profile is
+func LoadSettings(profile string) (Settings, error) {
+	s := DefaultSettings()
+
+	var file settingsFile
+	file.Settings = s
+	md, err := toml.DecodeFile(SettingsPath(), &file)
+	if err != nil && !os.IsNotExist(err) {
+		return s, fmt.Errorf("invalid %s: %w", SettingsPath(), err)
+	}
+	s = file.Settings
+
+	if profile != "" {
+		table, ok := file.Profiles[profile]
+		if !ok {
+			return s, fmt.Errorf("profile %q not found in %s", profile, SettingsPath())
+		}
+		// Only the keys the profile sets are overwritten (module URLs are merged)
+		s.Modules = copyModules(s.Modules)
+		if err := md.PrimitiveDecode(table, &s); err != nil {
+			return s, fmt.Errorf("invalid profile %q: %w", profile, err)
+		}
+	}
+
+	if err := s.validate(); err != nil {
+		return s, fmt.Errorf("%s: %w", SettingsPath(), err)
+	}
+	return s, nil
+}
+
+// ApplySettings makes s the current settings
+func ApplySettings(s Settings, profile string) error {
+	s.Viewer = strings.ToLower(strings.TrimSpace(s.Viewer))
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	settingsMu.Lock()
+	settings = s
+	settingsProfile = profile
+	settingsMu.Unlock()
+	return nil
+}
+
+// CurrentSettings returns the settings in use
+// The Modules map is shared, treat it as read-only
+func CurrentSettings() Settings {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	return settings
+}
+
+// SettingsProfile returns the active profile ("" if none)
+func SettingsProfile() string {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	return settingsProfile
+}
+
+// validate checks values that would break gummy at runtime
+func (s *Settings) validate() error {
+	if s.Port < 1 || s.Port > 65535 {
+		return fmt.Errorf("invalid port: %d", s.Port)
+	}
+	if err := validViewerMode(s.Viewer); err != nil {
+		return err
+	}
+	if s.Terminal != "" && !knownTerminal(s.Terminal) {
+		return fmt.Errorf("unknown terminal %q", s.Terminal)
+	}
+	for name, d := range map[string]time.Duration{
+		"monitor_interval": s.MonitorInterval,
+		"script_timeout":   s.ScriptTimeout,
+		"binary_timeout":   s.BinaryTimeout,
+	} {
+		if d < time.Second {
+			return fmt.Errorf("%s must be at least 1s (use a duration like \"30s\" or \"5m\")", name)
+		}
+	}
+	if s.ChunkSize < 1024 {
+		return fmt.Errorf("chunk_size must be at least 1024")
+	}
+	for name := range s.Modules {
+		if _, ok := moduleURLs[name]; !ok {
+			return fmt.Errorf("unknown module URL %q (%s)", name, strings.Join(moduleURLNames(), ", "))
+		}
+	}
+	return nil
+}
+
+// copyModules returns a copy of a module URL map
+func copyModules(modules map[string]string) map[string]string {
+	copied := make(map[string]string, len(modules))
+	for name, url := range modules {
+		copied[name] = url
+	}
+	return copied
+}
+
+// moduleURLs names the built-in module URLs that can be overridden in [modules]
+var moduleURLs = map[string]string{
+	"linpeas":   URL_LINPEAS,
+	"lse":       URL_LSE,
+	"deepce":    URL_DEEPCE,
+	"loot":      URL_LOOT,
+	"pspy64":    URL_PSPY64,
+	"pspy32":    URL_PSPY32,
+	"winpeas":   URL_WINPEAS,
+	"powerup":   URL_POWERUP,
+	"lazagne":   URL_LAZAGNE,
+	"sharpup":   URL_SHARPUP,
+	"powerview": URL_POWERVIEW,
+}
+
+// moduleURLNames returns the names of the overridable module URLs, sorted
+func moduleURLNames() []string {
+	names := make([]string, 0, len(moduleURLs))
+	for name := range moduleURLs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// moduleURL returns the configured URL for a built-in module URL (url itself if not overridden)
+func moduleURL(url string) string {
+	modules := CurrentSettings().Modules
+	for name, builtin := range moduleURLs {
+		if builtin == url {
+			if override := modules[name]; override != "" {
+				return override
+			}
+			break
+		}
+	}
+	return url
+}
+
+// settingKey is a key of the set/get commands
+type settingKey struct {
+	name        string
+	description string
+	startup     bool // Only read at startup
+	get         func(s *Settings) string
+	set         func(s *Settings, value string) error
+}
+
+// parseSettingInt parses an integer setting
+func parseSettingInt(value string, dst *int) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("not a number: %s", value)
+	}
+	*dst = n
+	return nil
+}
+
+// parseSettingBool parses an on/off setting
+func parseSettingBool(value string, dst *bool) error {
+	switch strings.ToLower(value) {
+	case "on", "true", "yes", "1":
+		*dst = true
+	case "off", "false", "no", "0":
+		*dst = false
+	default:
+		return fmt.Errorf("not on/off: %s", value)
+	}
+	return nil
+}
+
+// formatSettingBool shows an on/off setting
+func formatSettingBool(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// parseSettingDuration parses a duration setting ("30s", "5m")
+func parseSettingDuration(value string, dst *time.Duration) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("not a duration: %s (use e.g. 30s or 5m)", value)
+	}
+	*dst = d
+	return nil
+}
+
+// settingKeys lists the keys in the order "get" shows them
+var settingKeys = []settingKey{
+	{"port", "listener port", true,
+		func(s *Settings) string { return strconv.Itoa(s.Port) },
+		func(s *Settings, v string) error { return parseSettingInt(v, &s.Port) }},
+	{"interface", "listener interface", true,
+		func(s *Settings) string { return s.Interface },
+		func(s *Settings, v string) error { s.Interface = v; return nil }},
+	{"ip", "listener IP", true,
+		func(s *Settings) string { return s.IP },
+		func(s *Settings, v string) error { s.IP = v; return nil }},
+	{"viewer", "module output viewer", false,
+		func(s *Settings) string { return s.Viewer },
+		func(s *Settings, v string) error { s.Viewer = strings.ToLower(v); return nil }},
+	{"terminal", "preferred terminal emulator", false,
+		func(s *Settings) string { return s.Terminal },
+		func(s *Settings, v string) error { s.Terminal = v; return nil }},
+	{"monitor_interval", "session health check interval", false,
+		func(s *Settings) string { return s.MonitorInterval.String() },
+		func(s *Settings, v string) error { return parseSettingDuration(v, &s.MonitorInterval) }},
+	{"script_timeout", "time limit of module scripts", false,
+		func(s *Settings) string { return s.ScriptTimeout.String() },
+		func(s *Settings, v string) error { return parseSettingDuration(v, &s.ScriptTimeout) }},
+	{"binary_timeout", "time limit of module binaries", false,
+		func(s *Settings) string { return s.BinaryTimeout.String() },
+		func(s *Settings, v string) error { return parseSettingDuration(v, &s.BinaryTimeout) }},
+	{"chunk_size", "file transfer chunk size", false,
+		func(s *Settings) string { return strconv.Itoa(s.ChunkSize) },
+		func(s *Settings, v string) error { return parseSettingInt(v, &s.ChunkSize) }},
+	{"control", "control channels for new sessions", false,
+		func(s *Settings) string { return formatSettingBool(s.Control) },
+		func(s *Settings, v string) error { return parseSettingBool(v, &s.Control) }},
+}
+
+// findSettingKey returns the key named name; "modules.<name>" keys are built on demand
+func findSettingKey(name string) (settingKey, bool) {
+	for _, k := range settingKeys {
+		if k.name == name {
+			return k, true
+		}
+	}
+
+	module, ok := strings.CutPrefix(name, "modules.")
+	if !ok {
+		return settingKey{}, false
+	}
+	if _, ok := moduleURLs[module]; !ok {
+		return settingKey{}, false
+	}
+	return settingKey{
+		name:        name,
+		description: "URL of " + module,
+		get: func(s *Settings) string {
+			if url := s.Modules[module]; url != "" {
+				return url
+			}
+			return moduleURLs[module]
+		},
+		set: func(s *Settings, v string) error {
+			s.Modules = copyModules(s.Modules)
+			if v == "" || v == "default" {
+				delete(s.Modules, module)
+			} else {
+				s.Modules[module] = v
+			}
+			return nil
+		},
+	}, true
+}
+
+// saveSetting writes key = value to config.toml, in the profile's table if there is one
+// The file is rewritten, so comments are not kept
+func saveSetting(profile, key, value string) error {
+	path := SettingsPath()
+	doc := make(map[string]any)
+	if _, err := toml.DecodeFile(path, &doc); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("invalid %s: %w", path, err)
+	}
+
+	table := doc
+	if profile != "" {
+		table = subTable(subTable(doc, "profiles"), profile)
+	}
+
+	// Integers and booleans keep their type, everything else is a string ("5m", "tun0")
+	if module, ok := strings.CutPrefix(key, "modules."); ok {
+		modules := subTable(table, "modules")
+		if value == "" || value == "default" {
+			delete(modules, module)
+			if len(modules) == 0 {
+				delete(table, "modules")
+			}
+		} else {
+			modules[module] = value
+		}
+	} else if key == "port" || key == "chunk_size" {
+		table[key], _ = strconv.Atoi(value)
+	} else if key == "control" {
+		var enabled bool
+		parseSettingBool(value, &enabled)
+		table[key] = enabled
+	} else {
+		table[key] = value
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to save settings: %w", err)
+	}
+	defer f.Close()
+	return toml.NewEncoder(f).Encode(doc)
+}
+
+// subTable returns the table under key, creating it if needed
+func subTable(doc map[string]any, key string) map[string]any {
+	if table, ok := doc[key].(map[string]any); ok {
+		return table
+	}
+	table := make(map[string]any)
+	doc[key] = table
+	return table
+}
+
+// ShowSettings prints every setting with its value
+func (m *Manager) ShowSettings() {
+	s := CurrentSettings()
+	profile := SettingsProfile()
+	if profile == "" {
+		profile = "none"
+	}
+
+	lines := []string{
+		ui.CommandHelp(fmt.Sprintf("%s (profile: %s)", SettingsPath(), profile)),
+		ui.TableHeader("key                 value                          description"),
+	}
+	for _, k := range settingKeys {
+		description := k.description
+		if k.startup {
+			description += " (at startup)"
+		}
+		lines = append(lines, ui.Command(fmt.Sprintf("%-19s %-30s %s", k.name, shorten(k.get(&s), 30), description)))
+	}
+
+	lines = append(lines, "", ui.CommandHelp("module URLs"))
+	for _, name := range moduleURLNames() {
+		marker := ""
+		if s.Modules[name] != "" {
+			marker = " (custom)"
+		}
+		lines = append(lines, ui.Command(fmt.Sprintf("%-19s %s%s", "modules."+name, shorten(moduleURL(moduleURLs[name]), 70), marker)))
+	}
+
+	fmt.Println(ui.BoxWithTitle(fmt.Sprintf("%s Settings", ui.SymbolGem), lines))
+}
+
+// handleGet handles the get command
+// get        - show all settings
+// get <key>  - show one setting
+func (m *Manager) handleGet(args []string) {
+	if len(args) == 0 {
+		m.ShowSettings()
+		return
+	}
+	if args[0] == "profile" {
+		fmt.Println(SettingsProfile())
+		return
+	}
+
+	key, ok := findSettingKey(args[0])
+	if !ok {
+		fmt.Println(ui.Error(fmt.Sprintf("Unknown setting: %s (type 'get' to list them)", args[0])))
+		return
+	}
+	s := CurrentSettings()
+	fmt.Println(key.get(&s))
+}
+
+// handleSet handles the set command
+// set [--save] <key> <value>  - change a setting (and write it to config.toml)
+// set profile <name|none>     - reload the settings with another profile
+func (m *Manager) handleSet(args []string) {
+	usage := "Usage: set [--save] <key> <value> | set profile <name|none>"
+
+	save := false
+	if len(args) > 0 && args[0] == "--save" {
+		save, args = true, args[1:]
+	}
+	if len(args) < 2 {
+		fmt.Println(ui.CommandHelp(usage))
+		return
+	}
+	name, value := args[0], strings.Join(args[1:], " ")
+
+	if name == "profile" {
+		m.switchProfile(value)
+		return
+	}
+
+	key, ok := findSettingKey(name)
+	if !ok {
+		fmt.Println(ui.Error(fmt.Sprintf("Unknown setting: %s (type 'get' to list them)", name)))
+		return
+	}
+
+	s := CurrentSettings()
+	if err := key.set(&s, value); err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		return
+	}
+	if err := ApplySettings(s, SettingsProfile()); err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		return
+	}
+	fmt.Println(ui.Success(fmt.Sprintf("%s = %s", name, key.get(&s))))
+	if key.startup {
+		fmt.Println(ui.Warning("Only used at startup (use 'listeners add' for a new listener now)"))
+	}
+
+	if save {
+		if err := saveSetting(SettingsProfile(), name, value); err != nil {
+			fmt.Println(ui.Error(err.Error()))
+			return
+		}
+		fmt.Println(ui.Info(fmt.Sprintf("Saved to %s", SettingsPath())))
+	}
+}
+
+// switchProfile reloads config.toml with another profile ("none" for the base settings)
+// The listener settings are kept, they only matter at startup
+func (m *Manager) switchProfile(profile string) {
+	if profile == "none" {
+		profile = ""
+	}
+
+	s, err := LoadSettings(profile)
+	if err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		return
+	}
+	current := CurrentSettings()
+	s.Port, s.Interface, s.IP = current.Port, current.Interface, current.IP
+
+	if err := ApplySettings(s, profile); err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		return
+	}
+	if profile == "" {
+		fmt.Println(ui.Success("Using the base settings"))
+	} else {
+		fmt.Println(ui.Success(fmt.Sprintf("Using profile %s", profile)))
+	}
+}
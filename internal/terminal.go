@@ -11,53 +11,92 @@ type terminalConfig struct {
 	name      string
 	flag      string
 	extraArgs []string
+	env       string // Only usable when this variable is set
 }
 
 // terminalEmulators list ordered by priority
 var terminalEmulators = []terminalConfig{
+	// Running inside tmux: split the current window (works over SSH too)
+	{"tmux", "split-window", []string{"-d", "-v"}, "TMUX"},
+
 	// Modern terminals (PRIORITY!)
-	{"kitty", "-e", nil},                             // Kitty - GPU accelerated, user's terminal
-	{"ghostty", "-e", nil},                           // Ghostty - Mitchell's new terminal
-	{"foot", "-e", nil},                              // Foot - Wayland native
-	{"alacritty", "-e", nil},                         // Alacritty - GPU accelerated
-	{"wezterm", "start", []string{"--", "sh", "-c"}}, // WezTerm - Lua config
+	{"kitty", "-e", nil, ""},                             // Kitty - GPU accelerated, user's terminal
+	{"ghostty", "-e", nil, ""},                           // Ghostty - Mitchell's new terminal
+	{"foot", "-e", nil, ""},                              // Foot - Wayland native
+	{"alacritty", "-e", nil, ""},                         // Alacritty - GPU accelerated
+	{"wezterm", "start", []string{"--", "sh", "-c"}, ""}, // WezTerm - Lua config
 
 	// Traditional terminals
-	{"gnome-terminal", "--", nil},
-	{"konsole", "-e", nil},
-	{"xfce4-terminal", "-e", nil},
-	{"mate-terminal", "-e", nil},
-	{"terminator", "-x", nil},
-	{"xterm", "-e", nil},
-	{"urxvt", "-e", nil},
-	{"st", "-e", nil}, // Suckless terminal
+	{"gnome-terminal", "--", nil, ""},
+	{"konsole", "-e", nil, ""},
+	{"xfce4-terminal", "-e", nil, ""},
+	{"mate-terminal", "-e", nil, ""},
+	{"terminator", "-x", nil, ""},
+	{"xterm", "-e", nil, ""},
+	{"urxvt", "-e", nil, ""},
+	{"st", "-e", nil, ""}, // Suckless terminal
 }
 
-// OpenTerminal opens a new terminal window with the given command
-func OpenTerminal(command string) error {
-	var terminal string
-	var config terminalConfig
+// knownTerminal reports whether name is one of terminalEmulators
+func knownTerminal(name string) bool {
+	for _, t := range terminalEmulators {
+		if t.name == name {
+			return true
+		}
+	}
+	return false
+}
 
-	// Find first available terminal
+// findTerminal returns the first usable terminal whose name passes filter
+// The terminal set in the settings is tried first
+func findTerminal(filter func(name string) bool) (terminalConfig, bool) {
+	preferred := CurrentSettings().Terminal
+	var candidates []terminalConfig
 	for _, t := range terminalEmulators {
+		if t.name == preferred {
+			candidates = append(candidates, t)
+		}
+	}
+	candidates = append(candidates, terminalEmulators...)
+
+	for _, t := range candidates {
+		if !filter(t.name) {
+			continue
+		}
+		if t.env != "" && os.Getenv(t.env) == "" {
+			continue
+		}
 		if _, err := exec.LookPath(t.name); err == nil {
-			terminal = t.name
-			config = t
-			break
+			return t, true
 		}
 	}
+	return terminalConfig{}, false
+}
 
-	if terminal == "" {
+// OpenTerminal opens a new terminal window with the given command
+func OpenTerminal(command string) error {
+	config, ok := findTerminal(func(string) bool { return true })
+	if !ok {
 		return fmt.Errorf("no terminal emulator found. Install: kitty, alacritty, foot, or gnome-terminal")
 	}
+	return launchTerminal(config, command)
+}
+
+// launchTerminal runs command in the given terminal
+func launchTerminal(config terminalConfig, command string) error {
+	terminal := config.name
 
 	// Build command arguments
 	var args []string
 
-	// WezTerm has different structure
+	// WezTerm has different structure; tmux takes a single shell command
 	if terminal == "wezterm" {
 		args = append(args, "start", "--")
 		args = append(args, "sh", "-c", command)
+	} else if terminal == "tmux" {
+		args = append(args, config.flag)
+		args = append(args, config.extraArgs...)
+		args = append(args, command)
 	} else {
 		if config.flag != "" {
 			args = append(args, config.flag)
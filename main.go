@@ -18,6 +18,8 @@ type Config struct {
 	Host      string
 	Interface string
 	IP        string // Resolved IP (from interface or direct)
+	Viewer    string // Module output viewer (auto, terminal, tmux, inline)
+	Profile   string // Settings profile from ~/.gummy/config.toml
 }
 
 func main() {
@@ -78,6 +80,10 @@ func parseFlags() *Config {
 
 	flag.StringVar(&ipFlag, "ip", "", "IP address to bind to (alternative to -i)")
 
+	flag.StringVar(&config.Viewer, "viewer", internal.ViewerAuto, "Module output viewer: auto, terminal, tmux or inline")
+
+	flag.StringVar(&config.Profile, "profile", "", "Settings profile from ~/.gummy/config.toml")
+
 	// Custom usage message with Gummy styling
 	flag.Usage = func() {
 		// Print banner first
@@ -85,7 +91,7 @@ func parseFlags() *Config {
 		fmt.Println()
 
 		// Error message
-		fmt.Println(ui.Error("Either -i <interface> or -ip <address> is required"))
+		fmt.Println(ui.Error("Either -i <interface> or -ip <address> is required (or set in " + internal.SettingsPath() + ")"))
 		fmt.Println()
 
 		// Usage instructions without box
@@ -97,6 +103,8 @@ func parseFlags() *Config {
 		fmt.Println(ui.Command("  -i, -interface <name>    Network interface to bind to (e.g., eth0, eno1)"))
 		fmt.Println(ui.Command("  -ip <address>            IP address to bind to (alternative to -i)"))
 		fmt.Println(ui.Command("  -p, -port <number>       Port to listen on (default: 4444)"))
+		fmt.Println(ui.Command("  -viewer <mode>           Module output: auto, terminal, tmux or inline"))
+		fmt.Println(ui.Command("  -profile <name>          Settings profile from ~/.gummy/config.toml"))
 		fmt.Println()
 
 		// Available interfaces in box
@@ -105,6 +113,42 @@ func parseFlags() *Config {
 
 	flag.Parse()
 
+	// Settings from ~/.gummy/config.toml fill in the flags that weren't given
+	settings, err := internal.LoadSettings(config.Profile)
+	if err != nil {
+		fmt.Println(ui.Banner())
+		fmt.Println()
+		fmt.Println(ui.Error(err.Error()))
+		os.Exit(1)
+	}
+
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	if given["port"] || given["p"] {
+		settings.Port = config.Port
+	}
+	if given["viewer"] {
+		settings.Viewer = config.Viewer
+	}
+	if interfaceFlag != "" || ipFlag != "" {
+		settings.Interface, settings.IP = interfaceFlag, ipFlag
+	} else if settings.Interface != "" {
+		interfaceFlag = settings.Interface
+	} else {
+		ipFlag = settings.IP
+	}
+
+	// Validate the settings (viewer mode, port...) before anything starts
+	if err := internal.ApplySettings(settings, config.Profile); err != nil {
+		fmt.Println(ui.Banner())
+		fmt.Println()
+		fmt.Println(ui.Error(err.Error()))
+		os.Exit(1)
+	}
+	config.Port = settings.Port
+	config.Viewer = internal.ViewerMode()
+
 	// Validate that either interface or IP is provided
 	if interfaceFlag == "" && ipFlag == "" {
 		flag.Usage()